@@ -1,22 +1,28 @@
 package main
 
 import (
+	"context"
 	"crypto/sha256"
 	"fmt"
 	"log"
-	"net/http"
 	"os"
 	"os/signal"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/ramonvermeulen/httpfromtcp/cmd/server"
+	"github.com/ramonvermeulen/httpfromtcp/internal/client"
 	"github.com/ramonvermeulen/httpfromtcp/internal/headers"
 	"github.com/ramonvermeulen/httpfromtcp/internal/request"
 	"github.com/ramonvermeulen/httpfromtcp/internal/response"
+	"github.com/ramonvermeulen/httpfromtcp/internal/websocket"
 )
 
-const port = 42069
+const (
+	addr            = ":42069"
+	shutdownTimeout = 30 * time.Second
+)
 
 func respond200() []byte {
 	return []byte(`<html>
@@ -54,79 +60,146 @@ func respond500() []byte {
 </html>`)
 }
 
-func main() {
-	handler := func(res *response.Writer, req *request.Request) {
-		s := response.StatusOK
-		h := headers.NewHeaders()
-		body := respond200()
-
-		if req.RequestLine.RequestTarget == "/yourproblem" {
-			h.Set("Content-Type", "text/html")
-			s = response.StatusBadRequest
-			body = respond400()
-		} else if req.RequestLine.RequestTarget == "/myproblem" {
-			h.Set("Content-Type", "text/html")
-			s = response.StatusError
-			body = respond500()
-		} else if after, ok := strings.CutPrefix(req.RequestLine.RequestTarget, "/httpbin"); ok {
-			// chunked encoding example with trailers
-			proxyRes, err := http.Get(fmt.Sprintf("https://httpbin.org%s", after))
-			if err != nil {
-				s = response.StatusError
-				body = respond500()
-			} else {
-				res.WriteStatusLine(s)
-				h.Set("transfer-encoding", proxyRes.Header.Get("transfer-encoding"))
-				h.Set("Content-Type", proxyRes.Header.Get("Content-Type"))
-				h.Set("Trailer", "X-Content-Length")
-				h.Set("Trailer", "X-COntent-SHA256")
-				res.WriteHeaders(h)
-				fullBody := make([]byte, 0)
-				for {
-					data := make([]byte, 32)
-					n, err := proxyRes.Body.Read(data)
-					fullBody = append(fullBody, data[:n]...)
-					if err != nil {
-						break
-					}
-					res.WriteChunkedBody(data[:n])
-				}
-				res.WriteChunkedBodyDone(true)
-				hash := sha256.Sum256(fullBody)
-				trailer := headers.NewHeaders()
-				trailer.Set("X-Content-Length", fmt.Sprintf("%d", len(fullBody)))
-				trailer.Set("X-Content-SHA256", fmt.Sprintf("%x", hash))
-				res.WriteTrailers(trailer)
+var proxyClient = &client.Client{Timeout: 30 * time.Second}
+
+func proxyGet(ctx context.Context, url string) (*client.Response, error) {
+	req, err := client.NewRequest("GET", url, "")
+	if err != nil {
+		return nil, err
+	}
+	return proxyClient.Do(ctx, req)
+}
+
+func handleRoot(res *response.Writer, req *request.Request) {
+	res.Write(respond200())
+}
+
+func handleYourProblem(res *response.Writer, req *request.Request) {
+	res.Header().Set("Content-Type", "text/html")
+	res.SetStatus(response.StatusBadRequest)
+	res.Write(respond400())
+}
+
+func handleMyProblem(res *response.Writer, req *request.Request) {
+	res.Header().Set("Content-Type", "text/html")
+	res.SetStatus(response.StatusError)
+	res.Write(respond500())
+}
+
+// handleHttpbin is a chunked encoding example with trailers
+func handleHttpbin(res *response.Writer, req *request.Request) {
+	after, _ := strings.CutPrefix(req.RequestLine.RequestTarget, "/httpbin")
+	proxyRes, err := proxyGet(req.Context(), fmt.Sprintf("https://httpbin.org%s", after))
+	if err != nil {
+		handleMyProblem(res, req)
+		return
+	}
+	defer proxyRes.Body.Close()
+	h := res.Header()
+	res.WriteStatusLine(response.StatusOK)
+	h.Set("Transfer-Encoding", "chunked")
+	contentType, _ := proxyRes.Headers.Get("Content-Type")
+	h.Set("Content-Type", contentType)
+	h.Set("Trailer", "X-Content-Length")
+	h.Set("Trailer", "X-Content-SHA256")
+	res.WriteHeaders(h)
+	fullBody := make([]byte, 0)
+	for {
+		data := make([]byte, 32)
+		n, err := proxyRes.Body.Read(data)
+		fullBody = append(fullBody, data[:n]...)
+		if err != nil {
+			break
+		}
+		res.WriteChunkedBody(data[:n])
+		res.Flush()
+	}
+	res.WriteChunkedBodyDone(true)
+	hash := sha256.Sum256(fullBody)
+	trailer := headers.NewHeaders()
+	trailer.Set("X-Content-Length", fmt.Sprintf("%d", len(fullBody)))
+	trailer.Set("X-Content-SHA256", fmt.Sprintf("%x", hash))
+	if err := res.WriteTrailers(trailer); err != nil {
+		log.Printf("Error writing trailers: %v", err)
+	}
+}
+
+// handleVideo is a video example to show any binary data can be send
+// mkdir assets
+// curl -o assets/vim.mp4 https://storage.googleapis.com/qvault-webapp-dynamic-assets/lesson_videos/vim-vs-neovim-prime.mp4
+func handleVideo(res *response.Writer, req *request.Request) {
+	videoConent, err := os.ReadFile("assets/vim.mp4")
+	if err != nil {
+		handleMyProblem(res, req)
+		return
+	}
+	res.Header().Set("Content-Type", "video/mp4")
+	res.Write(videoConent)
+}
+
+// handleClock sends the time every second over a WebSocket until the client
+// goes away.
+func handleClock(conn *websocket.Conn, req *request.Request) {
+	go func() {
+		// Reading answers pings and notices when the client closes.
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
 				return
 			}
-		} else if req.RequestLine.RequestTarget == "/video" {
-			// video example to show any binary data can be send
-			// mkdir assets
-			// curl -o assets/vim.mp4 https://storage.googleapis.com/qvault-webapp-dynamic-assets/lesson_videos/vim-vs-neovim-prime.mp4
-			h.Set("Content-Type", "video/mp4")
-			videoConent, err := os.ReadFile("assets/vim.mp4")
-			if err != nil {
-				s = response.StatusError
-				body = respond500()
-			} else {
-				body = videoConent
+		}
+	}()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-req.Context().Done():
+			return
+		case now := <-ticker.C:
+			if err := conn.WriteMessage(websocket.TextMessage, []byte(now.Format(time.RFC3339))); err != nil {
+				return
 			}
 		}
+	}
+}
+
+func main() {
+	router := server.NewRouter()
+	router.Use(server.LogRequests(log.Default()))
+	router.Handle("", "/yourproblem", handleYourProblem)
+	router.Handle("", "/myproblem", handleMyProblem)
+	router.Get("/httpbin/{path...}", handleHttpbin)
+	router.Get("/video", handleVideo)
+	router.Get("/clock", server.WebSocket(handleClock, websocket.Options{EnableCompression: true}))
+	router.Handle("", "/{path...}", handleRoot)
 
-		res.WriteStatusLine(s)
-		h.Set("Content-Length", fmt.Sprintf("%d", len(body)))
-		res.WriteHeaders(h)
-		res.WriteBody(body)
+	errorHandler := func(res *response.Writer, err *server.RequestError) {
+		if err.StatusCode != response.StatusBadRequest {
+			server.DefaultErrorHandler(res, err)
+			return
+		}
+		res.Header().Set("Content-Type", "text/html")
+		res.SetStatus(err.StatusCode)
+		res.Write(respond400())
 	}
-	server, err := server.Serve(handler, port)
+	server, err := server.Config{
+		Handler:      router.ServeHTTP,
+		ErrorHandler: errorHandler,
+	}.ListenAndServe(addr)
 	if err != nil {
 		log.Fatalf("Error starting server: %v", err)
 	}
-	defer server.Close()
-	log.Println("Server started on port", port)
+	log.Println("Server started on", server.Addr())
 
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 	<-sigChan
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("Error shutting down server: %v", err)
+		server.Close()
+		return
+	}
 	log.Println("Server gracefully stopped")
 }
@@ -0,0 +1,35 @@
+package server
+
+import (
+	"slices"
+
+	"github.com/ramonvermeulen/httpfromtcp/internal/request"
+	"github.com/ramonvermeulen/httpfromtcp/internal/response"
+)
+
+// AuthorizeClient only lets requests through whose verified client
+// certificate is accepted by allow, other requests get a 403 Forbidden.
+// Requests without a verified certificate are always rejected.
+func AuthorizeClient(allow func(id *request.Identity) bool) Middleware {
+	return func(next Handler) Handler {
+		return func(w *response.Writer, req *request.Request) {
+			if req.Client == nil || !allow(req.Client) {
+				w.WriteStatusLine(response.StatusForbidden)
+				w.WriteHeaders(response.GetDefaultHeaders(0))
+				return
+			}
+			next(w, req)
+		}
+	}
+}
+
+// AllowClients is AuthorizeClient for a fixed list of names, a client is
+// accepted when its common name or one of its subject alternative names is
+// in names.
+func AllowClients(names ...string) Middleware {
+	return AuthorizeClient(func(id *request.Identity) bool {
+		return slices.ContainsFunc(id.Names(), func(name string) bool {
+			return slices.Contains(names, name)
+		})
+	})
+}
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log"
+	"net"
+	"time"
+)
+
+var ErrMissingHandler = errors.New("server: config has no handler")
+
+// Config describes a server. Zero values mean the defaults, so only Handler
+// has to be set.
+type Config struct {
+	Handler Handler
+	// ErrorHandler answers requests that could not be read, nil means
+	// DefaultErrorHandler.
+	ErrorHandler ErrorHandler
+
+	// ReadHeaderTimeout limits how long reading the request line and headers
+	// may take, counted from the first byte of the request. A client that is
+	// too slow gets a 408 Request Timeout response. Zero falls back to
+	// ReadTimeout, or DefaultReadHeaderTimeout when that is not set either, a
+	// negative value means no limit.
+	ReadHeaderTimeout time.Duration
+	// ReadTimeout limits how long reading a whole request, including its
+	// body, may take. Zero means no limit.
+	ReadTimeout time.Duration
+	// WriteTimeout limits how long writing a response may take, counted
+	// from the end of the request. It is also the deadline of the request
+	// context. Zero means no limit.
+	WriteTimeout time.Duration
+	// IdleTimeout is how long a keep-alive connection may wait for its next
+	// request. Zero means DefaultIdleTimeout, a negative value disables it
+	// and ReadTimeout applies instead.
+	IdleTimeout time.Duration
+	// MaxRequestsPerConn limits how many requests are served on a single
+	// connection, the last response is sent with "Connection: close". Zero
+	// means DefaultMaxRequestsPerConn, a negative value means no limit.
+	MaxRequestsPerConn int
+	// MaxBodySize limits the size of request bodies, larger ones are
+	// answered with 413 Content Too Large. Zero means DefaultMaxBodySize, a
+	// negative value means no limit.
+	MaxBodySize int
+	// MaxConcurrentStreams limits the streams an HTTP/2 client may have
+	// open on one connection, zero means http2.DefaultMaxConcurrentStreams.
+	MaxConcurrentStreams uint32
+	// DisableH2C serves cleartext connections with HTTP/1.1 only, ignoring
+	// the HTTP/2 preface and "Upgrade: h2c".
+	DisableH2C bool
+
+	// DropTrailersWithoutTE leaves the trailer section of chunked responses
+	// empty for clients that did not send "TE: trailers".
+	DropTrailersWithoutTE bool
+	// DisableDate stops adding a Date header to responses.
+	DisableDate bool
+	// ServerName is sent as the Server header of responses that don't set
+	// one, an empty name sends none.
+	ServerName string
+	// Logger receives panics in handlers, nil means the standard logger.
+	Logger *log.Logger
+
+	// TLSConfig makes the server accept TLS connections only. Certificates
+	// can be selected by server name and reloaded from disk with
+	// Certificates. The minimum version and cipher suites are taken as is,
+	// ALPN advertises "http/1.1" unless NextProtos is set. For mutual TLS
+	// set ClientAuth and ClientCAs, see LoadCertPool. The identity of a
+	// verified client is available as request.Request.Client.
+	TLSConfig *tls.Config
+}
+
+// Serve starts serving connections from l in the background, Close or
+// Shutdown stop it again. Any listener works, such as a Unix domain socket.
+func (c Config) Serve(l net.Listener) (*Server, error) {
+	if c.Handler == nil {
+		return nil, ErrMissingHandler
+	}
+	if c.ErrorHandler == nil {
+		c.ErrorHandler = DefaultErrorHandler
+	}
+	if c.ReadHeaderTimeout == 0 && c.ReadTimeout <= 0 {
+		c.ReadHeaderTimeout = DefaultReadHeaderTimeout
+	}
+	if c.IdleTimeout == 0 {
+		c.IdleTimeout = DefaultIdleTimeout
+	}
+	if c.MaxBodySize == 0 {
+		c.MaxBodySize = DefaultMaxBodySize
+	}
+	if c.MaxRequestsPerConn == 0 {
+		c.MaxRequestsPerConn = DefaultMaxRequestsPerConn
+	}
+	if c.Logger == nil {
+		c.Logger = log.Default()
+	}
+
+	if c.TLSConfig != nil {
+		cfg := c.TLSConfig.Clone()
+		if len(cfg.NextProtos) == 0 {
+			cfg.NextProtos = []string{"http/1.1"}
+		}
+		c.TLSConfig = cfg
+		l = tls.NewListener(l, cfg)
+	}
+
+	baseCtx, cancelBase := context.WithCancel(context.Background())
+	shutdownCtx, cancelShutdown := context.WithCancel(context.Background())
+	s := &Server{
+		cfg:            c,
+		listener:       l,
+		conns:          make(map[net.Conn]connState),
+		baseCtx:        baseCtx,
+		cancelBase:     cancelBase,
+		shutdownCtx:    shutdownCtx,
+		cancelShutdown: cancelShutdown,
+	}
+	go s.listen()
+	return s, nil
+}
+
+// ListenAndServe listens on the TCP address addr, such as "localhost:8080"
+// or ":0" for a free port, and serves connections from it in the background.
+// Addr reports the address that was bound.
+func (c Config) ListenAndServe(addr string) (*Server, error) {
+	if c.Handler == nil {
+		return nil, ErrMissingHandler
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return c.Serve(l)
+}
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/ramonvermeulen/httpfromtcp/internal/request"
+	"github.com/ramonvermeulen/httpfromtcp/internal/response"
+)
+
+// RequestError is passed to the ErrorHandler when a request could not be
+// read. Err is the error of the request parser, such as
+// request.ErrMalformedRequestLine, or os.ErrDeadlineExceeded when the client
+// was too slow.
+type RequestError struct {
+	StatusCode response.StatusCode
+	Err        error
+}
+
+func newRequestError(err error) *RequestError {
+	status := response.StatusBadRequest
+	switch {
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		status = response.StatusRequestTimeout
+	case errors.Is(err, request.ErrBodyTooLarge):
+		status = response.StatusContentTooLarge
+	case errors.Is(err, request.ErrUnsupportedTransferEncoding):
+		status = response.StatusNotImplemented
+	}
+	return &RequestError{StatusCode: status, Err: err}
+}
+
+func (e *RequestError) Error() string {
+	return fmt.Sprintf("%d %s: %v", e.StatusCode, response.StatusText(e.StatusCode), e.Err)
+}
+
+func (e *RequestError) Unwrap() error {
+	return e.Err
+}
+
+// ErrorHandler answers a request that could not be read, the handler is not
+// called for it. The connection is closed after the response.
+type ErrorHandler func(w *response.Writer, err *RequestError)
+
+// DefaultErrorHandler sends the status code of err with an empty body.
+func DefaultErrorHandler(w *response.Writer, err *RequestError) {
+	w.WriteStatusLine(err.StatusCode)
+	w.WriteHeaders(response.GetDefaultHeaders(0))
+}
@@ -0,0 +1,40 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"time"
+
+	"github.com/ramonvermeulen/httpfromtcp/internal/request"
+)
+
+// hijacker hands the connection of an HTTP/1.1 request to its handler
+// through response.Writer.Hijack.
+type hijacker struct {
+	s            *Server
+	conn         net.Conn
+	br           *bufio.Reader
+	stopWatching func()
+}
+
+// hijack stops the server from watching and tracking the connection and
+// removes its deadlines.
+func (h *hijacker) hijack() (net.Conn, *bufio.Reader, error) {
+	h.stopWatching()
+	h.s.untrackConn(h.conn)
+	h.conn.SetDeadline(time.Time{})
+	return h.conn, h.br, nil
+}
+
+type hijackContextKey struct{}
+
+// hijackContext returns the context for the connection req arrived on once
+// it is hijacked. Unlike the request context it has no write timeout, it ends
+// when the server shuts down or is closed.
+func hijackContext(req *request.Request) context.Context {
+	if ctx, ok := req.Context().Value(hijackContextKey{}).(context.Context); ok {
+		return ctx
+	}
+	return req.Context()
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"time"
+
+	"github.com/ramonvermeulen/httpfromtcp/internal/http2"
+	"github.com/ramonvermeulen/httpfromtcp/internal/request"
+	"github.com/ramonvermeulen/httpfromtcp/internal/response"
+)
+
+// isHTTP2Preface reports whether br starts with the HTTP/2 client preface.
+// PRI is not a method, so HTTP/1.1 clients never send it.
+func isHTTP2Preface(br *bufio.Reader) bool {
+	if p, _ := br.Peek(3); string(p) != "PRI" {
+		return false
+	}
+	p, _ := br.Peek(len(http2.ClientPreface))
+	return string(p) == http2.ClientPreface
+}
+
+// serveHTTP2 serves conn with HTTP/2 until it is closed, upgrade is the
+// HTTP/1.1 request that asked to switch or nil for a client with prior
+// knowledge. Every stream is served by the handler like an HTTP/1.1 request.
+func (s *Server) serveHTTP2(ctx context.Context, conn net.Conn, br *bufio.Reader, upgrade *request.Request) {
+	h2 := &http2.Server{
+		Handler: func(st *http2.Stream, req *request.Request) {
+			s.serveStream(conn, st, req)
+		},
+		MaxConcurrentStreams: s.cfg.MaxConcurrentStreams,
+		MaxBodySize:          s.cfg.MaxBodySize,
+		IdleTimeout:          s.idleTimeout(),
+		ConnState: func(idle bool) bool {
+			state := stateActive
+			if idle {
+				state = stateIdleHTTP2
+			}
+			return s.setConnState(conn, state) && !s.closed.Load()
+		},
+		Shutdown: s.shutdownCtx.Done(),
+	}
+	conn.SetReadDeadline(time.Time{})
+	if upgrade != nil {
+		h2.ServeUpgrade(ctx, conn, br, upgrade)
+		return
+	}
+	h2.ServeConn(ctx, conn, br)
+}
+
+// serveStream runs the handler for the request of an HTTP/2 stream. A
+// handler that panics after it started its response has its stream reset.
+func (s *Server) serveStream(conn net.Conn, st *http2.Stream, req *request.Request) {
+	ctx, cancel := context.WithCancel(req.Context())
+	if s.cfg.WriteTimeout > 0 {
+		ctx, cancel = context.WithTimeout(req.Context(), s.cfg.WriteTimeout)
+	}
+	defer cancel()
+	req = req.WithContext(ctx)
+	req.RemoteAddr = conn.RemoteAddr().String()
+	req.LocalAddr = conn.LocalAddr().String()
+
+	w := s.newStreamWriter(st, req)
+	if !s.recoverPanic(conn, func() { s.cfg.Handler(w, req) }) {
+		if w.Started() {
+			st.Reset(http2.ErrCodeInternal)
+			return
+		}
+		w = s.newStreamWriter(st, req)
+		w.WriteStatusLine(response.StatusError)
+		w.WriteHeaders(response.GetDefaultHeaders(0))
+	}
+	w.Finish()
+}
+
+func (s *Server) newStreamWriter(st *http2.Stream, req *request.Request) *response.Writer {
+	w := response.NewStreamWriter(st)
+	w.DropTrailersWithoutTE = s.cfg.DropTrailersWithoutTE
+	w.SendDate = !s.cfg.DisableDate
+	w.ServerName = s.cfg.ServerName
+	w.SetRequest(req)
+	return w
+}
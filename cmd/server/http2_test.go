@@ -0,0 +1,249 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ramonvermeulen/httpfromtcp/internal/headers"
+	"github.com/ramonvermeulen/httpfromtcp/internal/hpack"
+	"github.com/ramonvermeulen/httpfromtcp/internal/http2"
+	"github.com/ramonvermeulen/httpfromtcp/internal/request"
+	"github.com/ramonvermeulen/httpfromtcp/internal/response"
+)
+
+// h2cClient speaks HTTP/2 with prior knowledge over cleartext connections.
+func h2cClient() *http.Client {
+	var protocols http.Protocols
+	protocols.SetUnencryptedHTTP2(true)
+	return &http.Client{Transport: &http.Transport{Protocols: &protocols}}
+}
+
+func TestServe_HTTP2(t *testing.T) {
+	const parallel = 5
+	var arrived sync.WaitGroup
+	arrived.Add(parallel)
+	var mu sync.Mutex
+	remotes := map[string]bool{}
+
+	url := startServer(t, func(w *response.Writer, req *request.Request) {
+		switch req.RequestLine.RequestTarget {
+		case "/stream":
+			w.WriteStatusLine(response.StatusOK)
+			w.WriteHeaders(headers.Headers{"transfer-encoding": "chunked", "trailer": "x-parts"})
+			w.WriteChunkedBody([]byte("hello "))
+			w.Flush()
+			w.WriteChunkedBody([]byte("world"))
+			w.WriteChunkedBodyDone(true)
+			w.WriteTrailers(headers.Headers{"x-parts": "2"})
+		case "/big":
+			w.Write([]byte(strings.Repeat("a", 200000)))
+		case "/echo":
+			ct, _ := req.Headers.Get("content-type")
+			fmt.Fprintf(w, "%s %s %s %s", req.RequestLine.HTTPVersion, req.RequestLine.Method, ct, req.Body)
+		case "/parallel":
+			mu.Lock()
+			remotes[req.RemoteAddr] = true
+			mu.Unlock()
+			arrived.Done()
+			arrived.Wait()
+			w.Write([]byte("done"))
+		case "/panic":
+			panic("boom")
+		default:
+			w.SetStatus(response.StatusNotFound)
+		}
+	}, WithLogger(log.New(io.Discard, "", 0)))
+	c := h2cClient()
+
+	// Test: chunked responses become DATA frames with trailers
+	res, err := c.Get(url + "/stream")
+	require.NoError(t, err)
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, 2, res.ProtoMajor)
+	assert.Equal(t, "hello world", string(body))
+	assert.Equal(t, "2", res.Trailer.Get("X-Parts"))
+	assert.Empty(t, res.Header.Get("Transfer-Encoding"))
+
+	// Test: bodies larger than the flow control window are sent in full
+	res, err = c.Get(url + "/big")
+	require.NoError(t, err)
+	body, err = io.ReadAll(res.Body)
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, 200000, len(body))
+
+	// Test: request bodies and headers reach the handler
+	res, err = c.Post(url+"/echo", "text/plain", strings.NewReader("ping"))
+	require.NoError(t, err)
+	body, err = io.ReadAll(res.Body)
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, "2.0 POST text/plain ping", string(body))
+
+	// Test: HEAD gets the headers without a body
+	res, err = c.Head(url + "/big")
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	// Test: requests are multiplexed over one connection
+	var wg sync.WaitGroup
+	for range parallel {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := c.Get(url + "/parallel")
+			if assert.NoError(t, err) {
+				io.Copy(io.Discard, res.Body)
+				res.Body.Close()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Len(t, remotes, 1)
+
+	// Test: a panicking handler answers 500 and the connection stays usable
+	res, err = c.Get(url + "/panic")
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusInternalServerError, res.StatusCode)
+	res, err = c.Get(url + "/missing")
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+}
+
+func TestServe_H2CUpgrade(t *testing.T) {
+	url := startServer(t, func(w *response.Writer, req *request.Request) {
+		fmt.Fprintf(w, "%s %s", req.RequestLine.HTTPVersion, req.Body)
+	})
+	conn, br := dialServer(t, url)
+
+	// Test: the upgrade request is answered with 101 and then on stream 1
+	fmt.Fprint(conn, "POST / HTTP/1.1\r\nHost: localhost\r\nConnection: Upgrade, HTTP2-Settings\r\n"+
+		"Upgrade: h2c\r\nHTTP2-Settings: AAMAAABk\r\nContent-Length: 5\r\n\r\nhello")
+	line, err := br.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 101 Switching Protocols\r\n", line)
+	for line != "\r\n" {
+		line, err = br.ReadString('\n')
+		require.NoError(t, err)
+	}
+	fmt.Fprint(conn, http2.ClientPreface)
+	require.NoError(t, http2.WriteFrame(conn, http2.FrameSettings, 0, 0, nil))
+
+	status, body := readStream(t, br, 1)
+	assert.Equal(t, "200", status)
+	assert.Equal(t, "2.0 hello", body)
+
+	// Test: other requests go without the upgrade
+	res, body2 := get(t, "GET", url+"/")
+	assert.Equal(t, response.StatusOK, res.StatusLine.StatusCode)
+	assert.Equal(t, "1.1 ", body2)
+}
+
+// readStream reads frames until the response on stream id ended and returns
+// its status and body.
+func readStream(t *testing.T, br *bufio.Reader, id uint32) (string, string) {
+	t.Helper()
+	dec := hpack.NewDecoder(hpack.DefaultTableSize)
+	var status string
+	var body strings.Builder
+	for {
+		f, err := http2.ReadFrame(br, http2.DefaultMaxFrameSize)
+		require.NoError(t, err)
+		if f.StreamID != id {
+			continue
+		}
+		switch f.Type {
+		case http2.FrameHeaders:
+			fields, err := dec.Decode(f.Payload)
+			require.NoError(t, err)
+			for _, field := range fields {
+				if field.Name == ":status" {
+					status = field.Value
+				}
+			}
+		case http2.FrameData:
+			body.Write(f.Payload)
+		case http2.FrameRSTStream:
+			t.Fatalf("stream %d was reset", id)
+		}
+		if f.Flags.Has(http2.FlagEndStream) {
+			return status, body.String()
+		}
+	}
+}
+
+func TestServer_ShutdownHTTP2(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	s, err := Config{Handler: func(w *response.Writer, req *request.Request) {
+		close(started)
+		<-release
+		w.Write([]byte("finished"))
+	}}.ListenAndServe("localhost:0")
+	require.NoError(t, err)
+	defer s.Close()
+
+	// Test: Shutdown waits for open streams and then closes the connection
+	type result struct {
+		body string
+		err  error
+	}
+	done := make(chan result)
+	go func() {
+		res, err := h2cClient().Get("http://" + s.Addr().String() + "/")
+		if err != nil {
+			done <- result{err: err}
+			return
+		}
+		defer res.Body.Close()
+		body, err := io.ReadAll(res.Body)
+		done <- result{string(body), err}
+	}()
+	<-started
+	shutdown := make(chan error)
+	go func() { shutdown <- s.Shutdown(context.Background()) }()
+	close(release)
+	r := <-done
+	require.NoError(t, r.err)
+	assert.Equal(t, "finished", r.body)
+	assert.NoError(t, <-shutdown)
+
+	// Test: a connection that opened no stream gets GOAWAY and is closed
+	s, err = Config{Handler: reply("")}.ListenAndServe("localhost:0")
+	require.NoError(t, err)
+	defer s.Close()
+	conn, br := dialServer(t, "http://"+s.Addr().String())
+	fmt.Fprint(conn, http2.ClientPreface)
+	require.NoError(t, http2.WriteFrame(conn, http2.FrameSettings, 0, 0, nil))
+	for {
+		f, err := http2.ReadFrame(br, http2.DefaultMaxFrameSize)
+		require.NoError(t, err)
+		if f.Type == http2.FrameSettings && f.Flags.Has(http2.FlagAck) {
+			break
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, s.Shutdown(ctx))
+	f, err := http2.ReadFrame(br, http2.DefaultMaxFrameSize)
+	require.NoError(t, err)
+	assert.Equal(t, http2.FrameGoAway, f.Type)
+	_, err = br.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+}
@@ -1,64 +1,325 @@
 package server
 
 import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"net"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/ramonvermeulen/httpfromtcp/internal/http2"
 	"github.com/ramonvermeulen/httpfromtcp/internal/request"
 	"github.com/ramonvermeulen/httpfromtcp/internal/response"
 )
 
+// aLongTimeAgo is a deadline in the past, setting it interrupts a blocked read.
+var aLongTimeAgo = time.Unix(1, 0)
+
+const (
+	// DefaultIdleTimeout is how long a keep-alive connection may wait for
+	// its next request.
+	DefaultIdleTimeout = 2 * time.Minute
+	// DefaultMaxBodySize limits request bodies when the server sets no
+	// limit.
+	DefaultMaxBodySize = http2.DefaultMaxBodySize
+	// DefaultReadHeaderTimeout is how long reading a request head may take
+	// when neither it nor the read timeout is set.
+	DefaultReadHeaderTimeout = 10 * time.Second
+	// DefaultMaxRequestsPerConn is how many requests are served on one
+	// connection before it is closed.
+	DefaultMaxRequestsPerConn = 1000
+)
+
 type Server struct {
+	cfg      Config
 	listener net.Listener
-	handler  Handler
-	closed   bool
-}
-
-func Serve(handler Handler, port int) (*Server, error) {
-	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
-	if err != nil {
-		return nil, err
-	}
+	closed   atomic.Bool
 
-	s := &Server{
-		listener: listener,
-		handler:  handler,
-		closed:   false,
-	}
+	// baseCtx is the parent of all request contexts, it is cancelled when
+	// the server gives up on its requests.
+	baseCtx    context.Context
+	cancelBase context.CancelFunc
+	// shutdownCtx is cancelled as soon as Shutdown or Close is called, the
+	// server does not wait for hijacked connections and they end with it.
+	// HTTP/2 connections send GOAWAY.
+	shutdownCtx    context.Context
+	cancelShutdown context.CancelFunc
 
-	go s.listen()
+	mu    sync.Mutex
+	conns map[net.Conn]connState
+}
 
-	return s, nil
+// Serve listens on port and serves connections with handler in the
+// background, see Config for more control over the server.
+func Serve(handler Handler, port int, opts ...Option) (*Server, error) {
+	cfg := Config{Handler: handler}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg.ListenAndServe(fmt.Sprintf(":%d", port))
 }
 
-func (s *Server) Close() error {
-	s.closed = true
-	return nil
+// Addr returns the address the server listens on.
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
 }
 
 func (s *Server) listen() {
 	for {
 		conn, err := s.listener.Accept()
-		if s.closed {
+		if err != nil {
 			return
 		}
-		if err != nil {
+		if !s.trackConn(conn) {
+			conn.Close()
 			return
 		}
 		go s.handle(conn)
 	}
 }
 
+// handle serves requests on conn until the client or a response asks to
+// close it, the connection stays idle for too long or it served the maximum
+// number of requests. Request bodies are always read completely, so the next
+// request starts right after the previous one. Cleartext connections switch
+// to HTTP/2 when they start with its preface or ask for "Upgrade: h2c". A
+// connection hijacked by a handler is left alone from then on.
 func (s *Server) handle(conn net.Conn) {
-	defer conn.Close()
-	resWriter := response.NewWriter(conn)
-	req, err := request.RequestFromReader(conn)
+	hijacked := false
+	defer func() {
+		if !hijacked {
+			conn.Close()
+			s.untrackConn(conn)
+		}
+	}()
+	connCtx, cancelConn := context.WithCancel(s.baseCtx)
+	defer cancelConn()
+	hijackCtx, cancelHijack := context.WithCancel(connCtx)
+	defer cancelHijack()
+	defer context.AfterFunc(s.shutdownCtx, cancelHijack)()
+	var tlsState *tls.ConnectionState
+	var identity *request.Identity
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		if err := s.handshake(connCtx, tlsConn); err != nil {
+			s.logHandshakeError(conn, err)
+			return
+		}
+		state := tlsConn.ConnectionState()
+		tlsState = &state
+		if len(state.VerifiedChains) > 0 {
+			identity = request.NewIdentity(state.VerifiedChains[0][0])
+		}
+	}
+	br := bufio.NewReader(conn)
+	for served := 1; ; served++ {
+		if !s.setConnState(conn, stateIdle) {
+			return
+		}
+		if d := s.idleTimeout(); d > 0 {
+			conn.SetReadDeadline(time.Now().Add(d))
+		} else {
+			conn.SetReadDeadline(time.Time{})
+		}
+		if _, err := br.Peek(1); err != nil {
+			return
+		}
+		if !s.setConnState(conn, stateActive) {
+			return
+		}
+		if served == 1 && tlsState == nil && !s.cfg.DisableH2C && isHTTP2Preface(br) {
+			s.serveHTTP2(connCtx, conn, br, nil)
+			return
+		}
+
+		resWriter := s.newWriter(conn)
+		var req *request.Request
+		var err error
+		if !s.recoverPanic(conn, func() { req, err = s.readRequest(conn, br) }) {
+			s.writeError(conn, nil)
+			return
+		}
+		ctx, cancel := s.requestContext(connCtx, conn)
+		hj := &hijacker{s: s, conn: conn, br: br}
+		resWriter.SetHijacker(hj.hijack)
+		serve := func() { s.cfg.Handler(resWriter, req) }
+		if err != nil {
+			reqErr := newRequestError(err)
+			serve = func() { s.cfg.ErrorHandler(resWriter, reqErr) }
+			resWriter.CloseAfterResponse()
+		} else if tlsState == nil && !s.cfg.DisableH2C && http2.IsUpgrade(req) {
+			cancel()
+			s.serveHTTP2(connCtx, conn, br, req)
+			return
+		} else {
+			req = req.WithContext(context.WithValue(ctx, hijackContextKey{}, hijackCtx))
+			req.RemoteAddr = conn.RemoteAddr().String()
+			req.LocalAddr = conn.LocalAddr().String()
+			req.TLS = tlsState
+			req.Client = identity
+			resWriter.SetRequest(req)
+		}
+		if s.cfg.MaxRequestsPerConn > 0 && served >= s.cfg.MaxRequestsPerConn {
+			resWriter.CloseAfterResponse()
+		}
+		hj.stopWatching = watchClose(conn, br, cancel)
+		ok := s.recoverPanic(conn, serve)
+		if resWriter.Hijacked() {
+			cancel()
+			hijacked = true
+			return
+		}
+		hj.stopWatching()
+		cancel()
+		if !ok {
+			if !resWriter.Started() {
+				s.writeError(conn, req)
+			}
+			return
+		}
+		if s.closed.Load() {
+			resWriter.CloseAfterResponse()
+		}
+		if err := resWriter.Finish(); err != nil || !resWriter.KeepAlive() {
+			return
+		}
+	}
+}
+
+func (s *Server) newWriter(conn net.Conn) *response.Writer {
+	w := response.NewWriter(conn)
+	w.DropTrailersWithoutTE = s.cfg.DropTrailersWithoutTE
+	w.SendDate = !s.cfg.DisableDate
+	w.ServerName = s.cfg.ServerName
+	return w
+}
+
+// recoverPanic runs fn and recovers from a panic in it, logging the stack
+// trace. It reports whether fn returned normally.
+func (s *Server) recoverPanic(conn net.Conn, fn func()) (ok bool) {
+	defer func() {
+		if v := recover(); v != nil {
+			s.cfg.Logger.Printf("server: panic serving %s: %v\n%s", conn.RemoteAddr(), v, debug.Stack())
+			ok = false
+		}
+	}()
+	fn()
+	return true
+}
+
+// writeError answers req with a 500 after the handler failed before it
+// started its response. Whatever the handler buffered is dropped and the
+// connection is closed afterwards.
+func (s *Server) writeError(conn net.Conn, req *request.Request) {
+	w := s.newWriter(conn)
+	if req != nil {
+		w.SetRequest(req)
+	}
+	w.CloseAfterResponse()
+	w.WriteStatusLine(response.StatusError)
+	w.WriteHeaders(response.GetDefaultHeaders(0))
+	w.Finish()
+}
+
+// handshake runs the TLS handshake of conn, it has to complete within the
+// read header timeout or, if there is none, the idle timeout.
+func (s *Server) handshake(ctx context.Context, conn *tls.Conn) error {
+	timeout := s.cfg.ReadHeaderTimeout
+	if timeout <= 0 {
+		timeout = s.idleTimeout()
+	}
+	if timeout > 0 {
+		conn.SetDeadline(time.Now().Add(timeout))
+		defer conn.SetDeadline(time.Time{})
+	}
+	return conn.HandshakeContext(ctx)
+}
+
+// logHandshakeError logs why a TLS handshake failed, calling out client
+// certificates that could not be verified.
+func (s *Server) logHandshakeError(conn net.Conn, err error) {
+	var verifyErr *tls.CertificateVerificationError
+	if errors.As(err, &verifyErr) {
+		subject := "unknown"
+		if len(verifyErr.UnverifiedCertificates) > 0 {
+			subject = verifyErr.UnverifiedCertificates[0].Subject.String()
+		}
+		s.cfg.Logger.Printf("server: client certificate of %s (subject %q) rejected: %v", conn.RemoteAddr(), subject, verifyErr.Err)
+		return
+	}
+	s.cfg.Logger.Printf("server: TLS handshake error from %s: %v", conn.RemoteAddr(), err)
+}
+
+// requestContext returns the context for the next request on conn and
+// applies the write timeout, which becomes the deadline of the context.
+func (s *Server) requestContext(connCtx context.Context, conn net.Conn) (context.Context, context.CancelFunc) {
+	if s.cfg.WriteTimeout <= 0 {
+		return context.WithCancel(connCtx)
+	}
+	deadline := time.Now().Add(s.cfg.WriteTimeout)
+	conn.SetWriteDeadline(deadline)
+	return context.WithDeadline(connCtx, deadline)
+}
+
+// watchClose cancels the request context when the client closes the
+// connection while the handler runs. The request was read completely, so the
+// read only returns early for a closed connection or a pipelined request.
+// The returned function stops watching.
+func watchClose(conn net.Conn, br *bufio.Reader, cancel context.CancelFunc) func() {
+	var stopping atomic.Bool
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if _, err := br.Peek(1); err != nil && !stopping.Load() {
+			cancel()
+		}
+	}()
+	return func() {
+		stopping.Store(true)
+		conn.SetReadDeadline(aLongTimeAgo)
+		<-done
+		conn.SetReadDeadline(time.Time{})
+	}
+}
+
+// readRequest reads the next request from br. The head has to arrive within
+// the read header timeout and the whole request within the read timeout, both
+// counted from when the request started.
+func (s *Server) readRequest(conn net.Conn, br *bufio.Reader) (*request.Request, error) {
+	start := time.Now()
+	var bodyDeadline time.Time
+	if s.cfg.ReadTimeout > 0 {
+		bodyDeadline = start.Add(s.cfg.ReadTimeout)
+	}
+	headDeadline := bodyDeadline
+	if s.cfg.ReadHeaderTimeout > 0 {
+		headDeadline = start.Add(s.cfg.ReadHeaderTimeout)
+	}
+
+	conn.SetReadDeadline(headDeadline)
+	req, err := request.RequestHeadFromReader(br)
 	if err != nil {
-		headers := response.GetDefaultHeaders(0)
-		resWriter.WriteStatusLine(response.StatusBadRequest)
-		resWriter.WriteHeaders(headers)
+		return nil, err
+	}
+	conn.SetReadDeadline(bodyDeadline)
+	if err := req.ReadBody(br, s.cfg.MaxBodySize); err != nil {
+		return nil, err
+	}
+	conn.SetReadDeadline(time.Time{})
+	return req, nil
+}
+
+// idleTimeout returns the idle timeout, falling back to the read timeout
+// when it is disabled.
+func (s *Server) idleTimeout() time.Duration {
+	if s.cfg.IdleTimeout > 0 {
+		return s.cfg.IdleTimeout
 	}
-	s.handler(resWriter, req)
+	return s.cfg.ReadTimeout
 }
 
 type Handler func(w *response.Writer, req *request.Request)
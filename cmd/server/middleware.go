@@ -0,0 +1,42 @@
+package server
+
+import (
+	"log"
+	"time"
+
+	"github.com/ramonvermeulen/httpfromtcp/internal/request"
+	"github.com/ramonvermeulen/httpfromtcp/internal/response"
+)
+
+// Middleware wraps a Handler with logic that runs around it, such as
+// logging or authentication. It can observe the response through the
+// OnHeaders and OnWrite hooks of response.Writer, or answer the request
+// itself without calling next.
+type Middleware func(next Handler) Handler
+
+// Chain combines middleware into one, the first one is the outermost and
+// sees the request first.
+func Chain(mws ...Middleware) Middleware {
+	return func(next Handler) Handler {
+		for i := len(mws) - 1; i >= 0; i-- {
+			next = mws[i](next)
+		}
+		return next
+	}
+}
+
+// LogRequests logs the method, target, status code, body size and duration
+// of every request once its handler returned.
+func LogRequests(logger *log.Logger) Middleware {
+	return func(next Handler) Handler {
+		return func(w *response.Writer, req *request.Request) {
+			start := time.Now()
+			written := 0
+			w.OnWrite(func(p []byte) {
+				written += len(p)
+			})
+			next(w, req)
+			logger.Printf("%s %s %d %dB %s", req.RequestLine.Method, req.RequestLine.RequestTarget, w.Status(), written, time.Since(start))
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"bytes"
+	"log"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ramonvermeulen/httpfromtcp/internal/headers"
+	"github.com/ramonvermeulen/httpfromtcp/internal/request"
+	"github.com/ramonvermeulen/httpfromtcp/internal/response"
+)
+
+func tag(name string, order *[]string) Middleware {
+	return func(next Handler) Handler {
+		return func(w *response.Writer, req *request.Request) {
+			*order = append(*order, name)
+			w.OnHeaders(func(status response.StatusCode, h headers.Headers) {
+				h.Set("X-Tag", name)
+			})
+			next(w, req)
+		}
+	}
+}
+
+func TestChain(t *testing.T) {
+	var order []string
+	r := NewRouter()
+	r.Use(Chain(tag("outer", &order), tag("inner", &order)))
+	r.Get("/", func(w *response.Writer, req *request.Request) {
+		order = append(order, "handler")
+		w.Write([]byte("hello"))
+	})
+
+	// Test: middleware runs outermost first and can change headers
+	res := serveRoute(t, r, "GET", "/", "localhost")
+	assert.Equal(t, []string{"outer", "inner", "handler"}, order)
+	h, _ := res.Headers.Get("x-tag")
+	assert.Equal(t, "outer, inner", h)
+
+	// Test: middleware also runs for unmatched requests
+	order = nil
+	res = serveRoute(t, r, "GET", "/nope", "localhost")
+	assert.Equal(t, response.StatusNotFound, res.StatusLine.StatusCode)
+	assert.Equal(t, []string{"outer", "inner"}, order)
+
+	// Test: middleware can answer without calling next
+	deny := func(next Handler) Handler {
+		return func(w *response.Writer, req *request.Request) {
+			w.SetStatus(response.StatusMethodNotAllowed)
+		}
+	}
+	res = serveRoute(t, &Router{middleware: []Middleware{deny}}, "GET", "/", "localhost")
+	assert.Equal(t, response.StatusMethodNotAllowed, res.StatusLine.StatusCode)
+}
+
+func TestLogRequests(t *testing.T) {
+	logs := &bytes.Buffer{}
+	r := NewRouter()
+	r.Use(LogRequests(log.New(logs, "", 0)))
+	r.Get("/hello", reply("hello world"))
+
+	// Test: method, target, status and body size are logged
+	serveRoute(t, r, "GET", "/hello?x=1", "localhost")
+	assert.Contains(t, logs.String(), "GET /hello?x=1 200 11B ")
+	logs.Reset()
+	serveRoute(t, r, "POST", "/hello", "localhost")
+	assert.Contains(t, logs.String(), "POST /hello 405 0B ")
+}
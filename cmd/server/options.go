@@ -0,0 +1,103 @@
+package server
+
+import (
+	"log"
+	"time"
+)
+
+// Option changes the Config of a server started with Serve.
+type Option func(*Config)
+
+// WithDropTrailersWithoutTE leaves the trailer section of chunked responses
+// empty for clients that did not send "TE: trailers".
+func WithDropTrailersWithoutTE(drop bool) Option {
+	return func(c *Config) {
+		c.DropTrailersWithoutTE = drop
+	}
+}
+
+// WithDateHeader controls whether responses get a Date header, it is on by
+// default. Handlers can still send their own Date header.
+func WithDateHeader(enabled bool) Option {
+	return func(c *Config) {
+		c.DisableDate = !enabled
+	}
+}
+
+// WithServerHeader sets the Server header sent with every response that
+// doesn't set one, an empty name sends none.
+func WithServerHeader(name string) Option {
+	return func(c *Config) {
+		c.ServerName = name
+	}
+}
+
+// WithReadHeaderTimeout limits how long reading the request line and
+// headers may take, counted from the first byte of the request. A client
+// that is too slow gets a 408 Request Timeout response. Zero falls back to
+// the read timeout, or DefaultReadHeaderTimeout when that is not set either,
+// a negative value means no limit.
+func WithReadHeaderTimeout(d time.Duration) Option {
+	return func(c *Config) {
+		c.ReadHeaderTimeout = d
+	}
+}
+
+// WithReadTimeout limits how long reading a whole request, including its
+// body, may take. Zero means no limit.
+func WithReadTimeout(d time.Duration) Option {
+	return func(c *Config) {
+		c.ReadTimeout = d
+	}
+}
+
+// WithWriteTimeout limits how long writing a response may take, counted from
+// the end of the request. Zero means no limit.
+func WithWriteTimeout(d time.Duration) Option {
+	return func(c *Config) {
+		c.WriteTimeout = d
+	}
+}
+
+// WithIdleTimeout sets how long a keep-alive connection may wait for its
+// next request before it is closed. Zero means DefaultIdleTimeout, a
+// negative value disables it and the read timeout applies instead.
+func WithIdleTimeout(d time.Duration) Option {
+	return func(c *Config) {
+		c.IdleTimeout = d
+	}
+}
+
+// WithMaxRequestsPerConn limits how many requests are served on a single
+// connection, the last response is sent with "Connection: close". Zero means
+// DefaultMaxRequestsPerConn, a negative value means no limit.
+func WithMaxRequestsPerConn(n int) Option {
+	return func(c *Config) {
+		c.MaxRequestsPerConn = n
+	}
+}
+
+// WithMaxBodySize limits the size of request bodies, larger ones are
+// answered with 413 Content Too Large. Zero means DefaultMaxBodySize, a
+// negative value means no limit.
+func WithMaxBodySize(n int) Option {
+	return func(c *Config) {
+		c.MaxBodySize = n
+	}
+}
+
+// WithLogger sets where the server logs panics in handlers, it defaults to
+// the standard logger.
+func WithLogger(logger *log.Logger) Option {
+	return func(c *Config) {
+		c.Logger = logger
+	}
+}
+
+// WithErrorHandler sets the handler for requests that could not be read, by
+// default DefaultErrorHandler sends the status code with an empty body.
+func WithErrorHandler(h ErrorHandler) Option {
+	return func(c *Config) {
+		c.ErrorHandler = h
+	}
+}
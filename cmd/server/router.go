@@ -0,0 +1,356 @@
+package server
+
+import (
+	"fmt"
+	"maps"
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/ramonvermeulen/httpfromtcp/internal/request"
+	"github.com/ramonvermeulen/httpfromtcp/internal/response"
+)
+
+// Router dispatches requests to handlers by method, host and path, it is
+// used as the Handler of a server.
+//
+// A pattern is a path, optionally preceded by a host, such as "/users/{id}"
+// or "api.example.com/users/{id}". A segment in braces matches any single
+// path segment and is available through request.Request.Param. A last
+// segment written as "{name...}" or "*" matches the rest of the path. When
+// several routes match, literal segments win over parameters and parameters
+// win over wildcards, routes for a specific host win over the others.
+//
+// A path without a matching route gets a 404, a path that only matches
+// routes for other methods gets a 405 with an Allow header. HEAD requests are
+// served by GET routes and OPTIONS requests are answered automatically,
+// unless a route handles them itself.
+type Router struct {
+	// NotFound handles requests no route matches, nil sends an empty 404.
+	NotFound Handler
+
+	routes     []*route
+	mounts     []*mount
+	middleware []Middleware
+}
+
+type route struct {
+	method   string
+	host     string
+	segments []segment
+	handler  Handler
+}
+
+type segment struct {
+	literal  string
+	param    string
+	wildcard bool
+}
+
+type mount struct {
+	prefix  []string
+	handler Handler
+}
+
+func NewRouter() *Router {
+	return &Router{}
+}
+
+// Handle registers h for requests with method and a path matching pattern,
+// an empty method matches every method. It panics when the pattern is
+// invalid.
+func (r *Router) Handle(method, pattern string, h Handler) {
+	host, segments, err := parsePattern(pattern)
+	if err != nil {
+		panic(err)
+	}
+	r.routes = append(r.routes, &route{
+		method:   method,
+		host:     host,
+		segments: segments,
+		handler:  h,
+	})
+}
+
+func (r *Router) Get(pattern string, h Handler) {
+	r.Handle("GET", pattern, h)
+}
+
+func (r *Router) Post(pattern string, h Handler) {
+	r.Handle("POST", pattern, h)
+}
+
+func (r *Router) Put(pattern string, h Handler) {
+	r.Handle("PUT", pattern, h)
+}
+
+func (r *Router) Delete(pattern string, h Handler) {
+	r.Handle("DELETE", pattern, h)
+}
+
+// Use adds middleware that runs for every request the router handles,
+// including those answered with a 404 or 405.
+func (r *Router) Use(mws ...Middleware) {
+	r.middleware = append(r.middleware, mws...)
+}
+
+// Mount passes requests below prefix that no route matches to h, with prefix
+// removed from the request target. A Router can be mounted to group routes.
+func (r *Router) Mount(prefix string, h Handler) {
+	r.mounts = append(r.mounts, &mount{prefix: splitPath(prefix), handler: h})
+	slices.SortStableFunc(r.mounts, func(a, b *mount) int {
+		return len(b.prefix) - len(a.prefix)
+	})
+}
+
+// ServeHTTP makes the router usable as a Handler, pass r.ServeHTTP to the
+// server.
+func (r *Router) ServeHTTP(w *response.Writer, req *request.Request) {
+	if len(r.middleware) > 0 {
+		Chain(r.middleware...)(r.serve)(w, req)
+		return
+	}
+	r.serve(w, req)
+}
+
+func (r *Router) serve(w *response.Writer, req *request.Request) {
+	path, query := requestPath(req)
+	host := requestHost(req)
+	segments := splitPath(path)
+
+	var best *route
+	var bestParams map[string]string
+	matched := false
+	var allowed []string
+	for _, rt := range r.routes {
+		if rt.host != "" && rt.host != host {
+			continue
+		}
+		params, ok := rt.match(segments)
+		if !ok {
+			continue
+		}
+		matched = true
+		if rt.method != "" {
+			allowed = append(allowed, rt.method)
+		}
+		if !rt.allows(req.RequestLine.Method) {
+			continue
+		}
+		if best == nil || rt.moreSpecific(best) {
+			best, bestParams = rt, params
+		}
+	}
+
+	if best != nil {
+		req.Params = mergeParams(req.Params, bestParams)
+		best.handler(w, req)
+		return
+	}
+	if matched {
+		allow := allowHeader(allowed)
+		if req.RequestLine.Method == "OPTIONS" {
+			w.Header().Set("Allow", allow)
+			w.SetStatus(response.StatusNoContent)
+			return
+		}
+		h := response.GetDefaultHeaders(0)
+		h.Set("Allow", allow)
+		w.WriteStatusLine(response.StatusMethodNotAllowed)
+		w.WriteHeaders(h)
+		return
+	}
+
+	for _, m := range r.mounts {
+		if rest, ok := cutPrefix(segments, m.prefix); ok {
+			sub := *req
+			sub.RequestLine.RequestTarget = "/" + strings.Join(rest, "/") + query
+			m.handler(w, &sub)
+			return
+		}
+	}
+
+	if r.NotFound != nil {
+		r.NotFound(w, req)
+		return
+	}
+	w.WriteStatusLine(response.StatusNotFound)
+	w.WriteHeaders(response.GetDefaultHeaders(0))
+}
+
+func (rt *route) allows(method string) bool {
+	return rt.method == "" || rt.method == method || rt.method == "GET" && method == "HEAD"
+}
+
+// match reports whether the path segments match the route and returns the
+// path parameters.
+func (rt *route) match(path []string) (map[string]string, bool) {
+	params := map[string]string{}
+	for i, seg := range rt.segments {
+		if seg.wildcard {
+			params[seg.param] = strings.Join(path[i:], "/")
+			return params, true
+		}
+		if i >= len(path) {
+			return nil, false
+		}
+		if seg.param != "" {
+			if path[i] == "" {
+				return nil, false
+			}
+			params[seg.param] = path[i]
+			continue
+		}
+		if seg.literal != path[i] {
+			return nil, false
+		}
+	}
+	if len(path) != len(rt.segments) {
+		return nil, false
+	}
+	return params, true
+}
+
+// moreSpecific reports whether rt takes precedence over other when both
+// match a request.
+func (rt *route) moreSpecific(other *route) bool {
+	for i := 0; i < len(rt.segments) && i < len(other.segments); i++ {
+		a, b := rt.segments[i].rank(), other.segments[i].rank()
+		if a != b {
+			return a > b
+		}
+	}
+	// With the shared segments tied, the longer route only matched through
+	// a trailing wildcard that matched nothing, the exact match wins.
+	if len(rt.segments) != len(other.segments) {
+		return len(rt.segments) < len(other.segments)
+	}
+	if (rt.host != "") != (other.host != "") {
+		return rt.host != ""
+	}
+	return rt.method != "" && other.method == ""
+}
+
+func (s segment) rank() int {
+	switch {
+	case s.wildcard:
+		return 0
+	case s.param != "":
+		return 1
+	default:
+		return 2
+	}
+}
+
+func parsePattern(pattern string) (string, []segment, error) {
+	i := strings.Index(pattern, "/")
+	if i < 0 {
+		return "", nil, fmt.Errorf("router: pattern %q has no path", pattern)
+	}
+	host := strings.ToLower(pattern[:i])
+
+	var segments []segment
+	parts := splitPath(pattern[i:])
+	for j, part := range parts {
+		last := j == len(parts)-1
+		switch {
+		case part == "*":
+			if !last {
+				return "", nil, fmt.Errorf("router: wildcard in %q is not the last segment", pattern)
+			}
+			segments = append(segments, segment{param: "*", wildcard: true})
+		case strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}"):
+			name := part[1 : len(part)-1]
+			name, wildcard := strings.CutSuffix(name, "...")
+			if name == "" {
+				return "", nil, fmt.Errorf("router: empty parameter name in %q", pattern)
+			}
+			if wildcard && !last {
+				return "", nil, fmt.Errorf("router: wildcard in %q is not the last segment", pattern)
+			}
+			segments = append(segments, segment{param: name, wildcard: wildcard})
+		case strings.ContainsAny(part, "{}"):
+			return "", nil, fmt.Errorf("router: invalid segment %q in %q", part, pattern)
+		default:
+			segments = append(segments, segment{literal: part})
+		}
+	}
+	return host, segments, nil
+}
+
+// splitPath splits a path into its unescaped segments, the root path has
+// none.
+func splitPath(path string) []string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return nil
+	}
+	parts := strings.Split(path, "/")
+	for i, part := range parts {
+		if unescaped, err := url.PathUnescape(part); err == nil {
+			parts[i] = unescaped
+		}
+	}
+	return parts
+}
+
+// requestPath returns the path of the request target and its query,
+// including the question mark.
+func requestPath(req *request.Request) (string, string) {
+	target := req.RequestLine.RequestTarget
+	if !strings.HasPrefix(target, "/") {
+		if u, err := url.Parse(target); err == nil && u.Host != "" {
+			target = u.RequestURI()
+		}
+	}
+	path, query, ok := strings.Cut(target, "?")
+	if ok {
+		query = "?" + query
+	}
+	return path, query
+}
+
+// requestHost returns the host the request is for without the port.
+func requestHost(req *request.Request) string {
+	host, _ := req.Headers.Get("host")
+	// Only an absolute-form target names the host, "//host/path" is a path.
+	if target := req.RequestLine.RequestTarget; !strings.HasPrefix(target, "/") {
+		if u, err := url.Parse(target); err == nil && u.Host != "" {
+			host = u.Host
+		}
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(host)
+}
+
+func cutPrefix(path, prefix []string) ([]string, bool) {
+	if len(path) < len(prefix) || !slices.Equal(path[:len(prefix)], prefix) {
+		return nil, false
+	}
+	return path[len(prefix):], true
+}
+
+// mergeParams returns the parameters of dst and src, dst is copied so a
+// mounted router does not change the request of the outer one.
+func mergeParams(dst, src map[string]string) map[string]string {
+	if dst == nil {
+		return src
+	}
+	dst = maps.Clone(dst)
+	maps.Copy(dst, src)
+	return dst
+}
+
+// allowHeader lists the methods of the matching routes, adding HEAD for GET
+// and OPTIONS, which the router answers itself.
+func allowHeader(methods []string) string {
+	if slices.Contains(methods, "GET") {
+		methods = append(methods, "HEAD")
+	}
+	methods = append(methods, "OPTIONS")
+	slices.Sort(methods)
+	return strings.Join(slices.Compact(methods), ", ")
+}
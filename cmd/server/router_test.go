@@ -0,0 +1,150 @@
+package server
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ramonvermeulen/httpfromtcp/internal/headers"
+	"github.com/ramonvermeulen/httpfromtcp/internal/request"
+	"github.com/ramonvermeulen/httpfromtcp/internal/response"
+)
+
+func serveRoute(t *testing.T, r *Router, method, target, host string) *response.Response {
+	t.Helper()
+	req := request.NewRequest()
+	req.RequestLine = request.RequestLine{Method: method, RequestTarget: target, HTTPVersion: "1.1"}
+	req.Headers = headers.Headers{"host": host}
+	out := &bytes.Buffer{}
+	w := response.NewWriter(out)
+	w.SetRequest(req)
+	r.ServeHTTP(w, req)
+	require.NoError(t, w.Finish())
+	res, err := response.ResponseFromReader(out, method)
+	require.NoError(t, err)
+	return res
+}
+
+func reply(body string) Handler {
+	return func(w *response.Writer, req *request.Request) {
+		w.Write([]byte(body))
+	}
+}
+
+func TestRouter(t *testing.T) {
+	r := NewRouter()
+	r.Get("/", reply("root"))
+	r.Get("/users/{id}", func(w *response.Writer, req *request.Request) {
+		w.Write([]byte("user " + req.Param("id")))
+	})
+	r.Get("/users/me", reply("me"))
+	r.Delete("/users/{id}", reply("deleted"))
+	r.Get("/files/{path...}", func(w *response.Writer, req *request.Request) {
+		w.Write([]byte("file " + req.Param("path")))
+	})
+	r.Handle("", "/any", reply("any"))
+	r.Get("api.example.com/users/{id}", reply("api user"))
+
+	// Test: literal, parameter and root routes
+	assert.Equal(t, "root", serveRoute(t, r, "GET", "/", "localhost").Body)
+	assert.Equal(t, "user 42", serveRoute(t, r, "GET", "/users/42?x=1", "localhost").Body)
+	assert.Equal(t, "me", serveRoute(t, r, "GET", "/users/me", "localhost").Body)
+	assert.Equal(t, "user a b", serveRoute(t, r, "GET", "/users/a%20b", "localhost").Body)
+
+	// Test: method matching, HEAD is served by GET routes
+	assert.Equal(t, "deleted", serveRoute(t, r, "DELETE", "/users/42", "localhost").Body)
+	res := serveRoute(t, r, "HEAD", "/users/42", "localhost")
+	assert.Equal(t, response.StatusOK, res.StatusLine.StatusCode)
+	assert.Equal(t, "any", serveRoute(t, r, "POST", "/any", "localhost").Body)
+
+	// Test: wildcards match the rest of the path
+	assert.Equal(t, "file a/b/c.txt", serveRoute(t, r, "GET", "/files/a/b/c.txt", "localhost").Body)
+	assert.Equal(t, "file ", serveRoute(t, r, "GET", "/files", "localhost").Body)
+
+	// Test: an exact match wins over a wildcard that matches nothing
+	r.Get("/files", reply("files"))
+	assert.Equal(t, "files", serveRoute(t, r, "GET", "/files", "localhost").Body)
+	assert.Equal(t, "file a", serveRoute(t, r, "GET", "/files/a", "localhost").Body)
+
+	// Test: host specific routes win
+	assert.Equal(t, "api user", serveRoute(t, r, "GET", "/users/42", "API.example.com:8080").Body)
+	assert.Equal(t, "api user", serveRoute(t, r, "GET", "http://api.example.com/users/42", "").Body)
+
+	// Test: an origin-form target starting with "//" does not name the host
+	r.Get("admin.internal/{path...}", reply("admin"))
+	res = serveRoute(t, r, "GET", "//admin.internal/secret", "public.example")
+	assert.Equal(t, response.StatusNotFound, res.StatusLine.StatusCode)
+
+	// Test: unknown path gets 404
+	res = serveRoute(t, r, "GET", "/nope", "localhost")
+	assert.Equal(t, response.StatusNotFound, res.StatusLine.StatusCode)
+	res = serveRoute(t, r, "GET", "/users/42/posts", "localhost")
+	assert.Equal(t, response.StatusNotFound, res.StatusLine.StatusCode)
+
+	// Test: wrong method gets 405 with Allow
+	res = serveRoute(t, r, "POST", "/users/42", "localhost")
+	assert.Equal(t, response.StatusMethodNotAllowed, res.StatusLine.StatusCode)
+	h, _ := res.Headers.Get("allow")
+	assert.Equal(t, "DELETE, GET, HEAD, OPTIONS", h)
+
+	// Test: OPTIONS is answered automatically
+	res = serveRoute(t, r, "OPTIONS", "/users/me", "localhost")
+	assert.Equal(t, response.StatusNoContent, res.StatusLine.StatusCode)
+	h, _ = res.Headers.Get("allow")
+	assert.Equal(t, "DELETE, GET, HEAD, OPTIONS", h)
+
+	// Test: custom NotFound handler
+	r.NotFound = func(w *response.Writer, req *request.Request) {
+		w.SetStatus(response.StatusNotFound)
+		w.Write([]byte("custom"))
+	}
+	assert.Equal(t, "custom", serveRoute(t, r, "GET", "/nope", "localhost").Body)
+}
+
+func TestRouter_Mount(t *testing.T) {
+	api := NewRouter()
+	api.Get("/items/{id}", func(w *response.Writer, req *request.Request) {
+		w.Write([]byte(req.RequestLine.RequestTarget + " " + req.Param("version") + " " + req.Param("id")))
+	})
+	r := NewRouter()
+	r.Get("/api/{version}/status", reply("status"))
+	r.Mount("/api", func(w *response.Writer, req *request.Request) {
+		w.Write([]byte("api " + req.RequestLine.RequestTarget))
+	})
+	r.Mount("/api/v1", api.ServeHTTP)
+
+	// Test: routes win over mounts
+	assert.Equal(t, "status", serveRoute(t, r, "GET", "/api/v2/status", "localhost").Body)
+
+	// Test: longest prefix wins and is stripped from the target
+	assert.Equal(t, "/items/7?full=1  7", serveRoute(t, r, "GET", "/api/v1/items/7?full=1", "localhost").Body)
+	assert.Equal(t, "api /v2/other", serveRoute(t, r, "GET", "/api/v2/other", "localhost").Body)
+
+	// Test: prefix only matches whole segments
+	res := serveRoute(t, r, "GET", "/apiary", "localhost")
+	assert.Equal(t, response.StatusNotFound, res.StatusLine.StatusCode)
+
+	// Test: parameters of a mounted router do not leak into the outer request
+	req := request.NewRequest()
+	req.RequestLine = request.RequestLine{Method: "GET", RequestTarget: "/api/v1/items/7", HTTPVersion: "1.1"}
+	req.Params = map[string]string{"outer": "x"}
+	w := response.NewWriter(&bytes.Buffer{})
+	w.SetRequest(req)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, map[string]string{"outer": "x"}, req.Params)
+}
+
+func TestRouter_InvalidPattern(t *testing.T) {
+	r := NewRouter()
+
+	// Test: wildcard must be the last segment
+	assert.Panics(t, func() { r.Get("/files/{path...}/raw", reply("")) })
+	assert.Panics(t, func() { r.Get("/files/*/raw", reply("")) })
+
+	// Test: pattern needs a path and named parameters
+	assert.Panics(t, func() { r.Get("example.com", reply("")) })
+	assert.Panics(t, func() { r.Get("/users/{}", reply("")) })
+	assert.Panics(t, func() { r.Get("/users/x{id}", reply("")) })
+}
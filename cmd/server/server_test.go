@@ -0,0 +1,683 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ramonvermeulen/httpfromtcp/internal/client"
+	"github.com/ramonvermeulen/httpfromtcp/internal/headers"
+	"github.com/ramonvermeulen/httpfromtcp/internal/request"
+	"github.com/ramonvermeulen/httpfromtcp/internal/response"
+)
+
+func startServer(t *testing.T, handler Handler, opts ...Option) string {
+	t.Helper()
+	s, err := Serve(handler, 0, opts...)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	return "http://" + s.Addr().String()
+}
+
+func get(t *testing.T, method, url string) (*client.Response, string) {
+	t.Helper()
+	req, err := client.NewRequest(method, url, "")
+	require.NoError(t, err)
+	res, err := (&client.Client{}).Do(context.Background(), req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	return res, string(body)
+}
+
+func TestServe(t *testing.T) {
+	url := startServer(t, func(w *response.Writer, req *request.Request) {
+		switch req.RequestLine.RequestTarget {
+		case "/stream":
+			w.WriteStatusLine(response.StatusOK)
+			w.WriteHeaders(headers.Headers{"transfer-encoding": "chunked", "trailer": "x-parts"})
+			w.WriteChunkedBody([]byte("hello "))
+			w.Flush()
+			w.WriteChunkedBody([]byte("world"))
+			w.WriteChunkedBodyDone(true)
+			w.WriteTrailers(headers.Headers{"x-parts": "2"})
+		case "/big":
+			w.Write([]byte(strings.Repeat("a", 10000)))
+		default:
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte("hello world"))
+		}
+	}, WithServerHeader("httpfromtcp"))
+
+	// Test: small body is sent with Content-Length
+	res, body := get(t, "GET", url+"/")
+	assert.Equal(t, response.StatusOK, res.StatusLine.StatusCode)
+	assert.Equal(t, "hello world", body)
+	h, _ := res.Headers.Get("content-length")
+	assert.Equal(t, "11", h)
+	h, _ = res.Headers.Get("server")
+	assert.Equal(t, "httpfromtcp", h)
+	_, ok := res.Headers.Get("date")
+	assert.True(t, ok)
+
+	// Test: large body is chunked
+	res, body = get(t, "GET", url+"/big")
+	assert.Equal(t, strings.Repeat("a", 10000), body)
+	h, _ = res.Headers.Get("transfer-encoding")
+	assert.Equal(t, "chunked", h)
+
+	// Test: explicit chunked body with trailers
+	res, body = get(t, "GET", url+"/stream")
+	assert.Equal(t, "hello world", body)
+	h, _ = res.Trailers().Get("x-parts")
+	assert.Equal(t, "2", h)
+
+	// Test: HEAD gets the GET headers without a body
+	res, body = get(t, "HEAD", url+"/")
+	assert.Empty(t, body)
+	h, _ = res.Headers.Get("content-length")
+	assert.Equal(t, "11", h)
+}
+
+func TestServe_ClientRedirectsAndCookies(t *testing.T) {
+	url := startServer(t, func(w *response.Writer, req *request.Request) {
+		switch req.RequestLine.RequestTarget {
+		case "/login":
+			w.Header().Set("Set-Cookie", "session=abc; Path=/; Max-Age=60")
+			w.Header().Set("Location", "/home")
+			w.SetStatus(response.StatusSeeOther)
+		case "/home":
+			cookie, _ := req.Headers.Get("cookie")
+			w.Write([]byte("cookie: " + cookie))
+		}
+	})
+
+	c := &client.Client{Jar: client.NewJar()}
+	req, err := client.NewRequest("POST", url+"/login", "user=me")
+	require.NoError(t, err)
+	res, err := c.Do(context.Background(), req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	assert.Equal(t, response.StatusOK, res.StatusLine.StatusCode)
+	assert.Equal(t, "cookie: session=abc", string(body))
+}
+
+func dialServer(t *testing.T, url string) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	conn, err := net.Dial("tcp", strings.TrimPrefix(url, "http://"))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn, bufio.NewReader(conn)
+}
+
+func readResponse(t *testing.T, br *bufio.Reader) *response.Response {
+	t.Helper()
+	res, err := response.ResponseHeadFromReader(br, "GET")
+	require.NoError(t, err)
+	body, err := io.ReadAll(res.BodyReader(br))
+	require.NoError(t, err)
+	res.Body = string(body)
+	return res
+}
+
+func TestServe_KeepAlive(t *testing.T) {
+	echo := func(w *response.Writer, req *request.Request) {
+		w.Write([]byte(req.RequestLine.RequestTarget + " " + req.Body))
+	}
+
+	// Test: sequential and pipelined requests share a connection
+	url := startServer(t, echo)
+	conn, br := dialServer(t, url)
+	fmt.Fprint(conn, "GET /a HTTP/1.1\r\nHost: x\r\n\r\n")
+	res := readResponse(t, br)
+	assert.Equal(t, "/a ", res.Body)
+	fmt.Fprint(conn, "POST /b HTTP/1.1\r\nHost: x\r\nContent-Length: 3\r\n\r\nabcGET /c HTTP/1.1\r\nHost: x\r\n\r\n")
+	assert.Equal(t, "/b abc", readResponse(t, br).Body)
+	assert.Equal(t, "/c ", readResponse(t, br).Body)
+
+	// Test: Connection: close is honored
+	fmt.Fprint(conn, "GET /d HTTP/1.1\r\nHost: x\r\nConnection: close\r\n\r\n")
+	res = readResponse(t, br)
+	h, _ := res.Headers.Get("connection")
+	assert.Equal(t, "close", h)
+	_, err := br.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+
+	// Test: connection is closed after the maximum number of requests
+	url = startServer(t, echo, WithMaxRequestsPerConn(2))
+	conn, br = dialServer(t, url)
+	fmt.Fprint(conn, "GET /a HTTP/1.1\r\nHost: x\r\n\r\nGET /b HTTP/1.1\r\nHost: x\r\n\r\n")
+	res = readResponse(t, br)
+	_, ok := res.Headers.Get("connection")
+	assert.False(t, ok)
+	res = readResponse(t, br)
+	h, _ = res.Headers.Get("connection")
+	assert.Equal(t, "close", h)
+	_, err = br.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+
+	// Test: idle connections are closed
+	url = startServer(t, echo, WithIdleTimeout(50*time.Millisecond))
+	conn, br = dialServer(t, url)
+	fmt.Fprint(conn, "GET /a HTTP/1.1\r\nHost: x\r\n\r\n")
+	readResponse(t, br)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = br.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+
+	// Test: a chunked body is decoded and the next request follows it
+	conn, br = dialServer(t, url)
+	fmt.Fprint(conn, "POST /e HTTP/1.1\r\nHost: x\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabc\r\n0\r\n\r\n"+
+		"GET /f HTTP/1.1\r\nHost: x\r\n\r\n")
+	assert.Equal(t, "/e abc", readResponse(t, br).Body)
+	assert.Equal(t, "/f ", readResponse(t, br).Body)
+
+	// Test: requests a proxy could frame differently are rejected
+	for _, tc := range []struct {
+		head   string
+		status response.StatusCode
+	}{
+		{"Transfer-Encoding: chunked\r\nContent-Length: 3\r\n", response.StatusBadRequest},
+		{"Content-Length: 3\r\nContent-Length: 4\r\n", response.StatusBadRequest},
+		{"Content-Length: -5\r\n", response.StatusBadRequest},
+		{"Transfer-Encoding: gzip, chunked\r\n", response.StatusNotImplemented},
+	} {
+		conn, br = dialServer(t, url)
+		fmt.Fprint(conn, "POST /g HTTP/1.1\r\nHost: x\r\n"+tc.head+"\r\n3\r\nabc\r\n0\r\n\r\nGET /h HTTP/1.1\r\n\r\n")
+		res = readResponse(t, br)
+		assert.Equal(t, tc.status, res.StatusLine.StatusCode, tc.head)
+		_, err = br.ReadByte()
+		assert.ErrorIs(t, err, io.EOF, tc.head)
+	}
+
+	// Test: a request cut off by the client is answered with 400 and served
+	// no further
+	var served atomic.Int32
+	url = startServer(t, func(w *response.Writer, req *request.Request) {
+		served.Add(1)
+	})
+	for _, partial := range []string{"GE", "GET /x HTTP/1.1\r\nHost: x\r\n"} {
+		conn, br = dialServer(t, url)
+		fmt.Fprint(conn, "GET /a HTTP/1.1\r\nHost: x\r\n\r\n"+partial)
+		conn.(*net.TCPConn).CloseWrite()
+		assert.Equal(t, response.StatusOK, readResponse(t, br).StatusLine.StatusCode)
+		assert.Equal(t, response.StatusBadRequest, readResponse(t, br).StatusLine.StatusCode)
+		_, err = br.ReadByte()
+		assert.ErrorIs(t, err, io.EOF)
+	}
+	assert.Equal(t, int32(2), served.Load())
+
+	// Test: malformed request gets 400 and closes the connection
+	url = startServer(t, func(w *response.Writer, req *request.Request) {})
+	conn, br = dialServer(t, url)
+	fmt.Fprint(conn, "BROKEN\r\n\r\n")
+	res = readResponse(t, br)
+	assert.Equal(t, response.StatusBadRequest, res.StatusLine.StatusCode)
+	_, err = br.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestServer_Close(t *testing.T) {
+	started := make(chan struct{})
+	s, err := Serve(func(w *response.Writer, req *request.Request) {
+		close(started)
+		time.Sleep(time.Second)
+	}, 0)
+	require.NoError(t, err)
+	url := "http://" + s.Addr().String()
+
+	// Test: in-flight and idle connections are closed, listener stops accepting
+	idle, idleBr := dialServer(t, url)
+	conn, br := dialServer(t, url)
+	fmt.Fprint(conn, "GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+	<-started
+	require.NoError(t, s.Close())
+	conn.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+	_, err = br.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+	idle.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+	_, err = idleBr.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+	_, err = net.Dial("tcp", s.Addr().String())
+	assert.Error(t, err)
+}
+
+func TestServer_Shutdown(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	s, err := Serve(func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.RequestTarget == "/slow" {
+			close(started)
+			<-release
+		}
+		w.Write([]byte("done"))
+	}, 0)
+	require.NoError(t, err)
+	url := "http://" + s.Addr().String()
+
+	idle, idleBr := dialServer(t, url)
+	fmt.Fprint(idle, "GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+	readResponse(t, idleBr)
+	conn, br := dialServer(t, url)
+	fmt.Fprint(conn, "GET /slow HTTP/1.1\r\nHost: x\r\n\r\n")
+	<-started
+
+	// Test: context expires while a handler is still running
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, s.Shutdown(ctx), context.DeadlineExceeded)
+
+	// Test: idle connection was closed, listener stops accepting
+	_, err = idleBr.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+	_, err = net.Dial("tcp", s.Addr().String())
+	assert.Error(t, err)
+
+	// Test: active request completes with Connection: close
+	done := make(chan error)
+	go func() { done <- s.Shutdown(context.Background()) }()
+	close(release)
+	res := readResponse(t, br)
+	assert.Equal(t, "done", res.Body)
+	h, _ := res.Headers.Get("connection")
+	assert.Equal(t, "close", h)
+	require.NoError(t, <-done)
+	_, err = br.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestServe_MaxBodySize(t *testing.T) {
+	echo := func(w *response.Writer, req *request.Request) {
+		w.Write([]byte(req.Body))
+	}
+	url := startServer(t, echo, WithMaxBodySize(4))
+
+	// Test: a body over the limit gets 413 and the connection is closed
+	conn, br := dialServer(t, url)
+	fmt.Fprint(conn, "POST / HTTP/1.1\r\nHost: x\r\nContent-Length: 5\r\n\r\nhello")
+	res := readResponse(t, br)
+	assert.Equal(t, response.StatusContentTooLarge, res.StatusLine.StatusCode)
+	_, err := br.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+
+	// Test: a body within the limit is served
+	conn, br = dialServer(t, url)
+	fmt.Fprint(conn, "POST / HTTP/1.1\r\nHost: x\r\nContent-Length: 4\r\n\r\nhell")
+	res = readResponse(t, br)
+	assert.Equal(t, "hell", res.Body)
+
+	// Test: the limit applies to HTTP/2 as well
+	h2res, err := h2cClient().Post(url+"/", "text/plain", strings.NewReader("hello"))
+	require.NoError(t, err)
+	h2res.Body.Close()
+	assert.Equal(t, 413, h2res.StatusCode)
+}
+
+func TestServe_Timeouts(t *testing.T) {
+	echo := func(w *response.Writer, req *request.Request) {
+		w.Write([]byte(req.Body))
+	}
+
+	// Test: slow request head gets 408 and the connection is closed
+	url := startServer(t, echo, WithReadHeaderTimeout(50*time.Millisecond))
+	conn, br := dialServer(t, url)
+	fmt.Fprint(conn, "GET / HTTP/1.1\r\nHost: x\r\n")
+	res := readResponse(t, br)
+	assert.Equal(t, response.StatusRequestTimeout, res.StatusLine.StatusCode)
+	_, err := br.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+
+	// Test: the head is limited even when no timeout is set
+	s, err := Config{Handler: echo}.ListenAndServe("localhost:0")
+	require.NoError(t, err)
+	defer s.Close()
+	assert.Equal(t, DefaultReadHeaderTimeout, s.cfg.ReadHeaderTimeout)
+
+	// Test: slow request body gets 408
+	url = startServer(t, echo, WithReadHeaderTimeout(time.Second), WithReadTimeout(100*time.Millisecond))
+	conn, br = dialServer(t, url)
+	fmt.Fprint(conn, "POST / HTTP/1.1\r\nHost: x\r\nContent-Length: 5\r\n\r\nhe")
+	res = readResponse(t, br)
+	assert.Equal(t, response.StatusRequestTimeout, res.StatusLine.StatusCode)
+
+	// Test: a request within the timeouts is served, idle falls back to the read timeout
+	url = startServer(t, echo, WithReadTimeout(100*time.Millisecond), WithIdleTimeout(-1))
+	conn, br = dialServer(t, url)
+	fmt.Fprint(conn, "POST / HTTP/1.1\r\nHost: x\r\nContent-Length: 5\r\n\r\nhello")
+	res = readResponse(t, br)
+	assert.Equal(t, "hello", res.Body)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = br.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+
+	// Test: client that never sends anything is disconnected
+	url = startServer(t, echo, WithIdleTimeout(50*time.Millisecond))
+	conn, br = dialServer(t, url)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = br.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+
+	// Test: handler exceeding the write timeout has its connection cut off
+	url = startServer(t, func(w *response.Writer, req *request.Request) {
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte("late"))
+	}, WithWriteTimeout(20*time.Millisecond))
+	conn, br = dialServer(t, url)
+	fmt.Fprint(conn, "GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = br.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestServe_Panic(t *testing.T) {
+	logs := &syncBuffer{}
+	url := startServer(t, func(w *response.Writer, req *request.Request) {
+		switch req.RequestLine.RequestTarget {
+		case "/early":
+			w.Write([]byte("partial"))
+			panic("boom early")
+		case "/late":
+			w.Write([]byte("partial"))
+			w.Flush()
+			panic("boom late")
+		default:
+			w.Write([]byte("fine"))
+		}
+	}, WithLogger(log.New(logs, "", 0)))
+
+	// Test: panic before the response started sends a 500
+	conn, br := dialServer(t, url)
+	fmt.Fprint(conn, "GET /early HTTP/1.1\r\nHost: x\r\n\r\n")
+	res := readResponse(t, br)
+	assert.Equal(t, response.StatusError, res.StatusLine.StatusCode)
+	assert.NotContains(t, res.Body, "partial")
+	h, _ := res.Headers.Get("connection")
+	assert.Equal(t, "close", h)
+	assert.Contains(t, logs.String(), "boom early")
+	assert.Contains(t, logs.String(), "goroutine")
+
+	// Test: panic after the response started aborts the connection
+	conn, br = dialServer(t, url)
+	fmt.Fprint(conn, "GET /late HTTP/1.1\r\nHost: x\r\n\r\n")
+	head, err := response.ResponseHeadFromReader(br, "GET")
+	require.NoError(t, err)
+	assert.Equal(t, response.StatusOK, head.StatusLine.StatusCode)
+	_, err = io.ReadAll(head.BodyReader(br))
+	assert.ErrorIs(t, err, response.ErrIncompleteResponse)
+	assert.Contains(t, logs.String(), "boom late")
+
+	// Test: server keeps serving other connections
+	_, body := get(t, "GET", url+"/")
+	assert.Equal(t, "fine", body)
+}
+
+// panicConn panics when a read returns data containing "PANIC", like a
+// parser bug triggered by the bytes a client sent.
+type panicConn struct {
+	net.Conn
+}
+
+func (c panicConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if bytes.Contains(p[:n], []byte("PANIC")) {
+		panic("boom parsing")
+	}
+	return n, err
+}
+
+type panicListener struct {
+	net.Listener
+}
+
+func (l panicListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return panicConn{conn}, nil
+}
+
+func TestServe_ParsePanic(t *testing.T) {
+	logs := &syncBuffer{}
+	l, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	s, err := Config{
+		Handler: func(w *response.Writer, req *request.Request) { w.Write([]byte("fine")) },
+		Logger:  log.New(logs, "", 0),
+	}.Serve(panicListener{l})
+	require.NoError(t, err)
+	defer s.Close()
+	url := "http://" + s.Addr().String()
+
+	// Test: a panic while reading the request sends a 500 and closes the
+	// connection instead of crashing the process
+	conn, br := dialServer(t, url)
+	fmt.Fprint(conn, "GET / HTTP/1.1\r\n")
+	time.Sleep(20 * time.Millisecond)
+	fmt.Fprint(conn, "X-Trigger: PANIC\r\n\r\n")
+	res := readResponse(t, br)
+	assert.Equal(t, response.StatusError, res.StatusLine.StatusCode)
+	_, err = br.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+	assert.Contains(t, logs.String(), "boom parsing")
+
+	// Test: server keeps serving other connections
+	_, body := get(t, "GET", url+"/")
+	assert.Equal(t, "fine", body)
+}
+
+func TestServe_ErrorHandler(t *testing.T) {
+	called := false
+	handler := func(w *response.Writer, req *request.Request) {
+		called = true
+	}
+
+	// Test: default error handler sends the status without calling the handler
+	url := startServer(t, handler)
+	conn, br := dialServer(t, url)
+	fmt.Fprint(conn, "BREW /pot HTTP/1.1\r\nHost: x\r\n\r\n")
+	res := readResponse(t, br)
+	assert.Equal(t, response.StatusBadRequest, res.StatusLine.StatusCode)
+	assert.Empty(t, res.Body)
+	_, err := br.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+	assert.False(t, called)
+
+	// Test: a truncated request goes to the error handler, not the handler
+	conn, br = dialServer(t, url)
+	fmt.Fprint(conn, "GET /x HTTP/1.1\r\nHost: x\r\n")
+	conn.(*net.TCPConn).CloseWrite()
+	res = readResponse(t, br)
+	assert.Equal(t, response.StatusBadRequest, res.StatusLine.StatusCode)
+	_, err = br.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+	assert.False(t, called)
+
+	// Test: custom error handler gets the typed parse error
+	errs := make(chan *RequestError, 1)
+	url = startServer(t, handler, WithErrorHandler(func(w *response.Writer, err *RequestError) {
+		errs <- err
+		w.Header().Set("Content-Type", "application/json")
+		w.SetStatus(err.StatusCode)
+		fmt.Fprintf(w, `{"error":%q}`, err.Err)
+	}))
+	conn, br = dialServer(t, url)
+	fmt.Fprint(conn, "GET / HTTP/2.0\r\n\r\n")
+	res = readResponse(t, br)
+	assert.Equal(t, response.StatusBadRequest, res.StatusLine.StatusCode)
+	assert.Equal(t, `{"error":"unsupported HTTP version"}`, res.Body)
+	h, _ := res.Headers.Get("connection")
+	assert.Equal(t, "close", h)
+	reqErr := <-errs
+	assert.ErrorIs(t, reqErr, request.ErrUnsupportedHTTPVersion)
+	assert.False(t, called)
+
+	// Test: the custom error handler gets the EOF of a truncated request
+	conn, br = dialServer(t, url)
+	fmt.Fprint(conn, "GE")
+	conn.(*net.TCPConn).CloseWrite()
+	res = readResponse(t, br)
+	assert.Equal(t, response.StatusBadRequest, res.StatusLine.StatusCode)
+	assert.ErrorIs(t, <-errs, io.ErrUnexpectedEOF)
+	assert.False(t, called)
+}
+
+func TestServe_RequestContext(t *testing.T) {
+	cancelled := make(chan error, 1)
+	started := make(chan struct{}, 1)
+	s, err := Serve(func(w *response.Writer, req *request.Request) {
+		switch req.RequestLine.RequestTarget {
+		case "/wait":
+			started <- struct{}{}
+			select {
+			case <-req.Context().Done():
+				cancelled <- req.Context().Err()
+			case <-time.After(2 * time.Second):
+				cancelled <- nil
+			}
+		case "/deadline":
+			_, ok := req.Context().Deadline()
+			fmt.Fprintf(w, "%t", ok)
+		default:
+			fmt.Fprintf(w, "%s %s %v", req.RemoteAddr, req.LocalAddr, req.Context().Err())
+		}
+	}, 0, WithWriteTimeout(time.Minute))
+	require.NoError(t, err)
+	url := "http://" + s.Addr().String()
+
+	// Test: addresses are set and the context is live during the handler
+	conn, br := dialServer(t, url)
+	fmt.Fprint(conn, "GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+	res := readResponse(t, br)
+	assert.Equal(t, conn.LocalAddr().String()+" "+conn.RemoteAddr().String()+" <nil>", res.Body)
+
+	// Test: write timeout becomes the deadline of the context
+	fmt.Fprint(conn, "GET /deadline HTTP/1.1\r\nHost: x\r\n\r\n")
+	assert.Equal(t, "true", readResponse(t, br).Body)
+
+	// Test: a pipelined request does not cancel the running one
+	fmt.Fprint(conn, "GET /deadline HTTP/1.1\r\nHost: x\r\n\r\nGET / HTTP/1.1\r\nHost: x\r\n\r\n")
+	assert.Equal(t, "true", readResponse(t, br).Body)
+	assert.Contains(t, readResponse(t, br).Body, "<nil>")
+
+	// Test: context is cancelled when the client goes away
+	fmt.Fprint(conn, "GET /wait HTTP/1.1\r\nHost: x\r\n\r\n")
+	<-started
+	conn.Close()
+	assert.ErrorIs(t, <-cancelled, context.Canceled)
+
+	// Test: context is cancelled when the server is closed
+	conn, _ = dialServer(t, url)
+	fmt.Fprint(conn, "GET /wait HTTP/1.1\r\nHost: x\r\n\r\n")
+	<-started
+	require.NoError(t, s.Close())
+	assert.ErrorIs(t, <-cancelled, context.Canceled)
+}
+
+func TestServe_Hijack(t *testing.T) {
+	release := make(chan struct{})
+	s, err := Serve(func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusSwitchingProtocols)
+		w.WriteHeaders(headers.Headers{"upgrade": "shout"})
+		conn, br, err := w.Hijack()
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer conn.Close()
+		<-release
+		line, _ := br.ReadString('\n')
+		fmt.Fprint(conn, strings.ToUpper(line))
+	}, 0)
+	require.NoError(t, err)
+	defer s.Close()
+
+	// Test: the response written before hijacking is sent
+	conn, br := dialServer(t, "http://"+s.Addr().String())
+	fmt.Fprint(conn, "GET / HTTP/1.1\r\nHost: x\r\n\r\nhello\n")
+	status, h := readHandshake(t, br)
+	assert.Equal(t, "HTTP/1.1 101 Switching Protocols", status)
+	assert.Equal(t, "shout", h["upgrade"])
+
+	// Test: the server forgets the connection once it is hijacked
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, s.Shutdown(ctx))
+	require.NoError(t, s.Close())
+
+	// Test: the handler owns the connection, including the buffered bytes
+	close(release)
+	line, err := br.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HELLO\n", line)
+	_, err = br.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestConfig(t *testing.T) {
+	hello := func(w *response.Writer, req *request.Request) {
+		w.Write([]byte("hello"))
+	}
+
+	// Test: handler is required
+	_, err := Config{}.ListenAndServe("127.0.0.1:0")
+	assert.ErrorIs(t, err, ErrMissingHandler)
+
+	// Test: ListenAndServe binds a specific interface and reports the port
+	s, err := Config{Handler: hello, ServerName: "cfg"}.ListenAndServe("127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	addr := s.Addr().(*net.TCPAddr)
+	assert.True(t, addr.IP.IsLoopback())
+	assert.NotZero(t, addr.Port)
+	res, body := get(t, "GET", "http://"+s.Addr().String()+"/")
+	assert.Equal(t, "hello", body)
+	h, _ := res.Headers.Get("server")
+	assert.Equal(t, "cfg", h)
+
+	// Test: Serve accepts any listener, such as a Unix domain socket
+	path := filepath.Join(t.TempDir(), "server.sock")
+	l, err := net.Listen("unix", path)
+	require.NoError(t, err)
+	s, err = Config{Handler: hello}.Serve(l)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	assert.Equal(t, path, s.Addr().String())
+	conn, err := net.Dial("unix", path)
+	require.NoError(t, err)
+	defer conn.Close()
+	fmt.Fprint(conn, "GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+	assert.Equal(t, "hello", readResponse(t, bufio.NewReader(conn)).Body)
+}
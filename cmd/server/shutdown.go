@@ -0,0 +1,123 @@
+package server
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+type connState int
+
+const (
+	stateActive connState = iota
+	stateIdle
+	// stateIdleHTTP2 is an HTTP/2 connection without open streams, on
+	// shutdown it sends GOAWAY and closes itself.
+	stateIdleHTTP2
+)
+
+// shutdownPollInterval is how often Shutdown checks whether the active
+// connections are done.
+const shutdownPollInterval = 10 * time.Millisecond
+
+// Close stops the server immediately, it closes the listener and every
+// connection, including those with a request in flight, and cancels the
+// contexts of those requests. Hijacked connections are left to their
+// handlers.
+func (s *Server) Close() error {
+	err := s.closeListener()
+	s.cancelBase()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		conn.Close()
+		delete(s.conns, conn)
+	}
+	return err
+}
+
+// Shutdown stops the server gracefully. It closes the listener and the idle
+// keep-alive connections, then waits for the active connections to finish
+// their current response, which is sent with "Connection: close". When ctx
+// expires first, the contexts of the remaining requests are cancelled and
+// the error of ctx is returned. Their connections are left open, call Close
+// to cut them off. HTTP/2 connections get GOAWAY, those without open
+// streams are closed right away. Hijacked connections are not waited for,
+// the contexts handed to their handlers end right away.
+func (s *Server) Shutdown(ctx context.Context) error {
+	err := s.closeListener()
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for {
+		if s.closeIdleConns() {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			s.cancelBase()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// closeListener stops accepting connections, ends the hijacked ones and
+// makes HTTP/2 connections go away, only the first call closes the listener.
+func (s *Server) closeListener() error {
+	s.cancelShutdown()
+	if s.closed.Swap(true) {
+		return nil
+	}
+	return s.listener.Close()
+}
+
+// closeIdleConns closes the connections waiting for a request and reports
+// whether no connections are left.
+func (s *Server) closeIdleConns() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn, state := range s.conns {
+		if state == stateIdle {
+			conn.Close()
+			delete(s.conns, conn)
+		}
+	}
+	return len(s.conns) == 0
+}
+
+// trackConn registers a new connection, it reports false when the server is
+// already closed.
+func (s *Server) trackConn(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed.Load() {
+		return false
+	}
+	s.conns[conn] = stateActive
+	return true
+}
+
+// setConnState records whether conn is waiting for a request or serving one.
+// It reports false when the connection should not be used anymore, because
+// the server closed it or is shutting down and conn is between requests.
+func (s *Server) setConnState(conn net.Conn, state connState) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.conns[conn]; !ok {
+		return false
+	}
+	if state != stateActive && s.closed.Load() {
+		return false
+	}
+	s.conns[conn] = state
+	return true
+}
+
+// untrackConn forgets a connection that is closed or was hijacked.
+func (s *Server) untrackConn(conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.conns, conn)
+}
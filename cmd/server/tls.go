@@ -0,0 +1,154 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultCertReloadInterval is how often Certificates checks its files for
+// changes.
+const DefaultCertReloadInterval = time.Minute
+
+var ErrNoCertificates = errors.New("server: no certificates")
+
+// LoadCertPool reads a bundle of PEM encoded CA certificates, such as the
+// ClientCAs that client certificates are verified against for mutual TLS:
+//
+//	cas, err := server.LoadCertPool("clients-ca.pem")
+//	cfg := &tls.Config{ClientCAs: cas, ClientAuth: tls.RequireAndVerifyClientCert}
+//
+// tls.VerifyClientCertIfGiven requests a certificate without requiring it.
+func LoadCertPool(file string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("server: no certificates found in %s", file)
+	}
+	return pool, nil
+}
+
+// CertKeyPair names a PEM encoded certificate chain and its private key.
+type CertKeyPair struct {
+	CertFile string
+	KeyFile  string
+}
+
+// Certificates serves one or more certificates loaded from disk, picking the
+// one matching the server name the client asked for (SNI). Changed files are
+// picked up without a restart. Use GetCertificate in a tls.Config:
+//
+//	certs, err := server.LoadCertificates(pairs...)
+//	cfg := &tls.Config{GetCertificate: certs.GetCertificate}
+type Certificates struct {
+	// ReloadInterval is how often GetCertificate checks whether the files
+	// changed. Zero means DefaultCertReloadInterval, a negative value only
+	// reloads on an explicit call to Reload.
+	ReloadInterval time.Duration
+
+	pairs []CertKeyPair
+
+	mu       sync.RWMutex
+	certs    []*tls.Certificate
+	modTimes []time.Time
+	checked  time.Time
+}
+
+// LoadCertificates loads the certificates of pairs, the first one is used
+// for clients that don't send a matching server name.
+func LoadCertificates(pairs ...CertKeyPair) (*Certificates, error) {
+	if len(pairs) == 0 {
+		return nil, ErrNoCertificates
+	}
+	c := &Certificates{pairs: pairs}
+	if err := c.Reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Reload reads all certificate files again. If one of them fails to load the
+// certificates in use are kept and the error is returned.
+func (c *Certificates) Reload() error {
+	certs := make([]*tls.Certificate, 0, len(c.pairs))
+	modTimes := make([]time.Time, 0, len(c.pairs))
+	for _, pair := range c.pairs {
+		cert, err := tls.LoadX509KeyPair(pair.CertFile, pair.KeyFile)
+		if err != nil {
+			return err
+		}
+		certs = append(certs, &cert)
+		modTimes = append(modTimes, c.modTime(pair))
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.certs = certs
+	c.modTimes = modTimes
+	c.checked = time.Now()
+	return nil
+}
+
+// GetCertificate returns the certificate for the server name in hello, it
+// is meant for tls.Config.GetCertificate.
+func (c *Certificates) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.reloadIfChanged()
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, cert := range c.certs {
+		if hello.SupportsCertificate(cert) == nil {
+			return cert, nil
+		}
+	}
+	return c.certs[0], nil
+}
+
+// reloadIfChanged reloads the certificates when a file changed since the
+// last check, at most once per ReloadInterval. A failed reload is retried
+// with the next check.
+func (c *Certificates) reloadIfChanged() {
+	interval := c.ReloadInterval
+	if interval == 0 {
+		interval = DefaultCertReloadInterval
+	}
+	if interval < 0 {
+		return
+	}
+
+	c.mu.Lock()
+	if time.Since(c.checked) < interval {
+		c.mu.Unlock()
+		return
+	}
+	c.checked = time.Now()
+	changed := false
+	for i, pair := range c.pairs {
+		if !c.modTime(pair).Equal(c.modTimes[i]) {
+			changed = true
+		}
+	}
+	c.mu.Unlock()
+
+	if changed {
+		c.Reload()
+	}
+}
+
+// modTime returns the latest modification time of the files of pair.
+func (c *Certificates) modTime(pair CertKeyPair) time.Time {
+	var latest time.Time
+	for _, name := range []string{pair.CertFile, pair.KeyFile} {
+		if info, err := os.Stat(name); err == nil && info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest
+}
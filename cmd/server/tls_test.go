@@ -0,0 +1,278 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ramonvermeulen/httpfromtcp/internal/client"
+	"github.com/ramonvermeulen/httpfromtcp/internal/request"
+	"github.com/ramonvermeulen/httpfromtcp/internal/response"
+)
+
+// testCA signs certificates for tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &testCA{cert: cert, key: key, pool: pool}
+}
+
+// issue returns a certificate for cn and names, signed by the CA.
+func (ca *testCA) issue(t *testing.T, cn string, usage x509.ExtKeyUsage, names ...string) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	for _, name := range names {
+		if ip := net.ParseIP(name); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, name)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+	leaf, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+// writeCert writes cert as PEM files to dir and returns their names.
+func writeCert(t *testing.T, dir, name string, cert tls.Certificate) CertKeyPair {
+	t.Helper()
+	keyDER, err := x509.MarshalECPrivateKey(cert.PrivateKey.(*ecdsa.PrivateKey))
+	require.NoError(t, err)
+	pair := CertKeyPair{
+		CertFile: filepath.Join(dir, name+".crt"),
+		KeyFile:  filepath.Join(dir, name+".key"),
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	require.NoError(t, os.WriteFile(pair.CertFile, certPEM, 0o600))
+	require.NoError(t, os.WriteFile(pair.KeyFile, keyPEM, 0o600))
+	return pair
+}
+
+func startTLSServer(t *testing.T, handler Handler, tlsConfig *tls.Config) string {
+	t.Helper()
+	s, err := Config{Handler: handler, TLSConfig: tlsConfig}.ListenAndServe("127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	return s.Addr().String()
+}
+
+// peerName dials addr with serverName and returns the common name of the
+// certificate the server presented.
+func peerName(t *testing.T, addr, serverName string, pool *x509.CertPool) string {
+	t.Helper()
+	conn, err := tls.Dial("tcp", addr, &tls.Config{ServerName: serverName, RootCAs: pool})
+	require.NoError(t, err)
+	defer conn.Close()
+	return conn.ConnectionState().PeerCertificates[0].Subject.CommonName
+}
+
+func TestServe_TLS(t *testing.T) {
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	certs, err := LoadCertificates(
+		writeCert(t, dir, "default", ca.issue(t, "default", x509.ExtKeyUsageServerAuth, "localhost", "127.0.0.1")),
+		writeCert(t, dir, "api", ca.issue(t, "api", x509.ExtKeyUsageServerAuth, "api.example.com")),
+	)
+	require.NoError(t, err)
+	addr := startTLSServer(t, func(w *response.Writer, req *request.Request) {
+		fmt.Fprintf(w, "%s %s %x", req.TLS.ServerName, req.TLS.NegotiatedProtocol, req.TLS.Version)
+	}, &tls.Config{GetCertificate: certs.GetCertificate, MinVersion: tls.VersionTLS13})
+
+	// Test: handler sees the TLS state, ALPN negotiates http/1.1
+	c := &client.Client{TLSConfig: &tls.Config{RootCAs: ca.pool, ServerName: "localhost"}}
+	req, err := client.NewRequest("GET", "https://"+addr+"/", "")
+	require.NoError(t, err)
+	res, err := c.Do(context.Background(), req)
+	require.NoError(t, err)
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, fmt.Sprintf("localhost http/1.1 %x", tls.VersionTLS13), string(body))
+
+	// Test: certificate is selected by SNI
+	assert.Equal(t, "api", peerName(t, addr, "api.example.com", ca.pool))
+	assert.Equal(t, "default", peerName(t, addr, "localhost", ca.pool))
+
+	// Test: minimum version is enforced
+	_, err = tls.Dial("tcp", addr, &tls.Config{ServerName: "localhost", RootCAs: ca.pool, MaxVersion: tls.VersionTLS12})
+	assert.Error(t, err)
+
+	// Test: plaintext requests are not served
+	conn, br := dialServer(t, "http://"+addr)
+	fmt.Fprint(conn, "GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+	_, err = response.ResponseHeadFromReader(br, "GET")
+	assert.Error(t, err)
+}
+
+func TestCertificates_Reload(t *testing.T) {
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	pair := writeCert(t, dir, "server", ca.issue(t, "first", x509.ExtKeyUsageServerAuth, "localhost"))
+	certs, err := LoadCertificates(pair)
+	require.NoError(t, err)
+	certs.ReloadInterval = time.Millisecond
+	addr := startTLSServer(t, func(w *response.Writer, req *request.Request) {}, &tls.Config{GetCertificate: certs.GetCertificate})
+	assert.Equal(t, "first", peerName(t, addr, "localhost", ca.pool))
+
+	// Test: changed files are picked up without a restart
+	writeCert(t, dir, "server", ca.issue(t, "second", x509.ExtKeyUsageServerAuth, "localhost"))
+	later := time.Now().Add(time.Second)
+	require.NoError(t, os.Chtimes(pair.CertFile, later, later))
+	time.Sleep(5 * time.Millisecond)
+	assert.Equal(t, "second", peerName(t, addr, "localhost", ca.pool))
+
+	// Test: broken files keep the old certificate
+	require.NoError(t, os.WriteFile(pair.KeyFile, []byte("garbage"), 0o600))
+	assert.Error(t, certs.Reload())
+	assert.Equal(t, "second", peerName(t, addr, "localhost", ca.pool))
+
+	// Test: at least one certificate is needed
+	_, err = LoadCertificates()
+	assert.ErrorIs(t, err, ErrNoCertificates)
+}
+
+func TestServe_MutualTLS(t *testing.T) {
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.pem")
+	require.NoError(t, os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw}), 0o600))
+	clientCAs, err := LoadCertPool(caFile)
+	require.NoError(t, err)
+
+	router := NewRouter()
+	router.Get("/whoami", func(w *response.Writer, req *request.Request) {
+		if req.Client == nil {
+			w.Write([]byte("anonymous"))
+			return
+		}
+		fmt.Fprint(w, req.Client.Names())
+	})
+	admin := NewRouter()
+	admin.Use(AllowClients("admin.internal"))
+	admin.Get("/", reply("welcome"))
+	router.Mount("/admin", admin.ServeHTTP)
+
+	logs := &syncBuffer{}
+	serverCert := ca.issue(t, "server", x509.ExtKeyUsageServerAuth, "localhost")
+	newServer := func(auth tls.ClientAuthType) string {
+		s, err := Config{
+			Handler: router.ServeHTTP,
+			Logger:  log.New(logs, "", 0),
+			TLSConfig: &tls.Config{
+				Certificates: []tls.Certificate{serverCert},
+				ClientCAs:    clientCAs,
+				ClientAuth:   auth,
+			},
+		}.ListenAndServe("127.0.0.1:0")
+		require.NoError(t, err)
+		t.Cleanup(func() { s.Close() })
+		return s.Addr().String()
+	}
+	fetch := func(addr, path string, certs ...tls.Certificate) (*client.Response, string, error) {
+		c := &client.Client{TLSConfig: &tls.Config{RootCAs: ca.pool, ServerName: "localhost", Certificates: certs}}
+		req, err := client.NewRequest("GET", "https://"+addr+path, "")
+		require.NoError(t, err)
+		res, err := c.Do(context.Background(), req)
+		if err != nil {
+			return nil, "", err
+		}
+		defer res.Body.Close()
+		body, err := io.ReadAll(res.Body)
+		return res, string(body), err
+	}
+	adminCert := ca.issue(t, "ops", x509.ExtKeyUsageClientAuth, "admin.internal")
+	userCert := ca.issue(t, "alice", x509.ExtKeyUsageClientAuth, "alice.internal")
+
+	// Test: verified identity is exposed on the request
+	required := newServer(tls.RequireAndVerifyClientCert)
+	_, body, err := fetch(required, "/whoami", userCert)
+	require.NoError(t, err)
+	assert.Equal(t, "[alice alice.internal]", body)
+
+	// Test: middleware authorizes by identity
+	_, body, err = fetch(required, "/admin/", adminCert)
+	require.NoError(t, err)
+	assert.Equal(t, "welcome", body)
+	res, _, err := fetch(required, "/admin/", userCert)
+	require.NoError(t, err)
+	assert.Equal(t, response.StatusForbidden, res.StatusLine.StatusCode)
+
+	// Test: client without certificate is rejected when one is required
+	_, _, err = fetch(required, "/whoami")
+	assert.Error(t, err)
+
+	// Test: certificate from another CA is rejected and logged clearly
+	other := newTestCA(t).issue(t, "mallory", x509.ExtKeyUsageClientAuth, "mallory.internal")
+	_, _, err = fetch(required, "/whoami", other)
+	assert.Error(t, err)
+	assert.Eventually(t, func() bool {
+		return strings.Contains(logs.String(), `client certificate of 127.0.0.1`) &&
+			strings.Contains(logs.String(), `(subject "CN=mallory") rejected`)
+	}, time.Second, 10*time.Millisecond)
+
+	// Test: requested certificate is optional
+	optional := newServer(tls.VerifyClientCertIfGiven)
+	_, body, err = fetch(optional, "/whoami")
+	require.NoError(t, err)
+	assert.Equal(t, "anonymous", body)
+	res, _, err = fetch(optional, "/admin/")
+	require.NoError(t, err)
+	assert.Equal(t, response.StatusForbidden, res.StatusLine.StatusCode)
+	_, body, err = fetch(optional, "/whoami", adminCert)
+	require.NoError(t, err)
+	assert.Equal(t, "[ops admin.internal]", body)
+}
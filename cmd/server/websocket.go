@@ -0,0 +1,57 @@
+package server
+
+import (
+	"context"
+	"errors"
+
+	"github.com/ramonvermeulen/httpfromtcp/internal/request"
+	"github.com/ramonvermeulen/httpfromtcp/internal/response"
+	"github.com/ramonvermeulen/httpfromtcp/internal/websocket"
+)
+
+// WebSocketHandler serves a WebSocket connection, the connection is closed
+// when it returns. The context of req ends when the server shuts down or is
+// closed, the connection is then closed with CloseGoingAway.
+type WebSocketHandler func(conn *websocket.Conn, req *request.Request)
+
+// WebSocket returns a handler that answers the opening handshake of a
+// WebSocket and passes the connection to h. Requests that are no valid
+// handshake are rejected with an error status.
+func WebSocket(h WebSocketHandler, opts websocket.Options) Handler {
+	return func(w *response.Writer, req *request.Request) {
+		hs, err := websocket.CheckHandshake(req, opts)
+		if err != nil {
+			var hsErr *websocket.HandshakeError
+			errors.As(err, &hsErr)
+			hdrs := response.GetDefaultHeaders(0)
+			if hsErr.Status == response.StatusUpgradeRequired {
+				hdrs.Set("Upgrade", "websocket")
+				hdrs.Set("Sec-WebSocket-Version", websocket.Version)
+			}
+			w.WriteStatusLine(hsErr.Status)
+			w.WriteHeaders(hdrs)
+			return
+		}
+		netConn, br, err := w.Hijack()
+		if err != nil {
+			w.WriteStatusLine(response.StatusError)
+			w.WriteHeaders(response.GetDefaultHeaders(0))
+			return
+		}
+		defer netConn.Close()
+		rw := response.NewWriter(netConn)
+		rw.WriteStatusLine(response.StatusSwitchingProtocols)
+		rw.WriteHeaders(hs.Headers())
+		if err := rw.Flush(); err != nil {
+			return
+		}
+
+		ctx := hijackContext(req)
+		conn := websocket.NewConn(netConn, br, hs)
+		stop := context.AfterFunc(ctx, func() {
+			conn.Close(websocket.CloseGoingAway, "server shutting down")
+		})
+		defer stop()
+		h(conn, req.WithContext(ctx))
+	}
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ramonvermeulen/httpfromtcp/internal/request"
+	"github.com/ramonvermeulen/httpfromtcp/internal/response"
+	"github.com/ramonvermeulen/httpfromtcp/internal/websocket"
+)
+
+// readHandshake reads the response to an opening handshake and returns its
+// status line.
+func readHandshake(t *testing.T, br *bufio.Reader) (string, map[string]string) {
+	t.Helper()
+	status, err := br.ReadString('\n')
+	require.NoError(t, err)
+	h := map[string]string{}
+	for {
+		line, err := br.ReadString('\n')
+		require.NoError(t, err)
+		if line == "\r\n" {
+			return strings.TrimSpace(status), h
+		}
+		name, value, _ := strings.Cut(strings.TrimSpace(line), ":")
+		h[strings.ToLower(name)] = strings.TrimSpace(value)
+	}
+}
+
+const handshake = "GET /ws HTTP/1.1\r\nHost: localhost\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+	"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n"
+
+func echo(conn *websocket.Conn, req *request.Request) {
+	for {
+		typ, msg, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		conn.WriteMessage(typ, msg)
+	}
+}
+
+func TestWebSocket(t *testing.T) {
+	url := startServer(t, WebSocket(echo, websocket.Options{}))
+
+	// Test: the handshake is answered with 101 and the accept key
+	conn, br := dialServer(t, url)
+	fmt.Fprint(conn, handshake)
+	status, h := readHandshake(t, br)
+	assert.Equal(t, "HTTP/1.1 101 Switching Protocols", status)
+	assert.Equal(t, "websocket", h["upgrade"])
+	assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", h["sec-websocket-accept"])
+
+	// Test: messages are echoed, frames from the client are masked
+	conn.Write([]byte{0x81, 0x82, 0, 0, 0, 0, 'h', 'i'})
+	reply := make([]byte, 4)
+	_, err := io.ReadFull(br, reply)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x81, 0x02, 'h', 'i'}, reply)
+
+	// Test: the close handshake ends the connection
+	conn.Write([]byte{0x88, 0x82, 0, 0, 0, 0, 0x03, 0xe8})
+	_, err = io.ReadFull(br, reply)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x88, 0x02, 0x03, 0xe8}, reply)
+	_, err = br.ReadByte()
+	assert.Equal(t, io.EOF, err)
+
+	// Test: a request that is no handshake is rejected
+	res, _ := get(t, "GET", url+"/ws")
+	assert.Equal(t, response.StatusUpgradeRequired, res.StatusLine.StatusCode)
+	version, _ := res.Headers.Get("sec-websocket-version")
+	assert.Equal(t, "13", version)
+}
+
+func TestWebSocket_Compression(t *testing.T) {
+	url := startServer(t, WebSocket(echo, websocket.Options{EnableCompression: true}))
+
+	// Test: permessage-deflate is negotiated when the client offers it
+	conn, br := dialServer(t, url)
+	fmt.Fprint(conn, strings.Replace(handshake, "\r\n\r\n",
+		"\r\nSec-WebSocket-Extensions: permessage-deflate; client_max_window_bits\r\n\r\n", 1))
+	status, h := readHandshake(t, br)
+	assert.Equal(t, "HTTP/1.1 101 Switching Protocols", status)
+	assert.Equal(t, "permessage-deflate; server_no_context_takeover", h["sec-websocket-extensions"])
+
+	// Test: a compressed "Hello" is echoed compressed
+	conn.Write([]byte{0xc1, 0x87, 0, 0, 0, 0, 0xf2, 0x48, 0xcd, 0xc9, 0xc9, 0x07, 0x00})
+	hdr := make([]byte, 2)
+	_, err := io.ReadFull(br, hdr)
+	require.NoError(t, err)
+	assert.Equal(t, byte(0xc1), hdr[0])
+	payload := make([]byte, hdr[1])
+	_, err = io.ReadFull(br, payload)
+	require.NoError(t, err)
+	msg, err := io.ReadAll(flate.NewReader(io.MultiReader(bytes.NewReader(payload),
+		bytes.NewReader([]byte{0x00, 0x00, 0xff, 0xff, 0x01, 0x00, 0x00, 0xff, 0xff}))))
+	require.NoError(t, err)
+	assert.Equal(t, "Hello", string(msg))
+}
+
+func TestWebSocket_Shutdown(t *testing.T) {
+	s, err := Config{Handler: WebSocket(echo, websocket.Options{})}.ListenAndServe("localhost:0")
+	require.NoError(t, err)
+	defer s.Close()
+	conn, br := dialServer(t, "http://"+s.Addr().String())
+	fmt.Fprint(conn, handshake)
+	readHandshake(t, br)
+
+	// Test: Shutdown does not wait for WebSocket connections, they are
+	// closed with "going away"
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.NoError(t, s.Shutdown(ctx))
+	frame := make([]byte, 4)
+	_, err = io.ReadFull(br, frame)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x88, 0x16, 0x03, 0xe9}, frame)
+}
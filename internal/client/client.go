@@ -0,0 +1,372 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/ramonvermeulen/httpfromtcp/internal/headers"
+	"github.com/ramonvermeulen/httpfromtcp/internal/request"
+	"github.com/ramonvermeulen/httpfromtcp/internal/response"
+)
+
+var (
+	ErrUnsupportedScheme = fmt.Errorf("unsupported URL scheme")
+	ErrMissingHost       = fmt.Errorf("request URL has no host")
+)
+
+// Client sends requests with this project's own request writer and response
+// parser. The zero value is ready to use.
+type Client struct {
+	// Timeout limits the whole exchange, from dialing until the response
+	// body is closed. Zero means no limit besides the context of Do.
+	Timeout time.Duration
+	// TLSConfig is used for https requests, nil means the defaults.
+	TLSConfig *tls.Config
+	// Dialer opens the TCP connections, nil means a zero net.Dialer.
+	Dialer *net.Dialer
+	// MaxIdleConnsPerHost is how many keep-alive connections are kept per
+	// scheme and host:port for reuse. Zero means DefaultMaxIdleConnsPerHost,
+	// a negative value disables reuse.
+	MaxIdleConnsPerHost int
+	// IdleConnTimeout closes idle connections that were not reused in time,
+	// zero means DefaultIdleConnTimeout.
+	IdleConnTimeout time.Duration
+	// MaxRedirects is how many redirects Do follows before giving up. Zero
+	// means DefaultMaxRedirects, a negative value returns redirect responses
+	// to the caller.
+	MaxRedirects int
+	// Jar stores cookies from responses and sends them with later requests,
+	// nil disables cookies.
+	Jar *Jar
+	// Retry decides how requests with an idempotent method are retried when
+	// the connection breaks, nil means DefaultRetryPolicy.
+	Retry *RetryPolicy
+
+	pool pool
+}
+
+// Response is a response whose body is streamed from the connection.
+type Response struct {
+	StatusLine response.StatusLine
+	Headers    headers.Headers
+	// Body must be closed by the caller, also when it is not read.
+	Body io.ReadCloser
+
+	response *response.Response
+}
+
+// Trailers returns the trailer fields of a chunked response, they are only
+// known once Body returned io.EOF.
+func (r *Response) Trailers() headers.Headers {
+	return r.response.Trailers
+}
+
+// NewRequest returns a request for an absolute http or https URL, Do uses the
+// URL to decide where to connect to.
+func NewRequest(method, rawURL string, body string) (*request.Request, error) {
+	u, err := parseURL(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if !slices.ContainsFunc(request.HTTPMethods, func(m []byte) bool {
+		return string(m) == method
+	}) {
+		return nil, request.ErrUnsupportedHTTPMethod
+	}
+
+	req := request.NewRequest()
+	req.RequestLine = request.RequestLine{
+		Method:        method,
+		RequestTarget: u.String(),
+		HTTPVersion:   "1.1",
+	}
+	req.Headers.Set("Host", u.Host)
+	req.Body = body
+	return req, nil
+}
+
+func parseURL(rawURL string) (*url.URL, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedScheme, u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, ErrMissingHost
+	}
+	return u, nil
+}
+
+// connKey identifies the connections a request to u can reuse.
+func connKey(u *url.URL) string {
+	return u.Scheme + "://" + hostPort(u)
+}
+
+// hostPort returns the address to dial for u, adding the default port of
+// the scheme when the URL has none.
+func hostPort(u *url.URL) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	if u.Scheme == "https" {
+		return net.JoinHostPort(u.Hostname(), "443")
+	}
+	return net.JoinHostPort(u.Hostname(), "80")
+}
+
+// originForm returns the request target as sent to an origin server, the
+// path and query of u.
+func originForm(u *url.URL) string {
+	target := u.EscapedPath()
+	if target == "" {
+		target = "/"
+	}
+	if u.RawQuery != "" {
+		target += "?" + u.RawQuery
+	}
+	return target
+}
+
+// Do sends req and returns the response once its headers are read. The
+// request target of req must be an absolute URL, see NewRequest. Redirects
+// are followed and cookies are kept in Jar, idempotent requests are retried
+// when the connection breaks. Cancelling ctx aborts the exchange, including
+// reading the body.
+func (c *Client) Do(ctx context.Context, req *request.Request) (*Response, error) {
+	cancel := context.CancelFunc(func() {})
+	if c.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+	}
+
+	res, err := c.follow(ctx, req)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	b := res.Body.(*body)
+	release := b.release
+	b.release = func(done bool) {
+		release(done)
+		cancel()
+	}
+	return res, nil
+}
+
+// send does a single exchange for req on a pooled or new connection. Once
+// the body is read to the end the connection goes back to the pool if both
+// sides allow it.
+func (c *Client) send(ctx context.Context, req *request.Request) (*Response, error) {
+	u, err := parseURL(req.RequestLine.RequestTarget)
+	if err != nil {
+		return nil, err
+	}
+
+	pc, err := c.getConn(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		pc.conn.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() {
+		pc.conn.Close()
+	})
+
+	res, err := pc.roundTrip(c.wireRequest(u, req))
+	if err != nil {
+		stop()
+		pc.conn.Close()
+		return nil, contextError(ctx, err)
+	}
+	if c.Jar != nil {
+		if setCookie, ok := res.Headers.Get("set-cookie"); ok {
+			c.Jar.SetCookies(u, setCookie)
+		}
+	}
+
+	reusable := res.response.KeepAlive() && !wantsClose(req)
+	res.Body = &body{
+		reader:   res.Body,
+		ctx:      ctx,
+		response: res.response,
+		release: func(done bool) {
+			if stop() && done && reusable && c.maxIdleConnsPerHost() > 0 {
+				c.pool.put(pc, c.maxIdleConnsPerHost(), c.idleConnTimeout())
+			} else {
+				pc.conn.Close()
+			}
+		},
+	}
+	return res, nil
+}
+
+// wireRequest returns the request as sent to the origin server, in
+// origin-form and with the cookies from Jar.
+func (c *Client) wireRequest(u *url.URL, req *request.Request) *request.Request {
+	wire := *req
+	wire.RequestLine.RequestTarget = originForm(u)
+	wire.Headers = headers.NewHeaders()
+	for key, value := range req.Headers {
+		wire.Headers.Replace(key, value)
+	}
+	if _, ok := wire.Headers.Get("host"); !ok {
+		wire.Headers.Set("Host", u.Host)
+	}
+	if c.Jar != nil {
+		if cookies := c.Jar.Cookies(u); len(cookies) > 0 {
+			wire.Headers.Set("Cookie", cookieHeader(cookies))
+		}
+	}
+	return &wire
+}
+
+// CloseIdleConnections closes the connections kept for reuse, connections
+// in use are not affected.
+func (c *Client) CloseIdleConnections() {
+	c.pool.closeIdle()
+}
+
+func (c *Client) maxIdleConnsPerHost() int {
+	if c.MaxIdleConnsPerHost == 0 {
+		return DefaultMaxIdleConnsPerHost
+	}
+	return c.MaxIdleConnsPerHost
+}
+
+func (c *Client) idleConnTimeout() time.Duration {
+	if c.IdleConnTimeout == 0 {
+		return DefaultIdleConnTimeout
+	}
+	return c.IdleConnTimeout
+}
+
+// wantsClose reports whether req asks the server to close the connection.
+func wantsClose(req *request.Request) bool {
+	value, _ := req.Headers.Get("connection")
+	return headers.HasToken(value, "close")
+}
+
+// getConn returns an idle connection to the host of u or dials a new one.
+func (c *Client) getConn(ctx context.Context, u *url.URL) (*persistConn, error) {
+	key := connKey(u)
+	if pc := c.pool.get(key); pc != nil {
+		return pc, nil
+	}
+	conn, err := c.dial(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	return &persistConn{key: key, conn: conn, br: bufio.NewReader(conn)}, nil
+}
+
+func (c *Client) dial(ctx context.Context, u *url.URL) (net.Conn, error) {
+	dialer := c.Dialer
+	if dialer == nil {
+		dialer = &net.Dialer{}
+	}
+	conn, err := dialer.DialContext(ctx, "tcp", hostPort(u))
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "https" {
+		return conn, nil
+	}
+
+	cfg := &tls.Config{}
+	if c.TLSConfig != nil {
+		cfg = c.TLSConfig.Clone()
+	}
+	if cfg.ServerName == "" {
+		cfg.ServerName = u.Hostname()
+	}
+	if len(cfg.NextProtos) == 0 {
+		cfg.NextProtos = []string{"http/1.1"}
+	}
+	tlsConn := tls.Client(conn, cfg)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+// roundTrip writes req to the connection and reads the response head,
+// skipping interim 1xx responses.
+func (pc *persistConn) roundTrip(req *request.Request) (*Response, error) {
+	if err := req.Write(pc.conn); err != nil {
+		return nil, err
+	}
+
+	br := pc.br
+	for {
+		res, err := response.ResponseHeadFromReader(br, req.RequestLine.Method)
+		if err != nil {
+			return nil, err
+		}
+		code := res.StatusLine.StatusCode
+		if code >= 100 && code < 200 && code != response.StatusSwitchingProtocols {
+			continue
+		}
+		return &Response{
+			StatusLine: res.StatusLine,
+			Headers:    res.Headers,
+			Body:       io.NopCloser(res.BodyReader(br)),
+			response:   res,
+		}, nil
+	}
+}
+
+// contextError returns the context error behind err, a cancelled context
+// closes the connection and its deadline is set on the connection.
+func contextError(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	if _, ok := ctx.Deadline(); ok && errors.Is(err, os.ErrDeadlineExceeded) {
+		return context.DeadlineExceeded
+	}
+	return err
+}
+
+type body struct {
+	reader   io.ReadCloser
+	ctx      context.Context
+	response *response.Response
+	release  func(done bool)
+	released bool
+}
+
+func (b *body) Read(p []byte) (int, error) {
+	if b.released {
+		return 0, io.ErrClosedPipe
+	}
+	n, err := b.reader.Read(p)
+	if err == io.EOF {
+		b.released = true
+		b.release(true)
+	} else if err != nil {
+		err = contextError(b.ctx, err)
+	}
+	return n, err
+}
+
+// Close releases the connection, it only goes back to the pool when the
+// body was read completely.
+func (b *body) Close() error {
+	if !b.released {
+		b.released = true
+		b.release(b.response.Done())
+	}
+	return nil
+}
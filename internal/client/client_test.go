@@ -0,0 +1,138 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ramonvermeulen/httpfromtcp/internal/request"
+)
+
+// serveOnce accepts a single connection, reads one request from it and hands
+// both to respond.
+func serveOnce(t *testing.T, respond func(conn net.Conn, req *request.Request)) string {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { lis.Close() })
+
+	go func() {
+		conn, err := lis.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		req, err := request.RequestFromReader(bufio.NewReader(conn))
+		if err != nil {
+			return
+		}
+		respond(conn, req)
+	}()
+	return "http://" + lis.Addr().String()
+}
+
+func TestClient_Do(t *testing.T) {
+	// Test: request is sent in origin-form and the body is read
+	received := make(chan *request.Request, 1)
+	url := serveOnce(t, func(conn net.Conn, req *request.Request) {
+		received <- req
+		io.WriteString(conn, "HTTP/1.1 201 Created\r\nContent-Length: 5\r\n\r\nhello")
+	})
+	req, err := NewRequest("POST", url+"/submit?x=1", "ping")
+	require.NoError(t, err)
+	c := &Client{}
+	res, err := c.Do(context.Background(), req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	assert.Equal(t, 201, int(res.StatusLine.StatusCode))
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(body))
+
+	sent := <-received
+	assert.Equal(t, "POST", sent.RequestLine.Method)
+	assert.Equal(t, "/submit?x=1", sent.RequestLine.RequestTarget)
+	assert.Equal(t, "ping", sent.Body)
+	h, _ := sent.Headers.Get("host")
+	assert.Equal(t, url[len("http://"):], h)
+
+	// Test: chunked body is streamed before the response is complete
+	next := make(chan struct{})
+	url = serveOnce(t, func(conn net.Conn, req *request.Request) {
+		io.WriteString(conn, "HTTP/1.1 100 Continue\r\n\r\n")
+		io.WriteString(conn, "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\nTrailer: X-Sum\r\n\r\n5\r\nfirst\r\n")
+		<-next
+		io.WriteString(conn, "6\r\nsecond\r\n0\r\nX-Sum: 11\r\n\r\n")
+	})
+	req, err = NewRequest("GET", url+"/stream", "")
+	require.NoError(t, err)
+	res, err = c.Do(context.Background(), req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	assert.Equal(t, 200, int(res.StatusLine.StatusCode))
+	buf := make([]byte, 32)
+	n, err := res.Body.Read(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "first", string(buf[:n]))
+	close(next)
+	rest, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "second", string(rest))
+	h, _ = res.Trailers().Get("x-sum")
+	assert.Equal(t, "11", h)
+
+	// Test: timeout while waiting for the response
+	url = serveOnce(t, func(conn net.Conn, req *request.Request) {
+		time.Sleep(time.Second)
+	})
+	req, err = NewRequest("GET", url, "")
+	require.NoError(t, err)
+	c = &Client{Timeout: 50 * time.Millisecond}
+	_, err = c.Do(context.Background(), req)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// Test: context cancelled while reading the body
+	url = serveOnce(t, func(conn net.Conn, req *request.Request) {
+		io.WriteString(conn, "HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\nhello")
+		time.Sleep(time.Second)
+	})
+	req, err = NewRequest("GET", url, "")
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	res, err = (&Client{}).Do(ctx, req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	n, err = res.Body.Read(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(buf[:n]))
+	cancel()
+	_, err = res.Body.Read(buf)
+	require.ErrorIs(t, err, context.Canceled)
+}
+
+func TestNewRequest(t *testing.T) {
+	// Test: default host and request target
+	req, err := NewRequest("GET", "https://httpbin.org/get", "")
+	require.NoError(t, err)
+	assert.Equal(t, "https://httpbin.org/get", req.RequestLine.RequestTarget)
+	h, _ := req.Headers.Get("host")
+	assert.Equal(t, "httpbin.org", h)
+
+	// Test: unsupported scheme
+	_, err = NewRequest("GET", "ftp://example.com/", "")
+	require.ErrorIs(t, err, ErrUnsupportedScheme)
+
+	// Test: relative URL
+	_, err = NewRequest("GET", "/get", "")
+	require.Error(t, err)
+
+	// Test: unsupported method
+	_, err = NewRequest("BREW", "http://example.com/", "")
+	require.ErrorIs(t, err, request.ErrUnsupportedHTTPMethod)
+}
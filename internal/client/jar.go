@@ -0,0 +1,255 @@
+package client
+
+import (
+	"net"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Cookie is a cookie as stored in a Jar.
+type Cookie struct {
+	Name  string
+	Value string
+	// Domain is the host the cookie belongs to, with HostOnly set it is only
+	// sent to exactly that host, otherwise to its subdomains as well.
+	Domain   string
+	HostOnly bool
+	Path     string
+	// Expires is zero for session cookies.
+	Expires  time.Time
+	Secure   bool
+	HTTPOnly bool
+
+	created time.Time
+}
+
+// Jar stores the cookies set by responses and picks the ones to send with a
+// request, following the Domain, Path, Expires, Max-Age and Secure rules of
+// RFC 6265. Public suffixes are not checked, so a server can set a cookie
+// for a whole top level domain.
+type Jar struct {
+	mu      sync.Mutex
+	cookies map[string]*Cookie
+	now     func() time.Time
+}
+
+func NewJar() *Jar {
+	return &Jar{
+		cookies: make(map[string]*Cookie),
+		now:     time.Now,
+	}
+}
+
+// expiresLayouts are the date formats seen in the Expires attribute.
+var expiresLayouts = []string{
+	"Mon, 02 Jan 2006 15:04:05 GMT",
+	"Mon, 02-Jan-2006 15:04:05 GMT",
+	"Monday, 02-Jan-06 15:04:05 GMT",
+	"Mon Jan _2 15:04:05 2006",
+}
+
+// SetCookies stores the cookies from the Set-Cookie header value of a
+// response to a request for u.
+func (j *Jar) SetCookies(u *url.URL, setCookie string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	now := j.now()
+	for _, line := range splitSetCookie(setCookie) {
+		c, ok := parseSetCookie(u, line, now)
+		if !ok {
+			continue
+		}
+		key := c.Domain + ";" + c.Path + ";" + c.Name
+		if !c.Expires.IsZero() && !c.Expires.After(now) {
+			delete(j.cookies, key)
+			continue
+		}
+		if old, ok := j.cookies[key]; ok {
+			c.created = old.created
+		}
+		j.cookies[key] = c
+	}
+}
+
+// Cookies returns the cookies to send with a request for u, longer paths
+// first as RFC 6265 recommends.
+func (j *Jar) Cookies(u *url.URL) []*Cookie {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	now := j.now()
+	host := canonicalHost(u)
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+
+	var cookies []*Cookie
+	for key, c := range j.cookies {
+		if !c.Expires.IsZero() && !c.Expires.After(now) {
+			delete(j.cookies, key)
+			continue
+		}
+		if c.Secure && u.Scheme != "https" {
+			continue
+		}
+		if c.HostOnly && host != c.Domain || !c.HostOnly && !domainMatch(host, c.Domain) {
+			continue
+		}
+		if !pathMatch(path, c.Path) {
+			continue
+		}
+		cookies = append(cookies, c)
+	}
+
+	slices.SortFunc(cookies, func(a, b *Cookie) int {
+		if len(a.Path) != len(b.Path) {
+			return len(b.Path) - len(a.Path)
+		}
+		return a.created.Compare(b.created)
+	})
+	return cookies
+}
+
+// cookieHeader formats cookies as the value of a Cookie header.
+func cookieHeader(cookies []*Cookie) string {
+	pairs := make([]string, 0, len(cookies))
+	for _, c := range cookies {
+		pairs = append(pairs, c.Name+"="+c.Value)
+	}
+	return strings.Join(pairs, "; ")
+}
+
+// splitSetCookie splits a Set-Cookie value that holds several cookies. The
+// headers package joins repeated fields with a comma, which also shows up
+// inside Expires dates, so a comma only starts a new cookie when a name=value
+// pair follows it.
+func splitSetCookie(value string) []string {
+	var lines []string
+	start := 0
+	for i := 0; i < len(value); i++ {
+		if value[i] != ',' {
+			continue
+		}
+		next := value[i+1:]
+		if end := strings.IndexAny(next, ";,"); end != -1 {
+			next = next[:end]
+		}
+		if strings.Contains(next, "=") {
+			lines = append(lines, value[start:i])
+			start = i + 1
+		}
+	}
+	return append(lines, value[start:])
+}
+
+func parseSetCookie(u *url.URL, line string, now time.Time) (*Cookie, bool) {
+	parts := strings.Split(line, ";")
+	name, value, ok := strings.Cut(parts[0], "=")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" {
+		return nil, false
+	}
+
+	host := canonicalHost(u)
+	c := &Cookie{
+		Name:     name,
+		Value:    strings.Trim(strings.TrimSpace(value), `"`),
+		Domain:   host,
+		HostOnly: true,
+		Path:     defaultPath(u.EscapedPath()),
+		created:  now,
+	}
+
+	hasMaxAge := false
+	for _, attr := range parts[1:] {
+		key, val, _ := strings.Cut(attr, "=")
+		key = strings.ToLower(strings.TrimSpace(key))
+		val = strings.TrimSpace(val)
+
+		switch key {
+		case "domain":
+			domain := strings.ToLower(strings.TrimPrefix(val, "."))
+			if domain == "" {
+				continue
+			}
+			if !domainMatch(host, domain) {
+				return nil, false
+			}
+			if net.ParseIP(host) == nil {
+				c.Domain = domain
+				c.HostOnly = false
+			}
+		case "path":
+			if strings.HasPrefix(val, "/") {
+				c.Path = val
+			}
+		case "max-age":
+			seconds, err := strconv.Atoi(val)
+			if err != nil {
+				continue
+			}
+			hasMaxAge = true
+			if seconds <= 0 {
+				c.Expires = time.Unix(1, 0)
+			} else {
+				c.Expires = now.Add(time.Duration(seconds) * time.Second)
+			}
+		case "expires":
+			if hasMaxAge {
+				continue
+			}
+			for _, layout := range expiresLayouts {
+				if t, err := time.Parse(layout, val); err == nil {
+					c.Expires = t
+					break
+				}
+			}
+		case "secure":
+			c.Secure = true
+		case "httponly":
+			c.HTTPOnly = true
+		}
+	}
+	return c, true
+}
+
+func canonicalHost(u *url.URL) string {
+	return strings.ToLower(u.Hostname())
+}
+
+// domainMatch reports whether host is domain or one of its subdomains, IP
+// addresses only match themselves.
+func domainMatch(host, domain string) bool {
+	if host == domain {
+		return true
+	}
+	return net.ParseIP(host) == nil && strings.HasSuffix(host, "."+domain)
+}
+
+// defaultPath is the directory of the request path, see RFC 6265 5.1.4.
+func defaultPath(path string) string {
+	if !strings.HasPrefix(path, "/") {
+		return "/"
+	}
+	i := strings.LastIndex(path, "/")
+	if i == 0 {
+		return "/"
+	}
+	return path[:i]
+}
+
+func pathMatch(requestPath, cookiePath string) bool {
+	if requestPath == cookiePath {
+		return true
+	}
+	if !strings.HasPrefix(requestPath, cookiePath) {
+		return false
+	}
+	return strings.HasSuffix(cookiePath, "/") || requestPath[len(cookiePath)] == '/'
+}
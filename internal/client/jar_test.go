@@ -0,0 +1,79 @@
+package client
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func mustURL(t *testing.T, rawURL string) *url.URL {
+	t.Helper()
+	u, err := url.Parse(rawURL)
+	require.NoError(t, err)
+	return u
+}
+
+func cookieNames(cookies []*Cookie) []string {
+	names := []string{}
+	for _, c := range cookies {
+		names = append(names, c.Name)
+	}
+	return names
+}
+
+func TestJar(t *testing.T) {
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	jar := NewJar()
+	jar.now = func() time.Time { return now }
+
+	// Test: host-only cookie with default path
+	jar.SetCookies(mustURL(t, "http://example.com/account/login"), "session=abc")
+	assert.Equal(t, []string{"session"}, cookieNames(jar.Cookies(mustURL(t, "http://example.com/account/settings"))))
+	assert.Empty(t, jar.Cookies(mustURL(t, "http://example.com/")))
+	assert.Empty(t, jar.Cookies(mustURL(t, "http://www.example.com/account/settings")))
+
+	// Test: Domain attribute includes subdomains
+	jar.SetCookies(mustURL(t, "http://www.example.com/"), "lang=en; Domain=.example.com; Path=/")
+	assert.Equal(t, []string{"lang"}, cookieNames(jar.Cookies(mustURL(t, "http://api.example.com/"))))
+	assert.Empty(t, jar.Cookies(mustURL(t, "http://example.org/")))
+
+	// Test: Domain the host doesn't belong to is rejected
+	jar.SetCookies(mustURL(t, "http://www.example.com/"), "evil=1; Domain=example.org")
+	assert.Empty(t, jar.Cookies(mustURL(t, "http://example.org/")))
+
+	// Test: Path matching and ordering, longest path first
+	jar.SetCookies(mustURL(t, "http://example.com/"), "docs=1; Path=/docs")
+	assert.Equal(t, []string{"docs", "lang"}, cookieNames(jar.Cookies(mustURL(t, "http://example.com/docs/intro"))))
+	assert.Equal(t, []string{"lang"}, cookieNames(jar.Cookies(mustURL(t, "http://example.com/docsearch"))))
+
+	// Test: Secure cookies are only sent over https
+	jar.SetCookies(mustURL(t, "https://secure.test/"), "token=1; Secure")
+	assert.Empty(t, jar.Cookies(mustURL(t, "http://secure.test/")))
+	assert.Equal(t, []string{"token"}, cookieNames(jar.Cookies(mustURL(t, "https://secure.test/"))))
+
+	// Test: Expires, Max-Age wins and expired cookies delete earlier ones
+	jar.SetCookies(mustURL(t, "http://expire.test/"), "a=1; Expires=Tue, 20 Oct 2026 12:00:00 GMT, b=2; Max-Age=60; Expires=Sun, 18 Oct 2026 12:00:00 GMT, c=3; Expires=Sun, 18 Oct 2026 12:00:00 GMT")
+	assert.ElementsMatch(t, []string{"a", "b"}, cookieNames(jar.Cookies(mustURL(t, "http://expire.test/"))))
+	now = now.Add(2 * time.Minute)
+	assert.Equal(t, []string{"a"}, cookieNames(jar.Cookies(mustURL(t, "http://expire.test/"))))
+	jar.SetCookies(mustURL(t, "http://expire.test/"), "a=deleted; Max-Age=0")
+	assert.Empty(t, jar.Cookies(mustURL(t, "http://expire.test/")))
+	now = now.Add(48 * time.Hour)
+	assert.Equal(t, []string{"lang"}, cookieNames(jar.Cookies(mustURL(t, "http://example.com/"))))
+
+	// Test: Cookie header value
+	jar.SetCookies(mustURL(t, "http://example.com/"), "theme=dark")
+	assert.Equal(t, "lang=en; theme=dark", cookieHeader(jar.Cookies(mustURL(t, "http://example.com/"))))
+}
+
+func TestSplitSetCookie(t *testing.T) {
+	assert.Equal(t, []string{"a=1"}, splitSetCookie("a=1"))
+	assert.Equal(t, []string{"a=1", " b=2; Path=/"}, splitSetCookie("a=1, b=2; Path=/"))
+	assert.Equal(t,
+		[]string{"a=1; Expires=Wed, 21 Oct 2015 07:28:00 GMT; Secure", " b=2"},
+		splitSetCookie("a=1; Expires=Wed, 21 Oct 2015 07:28:00 GMT; Secure, b=2"),
+	)
+}
@@ -0,0 +1,131 @@
+package client
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultMaxIdleConnsPerHost = 2
+	DefaultIdleConnTimeout     = 90 * time.Second
+)
+
+// aLongTimeAgo is a read deadline in the past, it makes a blocked read return
+// right away.
+var aLongTimeAgo = time.Unix(1, 0)
+
+// persistConn is a connection that can carry several requests after each
+// other, together with the reader that holds any bytes read ahead.
+type persistConn struct {
+	key  string
+	conn net.Conn
+	br   *bufio.Reader
+
+	idleTimer *time.Timer
+	readDone  chan error
+}
+
+// pool keeps idle keep-alive connections keyed by scheme and host:port. While
+// a connection is idle a background read watches it, the server closing the
+// connection or sending unsolicited data ends that read and evicts it.
+type pool struct {
+	mu   sync.Mutex
+	idle map[string][]*persistConn
+}
+
+// get returns a healthy idle connection for key, or nil if there is none.
+func (p *pool) get(key string) *persistConn {
+	for {
+		p.mu.Lock()
+		conns := p.idle[key]
+		if len(conns) == 0 {
+			p.mu.Unlock()
+			return nil
+		}
+		pc := conns[len(conns)-1]
+		p.idle[key] = conns[:len(conns)-1]
+		p.mu.Unlock()
+
+		pc.idleTimer.Stop()
+		if pc.takeOver() {
+			return pc
+		}
+		pc.conn.Close()
+	}
+}
+
+// takeOver stops the background read of an idle connection. The connection
+// is only healthy if that read was still blocked, anything it returned means
+// the server closed the connection or is out of sync with us.
+func (pc *persistConn) takeOver() bool {
+	pc.conn.SetReadDeadline(aLongTimeAgo)
+	err := <-pc.readDone
+	if !errors.Is(err, os.ErrDeadlineExceeded) || pc.br.Buffered() > 0 {
+		return false
+	}
+	return pc.conn.SetReadDeadline(time.Time{}) == nil
+}
+
+// put makes pc available for reuse, it is closed instead when the host
+// already has max idle connections.
+func (p *pool) put(pc *persistConn, max int, timeout time.Duration) {
+	pc.conn.SetDeadline(time.Time{})
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.idle == nil {
+		p.idle = make(map[string][]*persistConn)
+	}
+	if len(p.idle[pc.key]) >= max {
+		pc.conn.Close()
+		return
+	}
+	p.idle[pc.key] = append(p.idle[pc.key], pc)
+
+	pc.readDone = make(chan error, 1)
+	go func() {
+		_, err := pc.br.Peek(1)
+		pc.readDone <- err
+		if p.remove(pc) {
+			pc.conn.Close()
+		}
+	}()
+	pc.idleTimer = time.AfterFunc(timeout, func() {
+		if p.remove(pc) {
+			pc.conn.Close()
+		}
+	})
+}
+
+// remove takes pc out of the pool, it reports whether pc was still idle.
+func (p *pool) remove(pc *persistConn) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	conns := p.idle[pc.key]
+	for i, c := range conns {
+		if c == pc {
+			p.idle[pc.key] = append(conns[:i], conns[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// closeIdle closes all idle connections.
+func (p *pool) closeIdle() {
+	p.mu.Lock()
+	idle := p.idle
+	p.idle = nil
+	p.mu.Unlock()
+
+	for _, conns := range idle {
+		for _, pc := range conns {
+			pc.idleTimer.Stop()
+			pc.conn.Close()
+		}
+	}
+}
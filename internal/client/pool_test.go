@@ -0,0 +1,157 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ramonvermeulen/httpfromtcp/internal/request"
+)
+
+// keepAliveServer answers every request on a connection with respond until
+// respond returns false, it counts the connections it accepted.
+func keepAliveServer(t *testing.T, respond func(conn net.Conn, req *request.Request) bool) (string, *atomic.Int32) {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { lis.Close() })
+
+	accepted := &atomic.Int32{}
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			accepted.Add(1)
+			go func() {
+				defer conn.Close()
+				br := bufio.NewReader(conn)
+				for {
+					req, err := request.RequestFromReader(br)
+					if err != nil || req.RequestLine.Method == "" {
+						return
+					}
+					if !respond(conn, req) {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return "http://" + lis.Addr().String(), accepted
+}
+
+func fetch(t *testing.T, c *Client, url string) string {
+	t.Helper()
+	req, err := NewRequest("GET", url, "")
+	require.NoError(t, err)
+	res, err := c.Do(context.Background(), req)
+	require.NoError(t, err)
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	require.NoError(t, res.Body.Close())
+	return string(body)
+}
+
+func TestClient_Pool(t *testing.T) {
+	ok := func(conn net.Conn, req *request.Request) bool {
+		io.WriteString(conn, "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok")
+		return true
+	}
+
+	// Test: sequential requests share one connection
+	url, accepted := keepAliveServer(t, ok)
+	c := &Client{}
+	for range 3 {
+		assert.Equal(t, "ok", fetch(t, c, url))
+	}
+	assert.Equal(t, int32(1), accepted.Load())
+
+	// Test: chunked responses are reused as well
+	url, accepted = keepAliveServer(t, func(conn net.Conn, req *request.Request) bool {
+		io.WriteString(conn, "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n2\r\nok\r\n0\r\n\r\n")
+		return true
+	})
+	for range 3 {
+		assert.Equal(t, "ok", fetch(t, c, url))
+	}
+	assert.Equal(t, int32(1), accepted.Load())
+
+	// Test: Connection: close from the server is honored
+	url, accepted = keepAliveServer(t, func(conn net.Conn, req *request.Request) bool {
+		io.WriteString(conn, "HTTP/1.1 200 OK\r\nContent-Length: 2\r\nConnection: close\r\n\r\nok")
+		return false
+	})
+	for range 2 {
+		assert.Equal(t, "ok", fetch(t, c, url))
+	}
+	assert.Equal(t, int32(2), accepted.Load())
+
+	// Test: connection closed by the server while idle is not reused
+	url, accepted = keepAliveServer(t, func(conn net.Conn, req *request.Request) bool {
+		io.WriteString(conn, "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok")
+		return false
+	})
+	assert.Equal(t, "ok", fetch(t, c, url))
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, "ok", fetch(t, c, url))
+	assert.Equal(t, int32(2), accepted.Load())
+
+	// Test: idle timeout
+	url, accepted = keepAliveServer(t, ok)
+	c = &Client{IdleConnTimeout: 20 * time.Millisecond}
+	assert.Equal(t, "ok", fetch(t, c, url))
+	time.Sleep(60 * time.Millisecond)
+	assert.Equal(t, "ok", fetch(t, c, url))
+	assert.Equal(t, int32(2), accepted.Load())
+
+	// Test: body closed before it was read completely is not reused
+	url, accepted = keepAliveServer(t, func(conn net.Conn, req *request.Request) bool {
+		io.WriteString(conn, "HTTP/1.1 200 OK\r\nContent-Length: 4\r\n\r\nok")
+		time.Sleep(20 * time.Millisecond)
+		io.WriteString(conn, "ok")
+		return true
+	})
+	c = &Client{}
+	req, err := NewRequest("GET", url, "")
+	require.NoError(t, err)
+	res, err := c.Do(context.Background(), req)
+	require.NoError(t, err)
+	require.NoError(t, res.Body.Close())
+	assert.Equal(t, "okok", fetch(t, c, url))
+	assert.Equal(t, int32(2), accepted.Load())
+
+	// Test: pooling disabled
+	url, accepted = keepAliveServer(t, ok)
+	c = &Client{MaxIdleConnsPerHost: -1}
+	for range 2 {
+		assert.Equal(t, "ok", fetch(t, c, url))
+	}
+	assert.Equal(t, int32(2), accepted.Load())
+
+	// Test: idle connections per host are limited
+	url, accepted = keepAliveServer(t, ok)
+	c = &Client{MaxIdleConnsPerHost: 1}
+	req, err = NewRequest("GET", url, "")
+	require.NoError(t, err)
+	first, err := c.Do(context.Background(), req)
+	require.NoError(t, err)
+	second, err := c.Do(context.Background(), req)
+	require.NoError(t, err)
+	io.ReadAll(first.Body)
+	io.ReadAll(second.Body)
+	assert.Equal(t, int32(2), accepted.Load())
+	u, err := parseURL(url)
+	require.NoError(t, err)
+	assert.Len(t, c.pool.idle[connKey(u)], 1)
+	c.CloseIdleConnections()
+	assert.Empty(t, c.pool.idle)
+}
@@ -0,0 +1,113 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+
+	"github.com/ramonvermeulen/httpfromtcp/internal/request"
+	"github.com/ramonvermeulen/httpfromtcp/internal/response"
+)
+
+const DefaultMaxRedirects = 10
+
+var (
+	ErrTooManyRedirects = fmt.Errorf("stopped after too many redirects")
+	ErrMissingLocation  = fmt.Errorf("redirect response without Location header")
+)
+
+// maxDrain is how much of a redirect body is read to be able to reuse the
+// connection, bigger bodies close it instead.
+const maxDrain = 4 << 10
+
+// follow sends req and follows the redirects it gets back.
+func (c *Client) follow(ctx context.Context, req *request.Request) (*Response, error) {
+	max := c.MaxRedirects
+	if max == 0 {
+		max = DefaultMaxRedirects
+	}
+
+	for hops := 0; ; hops++ {
+		res, err := c.sendWithRetry(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		if max < 0 || !isRedirect(res.StatusLine.StatusCode) {
+			return res, nil
+		}
+
+		next, err := redirectRequest(req, res)
+		io.Copy(io.Discard, io.LimitReader(res.Body, maxDrain))
+		res.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if hops >= max {
+			return nil, fmt.Errorf("%w: %d", ErrTooManyRedirects, max)
+		}
+		req = next
+	}
+}
+
+func isRedirect(code response.StatusCode) bool {
+	switch code {
+	case response.StatusMovedPermanently, response.StatusFound, response.StatusSeeOther,
+		response.StatusTemporaryRedirect, response.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
+
+// redirectRequest builds the request for the Location of res. 307 and 308
+// repeat the request as is, 303 switches to GET and so do 301 and 302 for
+// a POST, like browsers do. A switch to GET drops the body, leaving the
+// origin drops the credentials.
+func redirectRequest(req *request.Request, res *Response) (*request.Request, error) {
+	location, ok := res.Headers.Get("location")
+	if !ok {
+		return nil, ErrMissingLocation
+	}
+	from, err := url.Parse(req.RequestLine.RequestTarget)
+	if err != nil {
+		return nil, err
+	}
+	to, err := from.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+	to.Fragment = ""
+	if _, err := parseURL(to.String()); err != nil {
+		return nil, err
+	}
+
+	method := req.RequestLine.Method
+	body := req.Body
+	code := res.StatusLine.StatusCode
+	if code == response.StatusSeeOther && method != "HEAD" ||
+		(code == response.StatusMovedPermanently || code == response.StatusFound) && method == "POST" {
+		method = "GET"
+		body = ""
+	}
+
+	next := request.NewRequest()
+	next.RequestLine = request.RequestLine{
+		Method:        method,
+		RequestTarget: to.String(),
+		HTTPVersion:   req.RequestLine.HTTPVersion,
+	}
+	next.Body = body
+	for key, value := range req.Headers {
+		next.Headers.Replace(key, value)
+	}
+	next.Headers.Replace("Host", to.Host)
+	next.Headers.Del("Cookie")
+	if body == "" {
+		next.Headers.Del("Content-Length")
+		next.Headers.Del("Content-Type")
+	}
+	if connKey(from) != connKey(to) {
+		next.Headers.Del("Authorization")
+	}
+	return next, nil
+}
@@ -0,0 +1,88 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ramonvermeulen/httpfromtcp/internal/request"
+)
+
+func TestClient_Redirects(t *testing.T) {
+	seen := make(chan *request.Request, 10)
+	url, _ := keepAliveServer(t, func(conn net.Conn, req *request.Request) bool {
+		seen <- req
+		switch req.RequestLine.RequestTarget {
+		case "/found":
+			io.WriteString(conn, "HTTP/1.1 302 Found\r\nLocation: /target\r\nContent-Length: 5\r\n\r\nmoved")
+		case "/temporary":
+			io.WriteString(conn, "HTTP/1.1 307 Temporary Redirect\r\nLocation: target\r\nContent-Length: 0\r\n\r\n")
+		case "/see-other":
+			io.WriteString(conn, "HTTP/1.1 303 See Other\r\nLocation: /target\r\nContent-Length: 0\r\n\r\n")
+		case "/loop":
+			io.WriteString(conn, "HTTP/1.1 301 Moved Permanently\r\nLocation: /loop\r\nContent-Length: 0\r\n\r\n")
+		default:
+			body := fmt.Sprintf("%s %s", req.RequestLine.Method, req.Body)
+			fmt.Fprintf(conn, "HTTP/1.1 200 OK\r\nContent-Length: %d\r\n\r\n%s", len(body), body)
+		}
+		return true
+	})
+	c := &Client{}
+	post := func(path string) (*Response, string, error) {
+		req, err := NewRequest("POST", url+path, "data")
+		require.NoError(t, err)
+		req.Headers.Set("Content-Type", "text/plain")
+		res, err := c.Do(context.Background(), req)
+		if err != nil {
+			return nil, "", err
+		}
+		defer res.Body.Close()
+		body, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+		return res, string(body), nil
+	}
+
+	// Test: 302 turns a POST into a GET without body
+	_, body, err := post("/found")
+	require.NoError(t, err)
+	assert.Equal(t, "GET ", body)
+	<-seen
+	target := <-seen
+	assert.Equal(t, "/target", target.RequestLine.RequestTarget)
+	_, ok := target.Headers.Get("content-type")
+	assert.False(t, ok)
+
+	// Test: 307 repeats the POST with its body, relative Location
+	_, body, err = post("/temporary")
+	require.NoError(t, err)
+	assert.Equal(t, "POST data", body)
+	<-seen
+	assert.Equal(t, "/target", (<-seen).RequestLine.RequestTarget)
+
+	// Test: 303 turns a POST into a GET
+	_, body, err = post("/see-other")
+	require.NoError(t, err)
+	assert.Equal(t, "GET ", body)
+	<-seen
+	<-seen
+
+	// Test: redirect loops stop
+	c = &Client{MaxRedirects: 3}
+	_, _, err = post("/loop")
+	require.ErrorIs(t, err, ErrTooManyRedirects)
+	for range 4 {
+		<-seen
+	}
+
+	// Test: redirects not followed
+	c = &Client{MaxRedirects: -1}
+	res, body, err := post("/found")
+	require.NoError(t, err)
+	assert.Equal(t, 302, int(res.StatusLine.StatusCode))
+	assert.Equal(t, "moved", body)
+}
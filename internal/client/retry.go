@@ -0,0 +1,75 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"syscall"
+	"time"
+
+	"github.com/ramonvermeulen/httpfromtcp/internal/request"
+	"github.com/ramonvermeulen/httpfromtcp/internal/response"
+)
+
+// RetryPolicy retries requests whose connection broke before a response
+// came back. Only idempotent methods are retried, since a request with
+// another method might have been processed already.
+type RetryPolicy struct {
+	// MaxRetries is how often a request is retried, zero disables retries.
+	MaxRetries int
+	// Backoff is the wait before the first retry, it doubles for every
+	// following retry up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxRetries: 2,
+	Backoff:    50 * time.Millisecond,
+	MaxBackoff: time.Second,
+}
+
+var idempotentMethods = []string{"GET", "HEAD", "PUT", "DELETE", "OPTIONS", "TRACE"}
+
+func (c *Client) sendWithRetry(ctx context.Context, req *request.Request) (*Response, error) {
+	policy := DefaultRetryPolicy
+	if c.Retry != nil {
+		policy = *c.Retry
+	}
+	backoff := policy.Backoff
+
+	for attempt := 0; ; attempt++ {
+		res, err := c.send(ctx, req)
+		if err == nil || attempt >= policy.MaxRetries || !isIdempotent(req) || !isConnectionBroken(err) {
+			return res, err
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+		backoff = min(backoff*2, max(policy.MaxBackoff, policy.Backoff))
+	}
+}
+
+func isIdempotent(req *request.Request) bool {
+	for _, m := range idempotentMethods {
+		if req.RequestLine.Method == m {
+			return true
+		}
+	}
+	return false
+}
+
+// isConnectionBroken reports whether err means the server reset or closed
+// the connection before responding, which happens when a pooled connection
+// is closed by the server at the moment it is reused.
+func isConnectionBroken(err error) bool {
+	return errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, response.ErrIncompleteResponse)
+}
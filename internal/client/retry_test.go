@@ -0,0 +1,56 @@
+package client
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ramonvermeulen/httpfromtcp/internal/request"
+)
+
+func TestClient_Retry(t *testing.T) {
+	// resetFirst resets the first n connections after reading the request
+	resetFirst := func(n int32) string {
+		var count atomic.Int32
+		url, _ := keepAliveServer(t, func(conn net.Conn, req *request.Request) bool {
+			if count.Add(1) <= n {
+				conn.(*net.TCPConn).SetLinger(0)
+				return false
+			}
+			io.WriteString(conn, "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok")
+			return true
+		})
+		return url
+	}
+	policy := &RetryPolicy{MaxRetries: 2, Backoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond}
+
+	// Test: GET is retried after connection resets
+	c := &Client{Retry: policy}
+	assert.Equal(t, "ok", fetch(t, c, resetFirst(2)))
+
+	// Test: retries give up eventually
+	req, err := NewRequest("GET", resetFirst(3), "")
+	require.NoError(t, err)
+	_, err = c.Do(context.Background(), req)
+	require.Error(t, err)
+	assert.True(t, isConnectionBroken(err), err)
+
+	// Test: POST is never retried
+	req, err = NewRequest("POST", resetFirst(1), "data")
+	require.NoError(t, err)
+	_, err = c.Do(context.Background(), req)
+	require.Error(t, err)
+
+	// Test: retries disabled
+	c = &Client{Retry: &RetryPolicy{}}
+	req, err = NewRequest("GET", resetFirst(1), "")
+	require.NoError(t, err)
+	_, err = c.Do(context.Background(), req)
+	require.Error(t, err)
+}
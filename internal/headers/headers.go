@@ -3,6 +3,7 @@ package headers
 import (
 	"bytes"
 	"fmt"
+	"slices"
 	"strings"
 )
 
@@ -32,6 +33,35 @@ func isValidToken(bytes []byte) bool {
 	return true
 }
 
+// IsToken reports whether s is a non-empty token, the grammar used for field
+// names, transfer codings and chunk extension names.
+func IsToken(s string) bool {
+	return len(s) > 0 && !strings.Contains(s, ":") && isValidToken([]byte(s))
+}
+
+// ConnectionHeaders are only meaningful for a single HTTP/1.1 connection,
+// they are neither forwarded nor allowed in HTTP/2.
+var ConnectionHeaders = []string{"connection", "keep-alive", "proxy-connection", "transfer-encoding", "upgrade"}
+
+// ListValues splits a comma separated field value into its lowercased
+// members.
+func ListValues(value string) []string {
+	var values []string
+	for v := range strings.SplitSeq(value, ",") {
+		v = strings.ToLower(strings.TrimSpace(v))
+		if v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// HasToken reports whether the comma separated list contains token, ignoring
+// case.
+func HasToken(list, token string) bool {
+	return slices.Contains(ListValues(list), strings.ToLower(token))
+}
+
 func parseSingleHeader(fieldLine []byte) (string, string, error) {
 	rKey, rValue, _ := bytes.Cut(fieldLine, ValueSeparator)
 	key := bytes.TrimSpace(bytes.ToLower(rKey))
@@ -82,3 +82,13 @@ func TestHeaders_Parse(t *testing.T) {
 	assert.Equal(t, 0, n)
 	assert.False(t, done)
 }
+
+func TestListValues(t *testing.T) {
+	// Test: members are trimmed and lowercased, empty ones are dropped
+	assert.Equal(t, []string{"keep-alive", "upgrade"}, ListValues(" Keep-Alive, ,Upgrade "))
+	assert.Nil(t, ListValues(""))
+
+	// Test: tokens are matched ignoring case
+	assert.True(t, HasToken("keep-alive, Upgrade", "upgrade"))
+	assert.False(t, HasToken("upgrade-insecure", "upgrade"))
+}
@@ -0,0 +1,106 @@
+package hpack
+
+// Decoder decodes header blocks of one connection, it keeps the dynamic
+// table between blocks.
+type Decoder struct {
+	// MaxStringLength limits the length of a single name or value, zero
+	// means no limit.
+	MaxStringLength int
+
+	table dynamicTable
+	// maxTableSize is the largest table size the peer may choose, it is the
+	// header table size this side announced.
+	maxTableSize int
+}
+
+func NewDecoder(maxTableSize int) *Decoder {
+	return &Decoder{
+		table:        dynamicTable{maxSize: maxTableSize},
+		maxTableSize: maxTableSize,
+	}
+}
+
+// SetMaxTableSize changes the limit for table size updates of the peer,
+// after the new limit was acknowledged.
+func (d *Decoder) SetMaxTableSize(size int) {
+	d.maxTableSize = size
+	if d.table.maxSize > size {
+		d.table.setMaxSize(size)
+	}
+}
+
+// Decode decodes a complete header block.
+func (d *Decoder) Decode(block []byte) ([]HeaderField, error) {
+	var fields []HeaderField
+	p := block
+	for len(p) > 0 {
+		var f HeaderField
+		var err error
+		b := p[0]
+		switch {
+		case b&0x80 != 0:
+			// indexed header field
+			var index uint64
+			index, p, err = readInt(p, 7)
+			if err == nil {
+				f, err = d.table.field(index)
+			}
+		case b&0xc0 == 0x40:
+			// literal with incremental indexing
+			f, p, err = d.readLiteral(p, 6)
+			if err == nil {
+				d.table.add(f)
+			}
+		case b&0xe0 == 0x20:
+			// dynamic table size update
+			if len(fields) > 0 {
+				return nil, ErrLateSizeUpdate
+			}
+			var size uint64
+			size, p, err = readInt(p, 5)
+			if err != nil {
+				return nil, err
+			}
+			if size > uint64(d.maxTableSize) {
+				return nil, ErrTableSizeExceeded
+			}
+			d.table.setMaxSize(int(size))
+			continue
+		default:
+			// literal without indexing (0000) or never indexed (0001)
+			f, p, err = d.readLiteral(p, 4)
+			f.Sensitive = b&0xf0 == 0x10
+		}
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+// readLiteral reads a literal field whose name index has an n-bit prefix.
+func (d *Decoder) readLiteral(p []byte, n uint) (HeaderField, []byte, error) {
+	var f HeaderField
+	index, p, err := readInt(p, n)
+	if err != nil {
+		return f, nil, err
+	}
+	if index > 0 {
+		named, err := d.table.field(index)
+		if err != nil {
+			return f, nil, err
+		}
+		f.Name = named.Name
+	} else {
+		f.Name, p, err = readString(p, d.MaxStringLength)
+		if err != nil {
+			return f, nil, err
+		}
+	}
+	f.Value, p, err = readString(p, d.MaxStringLength)
+	if err != nil {
+		return f, nil, err
+	}
+	return f, p, nil
+}
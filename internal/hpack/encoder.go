@@ -0,0 +1,128 @@
+package hpack
+
+import "strings"
+
+// Encoder encodes header blocks of one connection. Fields are added to the
+// dynamic table so that repeating them costs a single byte, except for
+// sensitive fields, which are sent as never indexed literals.
+type Encoder struct {
+	// DisableHuffman sends all strings as is, by default a string is
+	// Huffman coded unless that makes it longer.
+	DisableHuffman bool
+
+	table dynamicTable
+	// sizeUpdate is set when the table size changed since the last header
+	// block, minSize is the smallest size it had in between.
+	sizeUpdate bool
+	minSize    int
+}
+
+func NewEncoder(maxTableSize int) *Encoder {
+	return &Encoder{table: dynamicTable{maxSize: maxTableSize}}
+}
+
+// SetMaxTableSize changes the size of the dynamic table, at most the header
+// table size the peer announced. The change is signalled at the start of the
+// next header block.
+func (e *Encoder) SetMaxTableSize(size int) {
+	if !e.sizeUpdate || size < e.minSize {
+		e.minSize = size
+	}
+	e.sizeUpdate = true
+	e.table.setMaxSize(size)
+}
+
+// AppendField appends the encoding of f to dst.
+func (e *Encoder) AppendField(dst []byte, f HeaderField) []byte {
+	if e.sizeUpdate {
+		if e.minSize < e.table.maxSize {
+			dst = appendInt(dst, 0x20, 5, uint64(e.minSize))
+		}
+		dst = appendInt(dst, 0x20, 5, uint64(e.table.maxSize))
+		e.sizeUpdate = false
+	}
+
+	sensitive := f.Sensitive || isSensitive(f)
+	index, nameIndex := e.search(f)
+	if index > 0 && !sensitive {
+		return appendInt(dst, 0x80, 7, uint64(index))
+	}
+
+	switch {
+	case sensitive:
+		dst = appendInt(dst, 0x10, 4, uint64(nameIndex))
+	case f.Size() > e.table.maxSize:
+		// Adding the field would only empty the table.
+		dst = appendInt(dst, 0x00, 4, uint64(nameIndex))
+	default:
+		dst = appendInt(dst, 0x40, 6, uint64(nameIndex))
+		e.table.add(HeaderField{Name: f.Name, Value: f.Value})
+	}
+	if nameIndex == 0 {
+		dst = e.appendString(dst, f.Name)
+	}
+	return e.appendString(dst, f.Value)
+}
+
+// Encode returns the header block for fields.
+func (e *Encoder) Encode(fields []HeaderField) []byte {
+	var dst []byte
+	for _, f := range fields {
+		dst = e.AppendField(dst, f)
+	}
+	return dst
+}
+
+// search returns the index of a table entry matching f and the index of an
+// entry with the name of f, zero when there is none.
+func (e *Encoder) search(f HeaderField) (int, int) {
+	nameIndex := 0
+	for i, sf := range staticTable {
+		if sf.Name != f.Name {
+			continue
+		}
+		if sf.Value == f.Value {
+			return i + 1, i + 1
+		}
+		if nameIndex == 0 {
+			nameIndex = i + 1
+		}
+	}
+	for i, df := range e.table.fields {
+		if df.Name != f.Name {
+			continue
+		}
+		if df.Value == f.Value {
+			return len(staticTable) + i + 1, len(staticTable) + i + 1
+		}
+		if nameIndex == 0 {
+			nameIndex = len(staticTable) + i + 1
+		}
+	}
+	return 0, nameIndex
+}
+
+func (e *Encoder) appendString(dst []byte, s string) []byte {
+	if !e.DisableHuffman {
+		if n := HuffmanEncodedLen(s); n <= len(s) {
+			dst = appendInt(dst, 0x80, 7, uint64(n))
+			return AppendHuffman(dst, s)
+		}
+	}
+	dst = appendInt(dst, 0x00, 7, uint64(len(s)))
+	return append(dst, s...)
+}
+
+// isSensitive reports whether a field carries credentials that must not end
+// up in a dynamic table, where they could be guessed by an attacker who
+// controls other fields on the connection (RFC 7541 section 7.1). Short
+// cookies are easy to guess as well.
+func isSensitive(f HeaderField) bool {
+	switch strings.ToLower(f.Name) {
+	case "authorization", "proxy-authorization":
+		return true
+	case "cookie":
+		return len(f.Value) < 20
+	}
+	return false
+}
@@ -0,0 +1,51 @@
+package hpack
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/ramonvermeulen/httpfromtcp/internal/headers"
+)
+
+// Fields returns the fields of h sorted by name, pseudo-header fields such as
+// ":status" first as HTTP/2 requires.
+func Fields(h headers.Headers) []HeaderField {
+	fields := make([]HeaderField, 0, len(h))
+	for name, value := range h {
+		fields = append(fields, HeaderField{Name: name, Value: value})
+	}
+	slices.SortFunc(fields, func(a, b HeaderField) int {
+		aPseudo, bPseudo := strings.HasPrefix(a.Name, ":"), strings.HasPrefix(b.Name, ":")
+		if aPseudo != bPseudo {
+			if aPseudo {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+	return fields
+}
+
+// EncodeHeaders returns the header block for h.
+func (e *Encoder) EncodeHeaders(h headers.Headers) []byte {
+	return e.Encode(Fields(h))
+}
+
+// DecodeHeaders decodes a header block into headers. Repeated fields are
+// combined into one, cookies with "; " as separator.
+func (d *Decoder) DecodeHeaders(block []byte) (headers.Headers, error) {
+	fields, err := d.Decode(block)
+	if err != nil {
+		return nil, err
+	}
+	h := headers.NewHeaders()
+	for _, f := range fields {
+		if v, ok := h.Get(f.Name); ok && f.Name == "cookie" {
+			h.Replace(f.Name, v+"; "+f.Value)
+			continue
+		}
+		h.Set(f.Name, f.Value)
+	}
+	return h, nil
+}
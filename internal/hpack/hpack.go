@@ -0,0 +1,154 @@
+// Package hpack implements HPACK, the header compression of HTTP/2 defined
+// in RFC 7541.
+package hpack
+
+import (
+	"errors"
+	"fmt"
+)
+
+// DefaultTableSize is the initial size of the dynamic table, both for the
+// encoder and the decoder.
+const DefaultTableSize = 4096
+
+var (
+	ErrInvalidIndex      = errors.New("hpack: invalid table index")
+	ErrIntegerOverflow   = errors.New("hpack: integer overflow")
+	ErrTruncated         = errors.New("hpack: truncated header block")
+	ErrInvalidHuffman    = errors.New("hpack: invalid huffman encoding")
+	ErrStringTooLong     = errors.New("hpack: string exceeds limit")
+	ErrTableSizeExceeded = errors.New("hpack: dynamic table size update exceeds limit")
+	ErrLateSizeUpdate    = errors.New("hpack: dynamic table size update after header field")
+)
+
+// HeaderField is a single header field. Sensitive fields are never added to
+// a dynamic table, by this encoder nor by any intermediary.
+type HeaderField struct {
+	Name      string
+	Value     string
+	Sensitive bool
+}
+
+// Size is the size of the field in the dynamic table.
+func (f HeaderField) Size() int {
+	return len(f.Name) + len(f.Value) + 32
+}
+
+func (f HeaderField) String() string {
+	return fmt.Sprintf("%s: %s", f.Name, f.Value)
+}
+
+// dynamicTable holds the fields added to it, the newest first.
+type dynamicTable struct {
+	fields  []HeaderField
+	size    int
+	maxSize int
+}
+
+func (t *dynamicTable) add(f HeaderField) {
+	t.fields = append([]HeaderField{f}, t.fields...)
+	t.size += f.Size()
+	t.evict()
+}
+
+func (t *dynamicTable) setMaxSize(size int) {
+	t.maxSize = size
+	t.evict()
+}
+
+func (t *dynamicTable) evict() {
+	for t.size > t.maxSize && len(t.fields) > 0 {
+		last := t.fields[len(t.fields)-1]
+		t.fields = t.fields[:len(t.fields)-1]
+		t.size -= last.Size()
+	}
+}
+
+// field returns the field at index of the combined static and dynamic
+// table.
+func (t *dynamicTable) field(index uint64) (HeaderField, error) {
+	switch {
+	case index == 0:
+		return HeaderField{}, ErrInvalidIndex
+	case index <= uint64(len(staticTable)):
+		return staticTable[index-1], nil
+	case index-uint64(len(staticTable)) <= uint64(len(t.fields)):
+		return t.fields[index-uint64(len(staticTable))-1], nil
+	default:
+		return HeaderField{}, ErrInvalidIndex
+	}
+}
+
+// appendInt appends i encoded with an n-bit prefix, the other bits of the
+// first byte are taken from first.
+func appendInt(dst []byte, first byte, n uint, i uint64) []byte {
+	limit := uint64(1)<<n - 1
+	if i < limit {
+		return append(dst, first|byte(i))
+	}
+	dst = append(dst, first|byte(limit))
+	i -= limit
+	for i >= 128 {
+		dst = append(dst, byte(i&0x7f)|0x80)
+		i >>= 7
+	}
+	return append(dst, byte(i))
+}
+
+// readInt reads an integer with an n-bit prefix, it returns the value and
+// the rest of p.
+func readInt(p []byte, n uint) (uint64, []byte, error) {
+	if len(p) == 0 {
+		return 0, nil, ErrTruncated
+	}
+	limit := uint64(1)<<n - 1
+	i := uint64(p[0]) & limit
+	p = p[1:]
+	if i < limit {
+		return i, p, nil
+	}
+	for shift := uint(0); ; shift += 7 {
+		if len(p) == 0 {
+			return 0, nil, ErrTruncated
+		}
+		if shift > 28 {
+			return 0, nil, ErrIntegerOverflow
+		}
+		b := p[0]
+		p = p[1:]
+		i += uint64(b&0x7f) << shift
+		if b&0x80 == 0 {
+			return i, p, nil
+		}
+	}
+}
+
+// readString reads a string literal, decoding it if it is Huffman coded.
+func readString(p []byte, maxLen int) (string, []byte, error) {
+	if len(p) == 0 {
+		return "", nil, ErrTruncated
+	}
+	huffman := p[0]&0x80 != 0
+	n, p, err := readInt(p, 7)
+	if err != nil {
+		return "", nil, err
+	}
+	if n > uint64(len(p)) {
+		return "", nil, ErrTruncated
+	}
+	if maxLen > 0 && n > uint64(maxLen) {
+		return "", nil, ErrStringTooLong
+	}
+	raw, p := p[:n], p[n:]
+	if !huffman {
+		return string(raw), p, nil
+	}
+	s, err := HuffmanDecode(raw)
+	if err != nil {
+		return "", nil, err
+	}
+	if maxLen > 0 && len(s) > maxLen {
+		return "", nil, ErrStringTooLong
+	}
+	return s, p, nil
+}
@@ -0,0 +1,286 @@
+package hpack
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ramonvermeulen/httpfromtcp/internal/headers"
+)
+
+func unhex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(strings.Join(strings.Fields(s), ""))
+	require.NoError(t, err)
+	return b
+}
+
+// block is a header block of an RFC 7541 appendix C example, with the
+// fields it holds and the size of the dynamic table afterwards.
+type block struct {
+	hex    string
+	fields []HeaderField
+	size   int
+}
+
+var requestFields = [][]HeaderField{
+	{
+		{Name: ":method", Value: "GET"},
+		{Name: ":scheme", Value: "http"},
+		{Name: ":path", Value: "/"},
+		{Name: ":authority", Value: "www.example.com"},
+	},
+	{
+		{Name: ":method", Value: "GET"},
+		{Name: ":scheme", Value: "http"},
+		{Name: ":path", Value: "/"},
+		{Name: ":authority", Value: "www.example.com"},
+		{Name: "cache-control", Value: "no-cache"},
+	},
+	{
+		{Name: ":method", Value: "GET"},
+		{Name: ":scheme", Value: "https"},
+		{Name: ":path", Value: "/index.html"},
+		{Name: ":authority", Value: "www.example.com"},
+		{Name: "custom-key", Value: "custom-value"},
+	},
+}
+
+var responseFields = [][]HeaderField{
+	{
+		{Name: ":status", Value: "302"},
+		{Name: "cache-control", Value: "private"},
+		{Name: "date", Value: "Mon, 21 Oct 2013 20:13:21 GMT"},
+		{Name: "location", Value: "https://www.example.com"},
+	},
+	{
+		{Name: ":status", Value: "307"},
+		{Name: "cache-control", Value: "private"},
+		{Name: "date", Value: "Mon, 21 Oct 2013 20:13:21 GMT"},
+		{Name: "location", Value: "https://www.example.com"},
+	},
+	{
+		{Name: ":status", Value: "200"},
+		{Name: "cache-control", Value: "private"},
+		{Name: "date", Value: "Mon, 21 Oct 2013 20:13:22 GMT"},
+		{Name: "location", Value: "https://www.example.com"},
+		{Name: "content-encoding", Value: "gzip"},
+		{Name: "set-cookie", Value: "foo=ASDJKHQKBZXOQWEOPIUAXQWEOIU; max-age=3600; version=1"},
+	},
+}
+
+var examples = []struct {
+	name      string
+	tableSize int
+	huffman   bool
+	blocks    []block
+}{
+	{"C.3 requests without Huffman coding", DefaultTableSize, false, []block{
+		{"8286 8441 0f77 7777 2e65 7861 6d70 6c65 2e63 6f6d", requestFields[0], 57},
+		{"8286 84be 5808 6e6f 2d63 6163 6865", requestFields[1], 110},
+		{"8287 85bf 400a 6375 7374 6f6d 2d6b 6579 0c63 7573 746f 6d2d 7661 6c75 65", requestFields[2], 164},
+	}},
+	{"C.4 requests with Huffman coding", DefaultTableSize, true, []block{
+		{"8286 8441 8cf1 e3c2 e5f2 3a6b a0ab 90f4 ff", requestFields[0], 57},
+		{"8286 84be 5886 a8eb 1064 9cbf", requestFields[1], 110},
+		{"8287 85bf 4088 25a8 49e9 5ba9 7d7f 8925 a849 e95b b8e8 b4bf", requestFields[2], 164},
+	}},
+	{"C.5 responses without Huffman coding", 256, false, []block{
+		{`4803 3330 3258 0770 7269 7661 7465 611d
+		  4d6f 6e2c 2032 3120 4f63 7420 3230 3133
+		  2032 303a 3133 3a32 3120 474d 546e 1768
+		  7474 7073 3a2f 2f77 7777 2e65 7861 6d70
+		  6c65 2e63 6f6d`, responseFields[0], 222},
+		{"4803 3330 37c1 c0bf", responseFields[1], 222},
+		{`88c1 611d 4d6f 6e2c 2032 3120 4f63 7420
+		  3230 3133 2032 303a 3133 3a32 3220 474d
+		  54c0 5a04 677a 6970 7738 666f 6f3d 4153
+		  444a 4b48 514b 425a 584f 5157 454f 5049
+		  5541 5851 5745 4f49 553b 206d 6178 2d61
+		  6765 3d33 3630 303b 2076 6572 7369 6f6e
+		  3d31`, responseFields[2], 215},
+	}},
+	{"C.6 responses with Huffman coding", 256, true, []block{
+		{`4882 6402 5885 aec3 771a 4b61 96d0 7abe
+		  9410 54d4 44a8 2005 9504 0b81 66e0 82a6
+		  2d1b ff6e 919d 29ad 1718 63c7 8f0b 97c8
+		  e9ae 82ae 43d3`, responseFields[0], 222},
+		{"4883 640e ffc1 c0bf", responseFields[1], 222},
+		{`88c1 6196 d07a be94 1054 d444 a820 0595
+		  040b 8166 e084 a62d 1bff c05a 839b d9ab
+		  77ad 94e7 821d d7f2 e6c7 b335 dfdf cd5b
+		  3960 d5af 2708 7f36 72c1 ab27 0fb5 291f
+		  9587 3160 65c0 03ed 4ee5 b106 3d50 07`, responseFields[2], 215},
+	}},
+}
+
+func TestExamples(t *testing.T) {
+	for _, ex := range examples {
+		d := NewDecoder(ex.tableSize)
+		e := NewEncoder(ex.tableSize)
+		e.DisableHuffman = !ex.huffman
+		for i, b := range ex.blocks {
+			// Test: the decoder reproduces the fields and the table
+			fields, err := d.Decode(unhex(t, b.hex))
+			require.NoError(t, err, "%s block %d", ex.name, i+1)
+			assert.Equal(t, b.fields, fields, "%s block %d", ex.name, i+1)
+			assert.Equal(t, b.size, d.table.size, "%s block %d", ex.name, i+1)
+
+			// Test: the encoder produces the same block
+			assert.Equal(t, unhex(t, b.hex), e.Encode(b.fields), "%s block %d", ex.name, i+1)
+			assert.Equal(t, b.size, e.table.size, "%s block %d", ex.name, i+1)
+		}
+	}
+}
+
+func TestInt(t *testing.T) {
+	// Test: integer representation examples (RFC 7541 C.1)
+	assert.Equal(t, []byte{0x0a}, appendInt(nil, 0, 5, 10))
+	assert.Equal(t, []byte{0x1f, 0x9a, 0x0a}, appendInt(nil, 0, 5, 1337))
+	assert.Equal(t, []byte{0x2a}, appendInt(nil, 0, 8, 42))
+	i, rest, err := readInt([]byte{0x1f, 0x9a, 0x0a, 0xff}, 5)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1337), i)
+	assert.Equal(t, []byte{0xff}, rest)
+}
+
+func TestDecoder(t *testing.T) {
+	// Test: header field representation examples (RFC 7541 C.2)
+	d := NewDecoder(DefaultTableSize)
+	fields, err := d.Decode(unhex(t, "400a 6375 7374 6f6d 2d6b 6579 0d63 7573 746f 6d2d 6865 6164 6572"))
+	require.NoError(t, err)
+	assert.Equal(t, []HeaderField{{Name: "custom-key", Value: "custom-header"}}, fields)
+	assert.Equal(t, 55, d.table.size)
+
+	d = NewDecoder(DefaultTableSize)
+	fields, err = d.Decode(unhex(t, "040c 2f73 616d 706c 652f 7061 7468"))
+	require.NoError(t, err)
+	assert.Equal(t, []HeaderField{{Name: ":path", Value: "/sample/path"}}, fields)
+	assert.Equal(t, 0, d.table.size)
+
+	fields, err = d.Decode(unhex(t, "1008 7061 7373 776f 7264 0673 6563 7265 74"))
+	require.NoError(t, err)
+	assert.Equal(t, []HeaderField{{Name: "password", Value: "secret", Sensitive: true}}, fields)
+	assert.Equal(t, 0, d.table.size)
+
+	fields, err = d.Decode(unhex(t, "82"))
+	require.NoError(t, err)
+	assert.Equal(t, []HeaderField{{Name: ":method", Value: "GET"}}, fields)
+
+	// Test: size updates evict entries
+	d = NewDecoder(DefaultTableSize)
+	_, err = d.Decode(unhex(t, "400a 6375 7374 6f6d 2d6b 6579 0d63 7573 746f 6d2d 6865 6164 6572"))
+	require.NoError(t, err)
+	_, err = d.Decode(unhex(t, "20 3f45 82"))
+	require.NoError(t, err)
+	assert.Equal(t, 0, d.table.size)
+	assert.Equal(t, 100, d.table.maxSize)
+
+	// Test: invalid input
+	_, err = NewDecoder(DefaultTableSize).Decode([]byte{0x80})
+	assert.ErrorIs(t, err, ErrInvalidIndex)
+	_, err = NewDecoder(DefaultTableSize).Decode([]byte{0xbe})
+	assert.ErrorIs(t, err, ErrInvalidIndex)
+	_, err = NewDecoder(DefaultTableSize).Decode(unhex(t, "4088 25a8"))
+	assert.ErrorIs(t, err, ErrTruncated)
+	_, err = NewDecoder(DefaultTableSize).Decode(unhex(t, "3fe2 1f"))
+	assert.ErrorIs(t, err, ErrTableSizeExceeded)
+	_, err = NewDecoder(DefaultTableSize).Decode(unhex(t, "82 20"))
+	assert.ErrorIs(t, err, ErrLateSizeUpdate)
+	_, err = NewDecoder(DefaultTableSize).Decode(unhex(t, "ff ff ff ff ff ff 01"))
+	assert.ErrorIs(t, err, ErrIntegerOverflow)
+	d = NewDecoder(DefaultTableSize)
+	d.MaxStringLength = 4
+	_, err = d.Decode(unhex(t, "400a 6375 7374 6f6d 2d6b 6579 0d63 7573 746f 6d2d 6865 6164 6572"))
+	assert.ErrorIs(t, err, ErrStringTooLong)
+}
+
+func TestHuffman(t *testing.T) {
+	// Test: encoding and decoding round trip
+	for _, s := range []string{"", "www.example.com", "no-cache", "\x00\xff binary \x7f", strings.Repeat("z", 100)} {
+		p := AppendHuffman(nil, s)
+		assert.Len(t, p, HuffmanEncodedLen(s))
+		decoded, err := HuffmanDecode(p)
+		require.NoError(t, err)
+		assert.Equal(t, s, decoded)
+	}
+	assert.Equal(t, unhex(t, "f1e3 c2e5 f23a 6ba0 ab90 f4ff"), AppendHuffman(nil, "www.example.com"))
+
+	// Test: padding longer than 7 bits
+	_, err := HuffmanDecode(unhex(t, "f1e3 c2e5 f23a 6ba0 ab90 f4ff ff"))
+	assert.ErrorIs(t, err, ErrInvalidHuffman)
+
+	// Test: padding that is not all ones
+	_, err = HuffmanDecode([]byte{0x00})
+	assert.ErrorIs(t, err, ErrInvalidHuffman)
+
+	// Test: end-of-string symbol
+	_, err = HuffmanDecode(unhex(t, "ffff fffc"))
+	assert.ErrorIs(t, err, ErrInvalidHuffman)
+}
+
+func TestEncoder(t *testing.T) {
+	// Test: sensitive fields are never indexed
+	e := NewEncoder(DefaultTableSize)
+	e.DisableHuffman = true
+	block := e.Encode([]HeaderField{
+		{Name: "authorization", Value: "Bearer token"},
+		{Name: "cookie", Value: "id=1"},
+		{Name: "password", Value: "secret", Sensitive: true},
+	})
+	assert.Equal(t, unhex(t, "1f08 0c42 6561 7265 7220 746f 6b65 6e"+"1f11 0469 643d 31"+
+		"1008 7061 7373 776f 7264 0673 6563 7265 74"), block)
+	assert.Equal(t, 0, e.table.size)
+	fields, err := NewDecoder(DefaultTableSize).Decode(block)
+	require.NoError(t, err)
+	for _, f := range fields {
+		assert.True(t, f.Sensitive, f.Name)
+	}
+
+	// Test: table size changes are signalled in the next block, the smallest first
+	e = NewEncoder(DefaultTableSize)
+	e.Encode([]HeaderField{{Name: "custom-key", Value: "custom-value"}})
+	e.SetMaxTableSize(0)
+	e.SetMaxTableSize(100)
+	assert.Equal(t, unhex(t, "20 3f45 82"), e.Encode([]HeaderField{{Name: ":method", Value: "GET"}}))
+	assert.Equal(t, unhex(t, "82"), e.Encode([]HeaderField{{Name: ":method", Value: "GET"}}))
+
+	// Test: fields larger than the table are not indexed
+	e.DisableHuffman = true
+	block = e.Encode([]HeaderField{{Name: "x-large", Value: strings.Repeat("a", 100)}})
+	assert.Equal(t, byte(0x00), block[0])
+	assert.Equal(t, 0, e.table.size)
+}
+
+func TestHeaders(t *testing.T) {
+	h := headers.Headers{"content-type": "text/plain", ":status": "200", "cookie": "session=0123456789abcdefghij"}
+
+	// Test: pseudo-header fields are sorted first
+	assert.Equal(t, []HeaderField{
+		{Name: ":status", Value: "200"},
+		{Name: "content-type", Value: "text/plain"},
+		{Name: "cookie", Value: "session=0123456789abcdefghij"},
+	}, Fields(h))
+
+	// Test: headers round trip through a header block
+	e := NewEncoder(DefaultTableSize)
+	d := NewDecoder(DefaultTableSize)
+	for range 2 {
+		decoded, err := d.DecodeHeaders(e.EncodeHeaders(h))
+		require.NoError(t, err)
+		assert.Equal(t, h, decoded)
+	}
+
+	// Test: repeated fields are combined
+	decoded, err := d.DecodeHeaders(e.Encode([]HeaderField{
+		{Name: "cookie", Value: "a=1"},
+		{Name: "cookie", Value: "b=2"},
+		{Name: "accept", Value: "text/html"},
+		{Name: "accept", Value: "text/plain"},
+	}))
+	require.NoError(t, err)
+	assert.Equal(t, headers.Headers{"cookie": "a=1; b=2", "accept": "text/html, text/plain"}, decoded)
+}
@@ -0,0 +1,91 @@
+package hpack
+
+import "sync"
+
+// huffmanNode is a node of the decoding tree, leaves have no children.
+type huffmanNode struct {
+	children [2]*huffmanNode
+	sym      byte
+}
+
+var (
+	huffmanRoot     *huffmanNode
+	huffmanRootOnce sync.Once
+)
+
+func buildHuffmanTree() {
+	huffmanRoot = &huffmanNode{}
+	for sym, code := range huffmanCodes {
+		node := huffmanRoot
+		for i := int(huffmanCodeLens[sym]) - 1; i >= 0; i-- {
+			bit := (code >> uint(i)) & 1
+			if node.children[bit] == nil {
+				node.children[bit] = &huffmanNode{}
+			}
+			node = node.children[bit]
+		}
+		node.sym = byte(sym)
+	}
+}
+
+// HuffmanDecode decodes a Huffman coded string. The padding at the end must
+// be shorter than a byte and consist of the most significant bits of the
+// end-of-string code, which itself must not appear.
+func HuffmanDecode(p []byte) (string, error) {
+	huffmanRootOnce.Do(buildHuffmanTree)
+
+	out := make([]byte, 0, len(p)*8/5)
+	node := huffmanRoot
+	// depth and ones track the bits since the last symbol, to validate the
+	// padding.
+	depth, ones := 0, true
+	for _, b := range p {
+		for i := 7; i >= 0; i-- {
+			bit := (b >> uint(i)) & 1
+			node = node.children[bit]
+			if node == nil {
+				return "", ErrInvalidHuffman
+			}
+			depth++
+			ones = ones && bit == 1
+			if node.children[0] == nil && node.children[1] == nil {
+				out = append(out, node.sym)
+				node = huffmanRoot
+				depth, ones = 0, true
+			}
+		}
+	}
+	if depth > 7 || !ones {
+		return "", ErrInvalidHuffman
+	}
+	return string(out), nil
+}
+
+// HuffmanEncodedLen returns the number of bytes s takes when Huffman coded.
+func HuffmanEncodedLen(s string) int {
+	bits := 0
+	for i := 0; i < len(s); i++ {
+		bits += int(huffmanCodeLens[s[i]])
+	}
+	return (bits + 7) / 8
+}
+
+// AppendHuffman appends the Huffman coding of s to dst, padded with the
+// most significant bits of the end-of-string code.
+func AppendHuffman(dst []byte, s string) []byte {
+	var acc uint64
+	n := uint(0)
+	for i := 0; i < len(s); i++ {
+		acc = acc<<huffmanCodeLens[s[i]] | uint64(huffmanCodes[s[i]])
+		n += uint(huffmanCodeLens[s[i]])
+		for n >= 8 {
+			n -= 8
+			dst = append(dst, byte(acc>>n))
+		}
+	}
+	if n > 0 {
+		acc = acc<<(8-n) | 0xff>>n
+		dst = append(dst, byte(acc))
+	}
+	return dst
+}
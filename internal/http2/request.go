@@ -0,0 +1,150 @@
+package http2
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/ramonvermeulen/httpfromtcp/internal/headers"
+	"github.com/ramonvermeulen/httpfromtcp/internal/hpack"
+	"github.com/ramonvermeulen/httpfromtcp/internal/request"
+)
+
+// HTTPVersion is the version in the request line of HTTP/2 requests.
+const HTTPVersion = "2.0"
+
+var ErrInvalidUpgrade = errors.New("http2: invalid h2c upgrade request")
+
+// newRequest builds the request of a stream from its header fields.
+func newRequest(fields []hpack.HeaderField) (*request.Request, error) {
+	req := &request.Request{
+		RequestLine: request.RequestLine{HTTPVersion: HTTPVersion},
+		Headers:     headers.NewHeaders(),
+	}
+	pseudo := map[string]string{}
+	var cookies []string
+	for i, f := range fields {
+		if name, ok := strings.CutPrefix(f.Name, ":"); ok {
+			if i > 0 && !strings.HasPrefix(fields[i-1].Name, ":") {
+				return nil, fmt.Errorf("pseudo-header %s after regular fields", f.Name)
+			}
+			if !slices.Contains([]string{"method", "scheme", "path", "authority"}, name) {
+				return nil, fmt.Errorf("unknown pseudo-header %s", f.Name)
+			}
+			if _, ok := pseudo[name]; ok {
+				return nil, fmt.Errorf("duplicate pseudo-header %s", f.Name)
+			}
+			pseudo[name] = f.Value
+			continue
+		}
+		if err := checkField(f); err != nil {
+			return nil, err
+		}
+		if f.Name == "cookie" {
+			cookies = append(cookies, f.Value)
+			continue
+		}
+		req.Headers.Set(f.Name, f.Value)
+	}
+	if pseudo["method"] == "" || pseudo["scheme"] == "" || pseudo["path"] == "" {
+		return nil, errors.New("missing :method, :scheme or :path")
+	}
+	req.RequestLine.Method = pseudo["method"]
+	req.RequestLine.RequestTarget = pseudo["path"]
+	if len(cookies) > 0 {
+		req.Headers.Replace("cookie", strings.Join(cookies, "; "))
+	}
+	if authority := pseudo["authority"]; authority != "" {
+		req.Headers.Replace("host", authority)
+	}
+	return req, nil
+}
+
+// addTrailers sets the trailers of a request, apart from its headers.
+func addTrailers(req *request.Request, fields []hpack.HeaderField) error {
+	req.Trailers = headers.NewHeaders()
+	for _, f := range fields {
+		if strings.HasPrefix(f.Name, ":") {
+			return fmt.Errorf("pseudo-header %s in trailers", f.Name)
+		}
+		if err := checkField(f); err != nil {
+			return err
+		}
+		req.Trailers.Set(f.Name, f.Value)
+	}
+	return nil
+}
+
+func checkField(f hpack.HeaderField) error {
+	if !headers.IsToken(f.Name) || f.Name != strings.ToLower(f.Name) {
+		return fmt.Errorf("invalid field name %q", f.Name)
+	}
+	if slices.Contains(headers.ConnectionHeaders, f.Name) {
+		return fmt.Errorf("connection-specific field %s", f.Name)
+	}
+	if f.Name == "te" && f.Value != "trailers" {
+		return errors.New("te other than trailers")
+	}
+	return nil
+}
+
+// checkContentLength compares a declared content-length with the length of
+// the body that was received.
+func checkContentLength(req *request.Request, n int) error {
+	v, ok := req.Headers.Get("content-length")
+	if !ok {
+		return nil
+	}
+	if length, err := strconv.Atoi(v); err != nil || length != n {
+		return fmt.Errorf("content-length %s does not match body of %d bytes", v, n)
+	}
+	return nil
+}
+
+// IsUpgrade reports whether req is an HTTP/1.1 request that asks to switch
+// to HTTP/2 with "Upgrade: h2c" and valid HTTP2-Settings.
+func IsUpgrade(req *request.Request) bool {
+	if req.RequestLine.HTTPVersion != "1.1" {
+		return false
+	}
+	upgrade, _ := req.Headers.Get("upgrade")
+	connection, _ := req.Headers.Get("connection")
+	if !headers.HasToken(upgrade, "h2c") || !headers.HasToken(connection, "upgrade") || !headers.HasToken(connection, "http2-settings") {
+		return false
+	}
+	_, err := upgradeSettings(req)
+	return err == nil
+}
+
+func upgradeSettings(req *request.Request) ([]Setting, error) {
+	v, ok := req.Headers.Get("http2-settings")
+	if !ok {
+		return nil, ErrInvalidUpgrade
+	}
+	p, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(v, "="))
+	if err != nil {
+		return nil, ErrInvalidUpgrade
+	}
+	settings, err := ParseSettings(p)
+	if err != nil {
+		return nil, ErrInvalidUpgrade
+	}
+	return settings, nil
+}
+
+// upgradedRequest turns the HTTP/1.1 request of an upgrade into the request
+// of stream 1.
+func upgradedRequest(req *request.Request) *request.Request {
+	up := *req
+	up.RequestLine.HTTPVersion = HTTPVersion
+	up.Headers = headers.NewHeaders()
+	for name, value := range req.Headers {
+		if !slices.Contains(headers.ConnectionHeaders, name) && name != "http2-settings" {
+			up.Headers[name] = value
+		}
+	}
+	return &up
+}
@@ -0,0 +1,745 @@
+package http2
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ramonvermeulen/httpfromtcp/internal/hpack"
+	"github.com/ramonvermeulen/httpfromtcp/internal/request"
+	"github.com/ramonvermeulen/httpfromtcp/internal/response"
+)
+
+// DefaultMaxConcurrentStreams is how many streams a client may have open at
+// once when the server does not set a limit.
+const DefaultMaxConcurrentStreams = 250
+
+// DefaultMaxBodySize limits the request body of a stream when the server
+// does not set a limit.
+const DefaultMaxBodySize = 10 << 20
+
+// maxHeaderBlockSize limits a header block spread over HEADERS and
+// CONTINUATION frames.
+const maxHeaderBlockSize = 1 << 20
+
+// Server serves HTTP/2 connections, every stream is handled on its own
+// goroutine so one connection multiplexes any number of requests.
+type Server struct {
+	// Handler serves the request of a stream. The response is written to
+	// the stream, typically with response.NewStreamWriter, and has to be
+	// complete when Handler returns, otherwise the stream is reset.
+	Handler func(st *Stream, req *request.Request)
+	// MaxConcurrentStreams limits the open streams of a connection, zero
+	// means DefaultMaxConcurrentStreams. Further streams are refused.
+	MaxConcurrentStreams uint32
+	// MaxBodySize limits the request body buffered for a stream, zero means
+	// DefaultMaxBodySize and a negative value means no limit. A larger body
+	// is answered with 413 and the stream is reset.
+	MaxBodySize int
+	// IdleTimeout closes a connection without open streams after this long,
+	// zero means never.
+	IdleTimeout time.Duration
+	// ConnState, if set, is called when a stream opens or closes, idle
+	// reports whether no streams are left. Returning false stops the
+	// connection gracefully: it sends GOAWAY, refuses new streams and is
+	// closed once the open ones are done.
+	ConnState func(idle bool) bool
+	// Shutdown, if set, stops the connection gracefully once it is closed,
+	// like ConnState returning false. A connection without open streams is
+	// closed right after its GOAWAY.
+	Shutdown <-chan struct{}
+}
+
+// ServeConn serves HTTP/2 with prior knowledge on conn, br reads from conn
+// and the client preface is next. It returns when the connection is closed.
+// Stream contexts are derived from ctx.
+func (s *Server) ServeConn(ctx context.Context, conn net.Conn, br *bufio.Reader) error {
+	return s.newConn(ctx, conn, br).serve(nil)
+}
+
+// ServeUpgrade switches conn to HTTP/2 after req, an HTTP/1.1 request
+// accepted by IsUpgrade, and serves it. req is answered on stream 1.
+func (s *Server) ServeUpgrade(ctx context.Context, conn net.Conn, br *bufio.Reader, req *request.Request) error {
+	settings, err := upgradeSettings(req)
+	if err != nil {
+		return err
+	}
+	sc := s.newConn(ctx, conn, br)
+	if err := sc.applySettings(settings); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(sc.bw, "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: h2c\r\n\r\n"); err != nil {
+		return err
+	}
+	return sc.serve(upgradedRequest(req))
+}
+
+type serverConn struct {
+	srv        *Server
+	conn       net.Conn
+	br         *bufio.Reader
+	ctx        context.Context
+	cancel     context.CancelFunc
+	maxStreams uint32
+	maxBody    int
+	handlers   sync.WaitGroup
+
+	// Only used by the read loop. recvWindow is how much DATA the client may
+	// still send on the connection.
+	dec        *hpack.Decoder
+	pending    *headerBlock
+	recvWindow int64
+
+	// wmu serializes frames and the state of the encoder.
+	wmu sync.Mutex
+	bw  *bufio.Writer
+	enc *hpack.Encoder
+
+	mu                sync.Mutex
+	cond              *sync.Cond
+	streams           map[uint32]*Stream
+	sendWindow        int64
+	initialWindowSize int64
+	peerMaxFrameSize  uint32
+	// lastStreamID is the highest stream the client opened, it is only
+	// changed by the read loop.
+	lastStreamID uint32
+	// draining is set once either side sent GOAWAY, no new streams are
+	// accepted.
+	draining bool
+	closed   bool
+}
+
+// headerBlock collects a header block until its END_HEADERS flag.
+type headerBlock struct {
+	streamID  uint32
+	endStream bool
+	block     []byte
+}
+
+func (s *Server) newConn(ctx context.Context, conn net.Conn, br *bufio.Reader) *serverConn {
+	ctx, cancel := context.WithCancel(ctx)
+	sc := &serverConn{
+		srv:               s,
+		conn:              conn,
+		br:                br,
+		ctx:               ctx,
+		cancel:            cancel,
+		maxStreams:        s.MaxConcurrentStreams,
+		maxBody:           s.MaxBodySize,
+		dec:               hpack.NewDecoder(hpack.DefaultTableSize),
+		recvWindow:        DefaultInitialWindowSize,
+		bw:                bufio.NewWriterSize(conn, 2*DefaultMaxFrameSize),
+		enc:               hpack.NewEncoder(hpack.DefaultTableSize),
+		streams:           make(map[uint32]*Stream),
+		sendWindow:        DefaultInitialWindowSize,
+		initialWindowSize: DefaultInitialWindowSize,
+		peerMaxFrameSize:  DefaultMaxFrameSize,
+	}
+	if sc.maxStreams == 0 {
+		sc.maxStreams = DefaultMaxConcurrentStreams
+	}
+	if sc.maxBody == 0 {
+		sc.maxBody = DefaultMaxBodySize
+	}
+	sc.cond = sync.NewCond(&sc.mu)
+	return sc
+}
+
+// serve runs the read loop of the connection, upgrade is the request of an
+// upgraded HTTP/1.1 connection.
+func (sc *serverConn) serve(upgrade *request.Request) error {
+	defer sc.close()
+
+	settings := appendSettings(nil, Setting{SettingMaxConcurrentStreams, sc.maxStreams})
+	if err := sc.writeFrame(FrameSettings, 0, 0, settings); err != nil {
+		return err
+	}
+	if err := sc.readPreface(); err != nil {
+		return sc.fail(err)
+	}
+	if upgrade != nil {
+		sc.lastStreamID = 1
+		st, err := sc.openStream(1, upgrade)
+		if err != nil {
+			return sc.fail(err)
+		}
+		// The request was read completely over HTTP/1.1.
+		sc.mu.Lock()
+		st.state = stateHalfClosedRemote
+		sc.mu.Unlock()
+		sc.dispatch(st)
+	} else {
+		// The connection is idle until the client opens a stream.
+		sc.notifyState(true)
+		if sc.drained() {
+			return nil
+		}
+	}
+	if sc.srv.Shutdown != nil {
+		go sc.watchShutdown()
+	}
+
+	for {
+		sc.setReadDeadline()
+		f, err := ReadFrame(sc.br, DefaultMaxFrameSize)
+		switch {
+		case errors.Is(err, ErrFrameTooLarge):
+			err = ConnectionError{ErrCodeFrameSize, "frame exceeds SETTINGS_MAX_FRAME_SIZE"}
+		case errors.Is(err, os.ErrDeadlineExceeded) && sc.idle():
+			sc.goAway(ErrCodeNo)
+			return nil
+		case err == nil:
+			err = sc.processFrame(f)
+		}
+		var streamErr StreamError
+		if errors.As(err, &streamErr) {
+			sc.resetStream(streamErr)
+			continue
+		}
+		if err != nil {
+			return sc.fail(err)
+		}
+		if sc.drained() {
+			return nil
+		}
+	}
+}
+
+// readPreface reads the client preface and the SETTINGS frame that has to
+// follow it.
+func (sc *serverConn) readPreface() error {
+	preface := make([]byte, len(ClientPreface))
+	if _, err := io.ReadFull(sc.br, preface); err != nil {
+		return err
+	}
+	if string(preface) != ClientPreface {
+		return ConnectionError{ErrCodeProtocol, "invalid client preface"}
+	}
+	f, err := ReadFrame(sc.br, DefaultMaxFrameSize)
+	if err != nil {
+		return err
+	}
+	if f.Type != FrameSettings || f.Flags.Has(FlagAck) {
+		return ConnectionError{ErrCodeProtocol, "client preface is not followed by SETTINGS"}
+	}
+	return sc.processSettings(f)
+}
+
+// fail ends the connection after err, with a GOAWAY frame for protocol
+// errors.
+func (sc *serverConn) fail(err error) error {
+	var connErr ConnectionError
+	if errors.As(err, &connErr) {
+		sc.goAway(connErr.Code)
+		return err
+	}
+	if err == io.EOF || errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+	return err
+}
+
+// close cancels the remaining streams and waits for their handlers.
+func (sc *serverConn) close() {
+	sc.mu.Lock()
+	sc.closed = true
+	sc.cond.Broadcast()
+	sc.mu.Unlock()
+	sc.cancel()
+	sc.conn.Close()
+	sc.handlers.Wait()
+}
+
+func (sc *serverConn) processFrame(f Frame) error {
+	if sc.pending != nil && (f.Type != FrameContinuation || f.StreamID != sc.pending.streamID) {
+		return ConnectionError{ErrCodeProtocol, "header block interrupted by " + f.Type.String()}
+	}
+	switch f.Type {
+	case FrameData:
+		return sc.processData(f)
+	case FrameHeaders:
+		return sc.processHeaders(f)
+	case FrameContinuation:
+		return sc.processContinuation(f)
+	case FramePriority:
+		if f.StreamID == 0 {
+			return ConnectionError{ErrCodeProtocol, "PRIORITY on stream 0"}
+		}
+		if f.Length != 5 {
+			return StreamError{f.StreamID, ErrCodeFrameSize, "PRIORITY payload is not 5 bytes"}
+		}
+		return nil
+	case FrameRSTStream:
+		return sc.processRSTStream(f)
+	case FrameSettings:
+		return sc.processSettings(f)
+	case FramePushPromise:
+		return ConnectionError{ErrCodeProtocol, "PUSH_PROMISE from client"}
+	case FramePing:
+		return sc.processPing(f)
+	case FrameGoAway:
+		return sc.processGoAway(f)
+	case FrameWindowUpdate:
+		return sc.processWindowUpdate(f)
+	default:
+		// Unknown frame types are ignored.
+		return nil
+	}
+}
+
+func (sc *serverConn) processHeaders(f Frame) error {
+	if f.StreamID == 0 || f.StreamID%2 == 0 {
+		return ConnectionError{ErrCodeProtocol, "HEADERS on an invalid stream"}
+	}
+	p, err := unpad(f)
+	if err != nil {
+		return err
+	}
+	if f.Flags.Has(FlagPriority) {
+		if len(p) < 5 {
+			return ConnectionError{ErrCodeFrameSize, "HEADERS priority is truncated"}
+		}
+		p = p[5:]
+	}
+	sc.pending = &headerBlock{
+		streamID:  f.StreamID,
+		endStream: f.Flags.Has(FlagEndStream),
+		block:     append([]byte(nil), p...),
+	}
+	if f.Flags.Has(FlagEndHeaders) {
+		return sc.endHeaders()
+	}
+	return nil
+}
+
+func (sc *serverConn) processContinuation(f Frame) error {
+	if sc.pending == nil {
+		return ConnectionError{ErrCodeProtocol, "CONTINUATION without HEADERS"}
+	}
+	sc.pending.block = append(sc.pending.block, f.Payload...)
+	if len(sc.pending.block) > maxHeaderBlockSize {
+		return ConnectionError{ErrCodeEnhanceYourCalm, "header block too large"}
+	}
+	if f.Flags.Has(FlagEndHeaders) {
+		return sc.endHeaders()
+	}
+	return nil
+}
+
+// endHeaders decodes a complete header block, it opens a stream or holds the
+// trailers of an open one.
+func (sc *serverConn) endHeaders() error {
+	hb := sc.pending
+	sc.pending = nil
+	fields, err := sc.dec.Decode(hb.block)
+	if err != nil {
+		return ConnectionError{ErrCodeCompression, err.Error()}
+	}
+
+	if st := sc.stream(hb.streamID); st != nil {
+		if !st.open() {
+			return StreamError{st.id, ErrCodeStreamClosed, "HEADERS on a half-closed stream"}
+		}
+		if !hb.endStream {
+			return StreamError{st.id, ErrCodeProtocol, "trailers without END_STREAM"}
+		}
+		if err := addTrailers(st.req, fields); err != nil {
+			return StreamError{st.id, ErrCodeProtocol, err.Error()}
+		}
+		return sc.endRequest(st)
+	}
+
+	if hb.streamID <= sc.lastStreamID {
+		return ConnectionError{ErrCodeStreamClosed, "HEADERS on a closed stream"}
+	}
+	sc.mu.Lock()
+	sc.lastStreamID = hb.streamID
+	sc.mu.Unlock()
+	req, err := newRequest(fields)
+	if err != nil {
+		return StreamError{hb.streamID, ErrCodeProtocol, err.Error()}
+	}
+	st, err := sc.openStream(hb.streamID, req)
+	if err != nil || st == nil {
+		return err
+	}
+	if hb.endStream {
+		return sc.endRequest(st)
+	}
+	return nil
+}
+
+func (sc *serverConn) processData(f Frame) error {
+	if f.StreamID == 0 {
+		return ConnectionError{ErrCodeProtocol, "DATA on stream 0"}
+	}
+	if int64(f.Length) > sc.recvWindow {
+		return ConnectionError{ErrCodeFlowControl, "DATA exceeds the connection window"}
+	}
+	sc.recvWindow -= int64(f.Length)
+	p, err := unpad(f)
+	if err != nil {
+		return err
+	}
+	// The connection window is given back right away, whatever happens to
+	// the stream, request bodies are buffered until they are complete and
+	// limited to maxBody.
+	if f.Length > 0 {
+		if err := sc.writeWindowUpdate(0, f.Length); err != nil {
+			return err
+		}
+		sc.recvWindow += int64(f.Length)
+	}
+	st := sc.stream(f.StreamID)
+	if st == nil || !st.open() {
+		if f.StreamID > sc.lastStreamID {
+			return ConnectionError{ErrCodeProtocol, "DATA on an idle stream"}
+		}
+		return StreamError{f.StreamID, ErrCodeStreamClosed, "DATA on a closed stream"}
+	}
+	if int64(f.Length) > st.recvWindow {
+		return StreamError{st.id, ErrCodeFlowControl, "DATA exceeds the stream window"}
+	}
+	st.recvWindow -= int64(f.Length)
+	if sc.maxBody > 0 && st.body.Len()+len(p) > sc.maxBody {
+		return sc.refuseBody(st)
+	}
+	st.body.Write(p)
+	if f.Flags.Has(FlagEndStream) {
+		return sc.endRequest(st)
+	}
+	if f.Length > 0 {
+		if err := sc.writeWindowUpdate(f.StreamID, f.Length); err != nil {
+			return err
+		}
+		st.recvWindow += int64(f.Length)
+	}
+	return nil
+}
+
+// refuseBody answers a request whose body is over the limit with 413 and
+// resets its stream, so the client stops sending the body.
+func (sc *serverConn) refuseBody(st *Stream) error {
+	st.cancel()
+	status := strconv.Itoa(int(response.StatusContentTooLarge))
+	if err := st.writeHeaderBlock([]hpack.HeaderField{{Name: ":status", Value: status}}, true); err != nil {
+		return err
+	}
+	return StreamError{st.id, ErrCodeNo, "request body too large"}
+}
+
+func (sc *serverConn) processRSTStream(f Frame) error {
+	if f.StreamID == 0 {
+		return ConnectionError{ErrCodeProtocol, "RST_STREAM on stream 0"}
+	}
+	if f.Length != 4 {
+		return ConnectionError{ErrCodeFrameSize, "RST_STREAM payload is not 4 bytes"}
+	}
+	st := sc.stream(f.StreamID)
+	if st == nil {
+		if f.StreamID > sc.lastStreamID {
+			return ConnectionError{ErrCodeProtocol, "RST_STREAM on an idle stream"}
+		}
+		return nil
+	}
+	sc.closeStream(st)
+	st.cancel()
+	return nil
+}
+
+func (sc *serverConn) processSettings(f Frame) error {
+	if f.StreamID != 0 {
+		return ConnectionError{ErrCodeProtocol, "SETTINGS on a stream"}
+	}
+	if f.Flags.Has(FlagAck) {
+		if f.Length != 0 {
+			return ConnectionError{ErrCodeFrameSize, "SETTINGS acknowledgement with payload"}
+		}
+		return nil
+	}
+	settings, err := ParseSettings(f.Payload)
+	if err != nil {
+		return err
+	}
+	if err := sc.applySettings(settings); err != nil {
+		return err
+	}
+	return sc.writeFrame(FrameSettings, FlagAck, 0, nil)
+}
+
+// applySettings applies the settings of the client.
+func (sc *serverConn) applySettings(settings []Setting) error {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	defer sc.cond.Broadcast()
+	for _, s := range settings {
+		switch s.ID {
+		case SettingHeaderTableSize:
+			sc.wmu.Lock()
+			sc.enc.SetMaxTableSize(int(min(s.Value, hpack.DefaultTableSize)))
+			sc.wmu.Unlock()
+		case SettingEnablePush:
+			if s.Value > 1 {
+				return ConnectionError{ErrCodeProtocol, "invalid SETTINGS_ENABLE_PUSH"}
+			}
+		case SettingInitialWindowSize:
+			if s.Value > maxWindowSize {
+				return ConnectionError{ErrCodeFlowControl, "SETTINGS_INITIAL_WINDOW_SIZE too large"}
+			}
+			delta := int64(s.Value) - sc.initialWindowSize
+			for _, st := range sc.streams {
+				st.sendWindow += delta
+				if st.sendWindow > maxWindowSize {
+					return ConnectionError{ErrCodeFlowControl, "stream window overflow"}
+				}
+			}
+			sc.initialWindowSize = int64(s.Value)
+		case SettingMaxFrameSize:
+			if s.Value < DefaultMaxFrameSize || s.Value > maxFrameSizeLimit {
+				return ConnectionError{ErrCodeProtocol, "invalid SETTINGS_MAX_FRAME_SIZE"}
+			}
+			sc.peerMaxFrameSize = s.Value
+		}
+	}
+	return nil
+}
+
+func (sc *serverConn) processPing(f Frame) error {
+	if f.StreamID != 0 {
+		return ConnectionError{ErrCodeProtocol, "PING on a stream"}
+	}
+	if f.Length != 8 {
+		return ConnectionError{ErrCodeFrameSize, "PING payload is not 8 bytes"}
+	}
+	if f.Flags.Has(FlagAck) {
+		return nil
+	}
+	return sc.writeFrame(FramePing, FlagAck, 0, f.Payload)
+}
+
+func (sc *serverConn) processGoAway(f Frame) error {
+	if f.StreamID != 0 {
+		return ConnectionError{ErrCodeProtocol, "GOAWAY on a stream"}
+	}
+	if f.Length < 8 {
+		return ConnectionError{ErrCodeFrameSize, "GOAWAY payload is truncated"}
+	}
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.draining = true
+	return nil
+}
+
+func (sc *serverConn) processWindowUpdate(f Frame) error {
+	if f.Length != 4 {
+		return ConnectionError{ErrCodeFrameSize, "WINDOW_UPDATE payload is not 4 bytes"}
+	}
+	inc := int64(binary.BigEndian.Uint32(f.Payload) & (1<<31 - 1))
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	defer sc.cond.Broadcast()
+	if f.StreamID == 0 {
+		if inc == 0 {
+			return ConnectionError{ErrCodeProtocol, "WINDOW_UPDATE with zero increment"}
+		}
+		sc.sendWindow += inc
+		if sc.sendWindow > maxWindowSize {
+			return ConnectionError{ErrCodeFlowControl, "connection window overflow"}
+		}
+		return nil
+	}
+	st := sc.streams[f.StreamID]
+	if st == nil {
+		if f.StreamID > sc.lastStreamID {
+			return ConnectionError{ErrCodeProtocol, "WINDOW_UPDATE on an idle stream"}
+		}
+		return nil
+	}
+	if inc == 0 {
+		return StreamError{f.StreamID, ErrCodeProtocol, "WINDOW_UPDATE with zero increment"}
+	}
+	st.sendWindow += inc
+	if st.sendWindow > maxWindowSize {
+		return StreamError{f.StreamID, ErrCodeFlowControl, "stream window overflow"}
+	}
+	return nil
+}
+
+// openStream adds a stream for req. It returns nil when the stream is
+// ignored because the connection is going away, and a stream error when
+// too many streams are open.
+func (sc *serverConn) openStream(id uint32, req *request.Request) (*Stream, error) {
+	sc.mu.Lock()
+	if sc.draining {
+		sc.mu.Unlock()
+		return nil, nil
+	}
+	if uint32(len(sc.streams)) >= sc.maxStreams {
+		sc.mu.Unlock()
+		return nil, StreamError{id, ErrCodeRefusedStream, "too many concurrent streams"}
+	}
+	ctx, cancel := context.WithCancel(sc.ctx)
+	st := &Stream{
+		id:         id,
+		sc:         sc,
+		req:        req.WithContext(ctx),
+		cancel:     cancel,
+		recvWindow: DefaultInitialWindowSize,
+		sendWindow: sc.initialWindowSize,
+	}
+	sc.streams[id] = st
+	sc.mu.Unlock()
+
+	sc.notifyState(false)
+	return st, nil
+}
+
+// endRequest completes the request of st once the client ended the stream
+// and starts its handler.
+func (sc *serverConn) endRequest(st *Stream) error {
+	sc.mu.Lock()
+	st.state = stateHalfClosedRemote
+	sc.mu.Unlock()
+	if err := checkContentLength(st.req, st.body.Len()); err != nil {
+		return StreamError{st.id, ErrCodeProtocol, err.Error()}
+	}
+	st.req.Body = st.body.String()
+	sc.dispatch(st)
+	return nil
+}
+
+func (sc *serverConn) dispatch(st *Stream) {
+	sc.handlers.Add(1)
+	go func() {
+		defer sc.handlers.Done()
+		defer st.cancel()
+		sc.srv.Handler(st, st.req)
+		st.Reset(ErrCodeInternal)
+	}()
+}
+
+func (sc *serverConn) stream(id uint32) *Stream {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.streams[id]
+}
+
+// resetStream ends the stream of err with RST_STREAM.
+func (sc *serverConn) resetStream(err StreamError) {
+	if st := sc.stream(err.StreamID); st != nil {
+		sc.closeStream(st)
+		st.cancel()
+	}
+	sc.writeFrame(FrameRSTStream, 0, err.StreamID, binary.BigEndian.AppendUint32(nil, uint32(err.Code)))
+}
+
+// closeStream removes a stream that ended, on a draining connection the
+// last one closes the connection.
+func (sc *serverConn) closeStream(st *Stream) {
+	sc.mu.Lock()
+	if st.state == stateClosed {
+		sc.mu.Unlock()
+		return
+	}
+	st.state = stateClosed
+	delete(sc.streams, st.id)
+	idle := len(sc.streams) == 0
+	sc.cond.Broadcast()
+	sc.mu.Unlock()
+
+	sc.notifyState(idle)
+	if idle {
+		sc.setReadDeadline()
+		if sc.drained() {
+			sc.flush()
+			sc.conn.Close()
+		}
+	}
+}
+
+// notifyState reports a stream opening or closing to Server.ConnState and
+// starts going away when it asks to.
+func (sc *serverConn) notifyState(idle bool) {
+	if sc.srv.ConnState != nil && !sc.srv.ConnState(idle) {
+		sc.goAway(ErrCodeNo)
+	}
+}
+
+// watchShutdown goes away once the server shuts down, a connection without
+// open streams is closed, which ends the read loop.
+func (sc *serverConn) watchShutdown() {
+	select {
+	case <-sc.srv.Shutdown:
+	case <-sc.ctx.Done():
+		return
+	}
+	sc.goAway(ErrCodeNo)
+	if sc.drained() {
+		sc.conn.Close()
+	}
+}
+
+// goAway sends GOAWAY with the last stream that was processed, no new
+// streams are accepted after it.
+func (sc *serverConn) goAway(code ErrCode) {
+	sc.mu.Lock()
+	sc.draining = true
+	p := binary.BigEndian.AppendUint32(nil, sc.lastStreamID)
+	sc.mu.Unlock()
+	p = binary.BigEndian.AppendUint32(p, uint32(code))
+	sc.writeFrame(FrameGoAway, 0, 0, p)
+}
+
+// drained reports whether the connection is going away and has no open
+// streams left.
+func (sc *serverConn) drained() bool {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.draining && len(sc.streams) == 0
+}
+
+func (sc *serverConn) idle() bool {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return len(sc.streams) == 0
+}
+
+// setReadDeadline applies the idle timeout while no stream is open.
+func (sc *serverConn) setReadDeadline() {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if len(sc.streams) == 0 && sc.srv.IdleTimeout > 0 {
+		sc.conn.SetReadDeadline(time.Now().Add(sc.srv.IdleTimeout))
+	} else {
+		sc.conn.SetReadDeadline(time.Time{})
+	}
+}
+
+// writeFrame writes a single frame and flushes it.
+func (sc *serverConn) writeFrame(typ FrameType, flags Flags, streamID uint32, payload []byte) error {
+	sc.wmu.Lock()
+	defer sc.wmu.Unlock()
+	if err := WriteFrame(sc.bw, typ, flags, streamID, payload); err != nil {
+		return err
+	}
+	return sc.bw.Flush()
+}
+
+func (sc *serverConn) writeWindowUpdate(streamID, inc uint32) error {
+	return sc.writeFrame(FrameWindowUpdate, 0, streamID, binary.BigEndian.AppendUint32(nil, inc))
+}
+
+func (sc *serverConn) flush() error {
+	sc.wmu.Lock()
+	defer sc.wmu.Unlock()
+	return sc.bw.Flush()
+}
@@ -0,0 +1,340 @@
+package http2
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ramonvermeulen/httpfromtcp/internal/hpack"
+	"github.com/ramonvermeulen/httpfromtcp/internal/request"
+	"github.com/ramonvermeulen/httpfromtcp/internal/response"
+)
+
+func TestReadWriteFrame(t *testing.T) {
+	// Test: a frame survives a round trip
+	var buf bytes.Buffer
+	require.NoError(t, WriteFrame(&buf, FrameHeaders, FlagEndStream|FlagEndHeaders, 3, []byte("block")))
+	assert.Equal(t, []byte{0, 0, 5, 1, 5, 0, 0, 0, 3}, buf.Bytes()[:9])
+	f, err := ReadFrame(&buf, DefaultMaxFrameSize)
+	require.NoError(t, err)
+	assert.Equal(t, FrameHeader{Length: 5, Type: FrameHeaders, Flags: FlagEndStream | FlagEndHeaders, StreamID: 3}, f.FrameHeader)
+	assert.Equal(t, "block", string(f.Payload))
+
+	// Test: frames above the maximum size are rejected
+	require.NoError(t, WriteFrame(&buf, FrameData, 0, 1, make([]byte, 20)))
+	_, err = ReadFrame(&buf, 10)
+	assert.ErrorIs(t, err, ErrFrameTooLarge)
+
+	// Test: settings are parsed in pairs of six bytes
+	settings, err := ParseSettings(appendSettings(nil, Setting{SettingInitialWindowSize, 10}, Setting{SettingEnablePush, 0}))
+	require.NoError(t, err)
+	assert.Equal(t, []Setting{{SettingInitialWindowSize, 10}, {SettingEnablePush, 0}}, settings)
+	_, err = ParseSettings([]byte{0, 1, 0})
+	assert.Equal(t, ConnectionError{ErrCodeFrameSize, "SETTINGS payload is not a multiple of 6"}, err)
+}
+
+// testConn is the client side of a connection served by a Server.
+type testConn struct {
+	t      *testing.T
+	conn   net.Conn
+	frames chan Frame
+	enc    *hpack.Encoder
+	dec    *hpack.Decoder
+}
+
+func startConn(t *testing.T, srv *Server, settings ...Setting) *testConn {
+	client, server := net.Pipe()
+	go srv.ServeConn(context.Background(), server, bufio.NewReader(server))
+	tc := &testConn{
+		t:      t,
+		conn:   client,
+		frames: make(chan Frame, 100),
+		enc:    hpack.NewEncoder(hpack.DefaultTableSize),
+		dec:    hpack.NewDecoder(hpack.DefaultTableSize),
+	}
+	t.Cleanup(func() { client.Close() })
+	go func() {
+		defer close(tc.frames)
+		for {
+			f, err := ReadFrame(client, maxFrameSizeLimit)
+			if err != nil {
+				return
+			}
+			tc.frames <- f
+		}
+	}()
+
+	client.Write([]byte(ClientPreface))
+	tc.write(FrameSettings, 0, 0, appendSettings(nil, settings...))
+	assert.Equal(t, FrameSettings, tc.read().Type)
+	ack := tc.read()
+	assert.Equal(t, FrameSettings, ack.Type)
+	assert.True(t, ack.Flags.Has(FlagAck))
+	return tc
+}
+
+func (tc *testConn) write(typ FrameType, flags Flags, streamID uint32, payload []byte) {
+	require.NoError(tc.t, WriteFrame(tc.conn, typ, flags, streamID, payload))
+}
+
+// request opens a stream with a GET request for path.
+func (tc *testConn) request(streamID uint32, path string) {
+	block := tc.enc.Encode([]hpack.HeaderField{
+		{Name: ":method", Value: "GET"},
+		{Name: ":scheme", Value: "http"},
+		{Name: ":path", Value: path},
+		{Name: ":authority", Value: "localhost"},
+	})
+	tc.write(FrameHeaders, FlagEndHeaders|FlagEndStream, streamID, block)
+}
+
+// read returns the next frame, skipping WINDOW_UPDATE frames.
+func (tc *testConn) read() Frame {
+	tc.t.Helper()
+	for {
+		select {
+		case f, ok := <-tc.frames:
+			require.True(tc.t, ok, "connection closed")
+			if f.Type != FrameWindowUpdate {
+				return f
+			}
+		case <-time.After(2 * time.Second):
+			tc.t.Fatal("timeout waiting for a frame")
+		}
+	}
+}
+
+func (tc *testConn) readHeaders() map[string]string {
+	tc.t.Helper()
+	f := tc.read()
+	require.Equal(tc.t, FrameHeaders, f.Type)
+	fields, err := tc.dec.Decode(f.Payload)
+	require.NoError(tc.t, err)
+	h := map[string]string{}
+	for _, field := range fields {
+		h[field.Name] = field.Value
+	}
+	return h
+}
+
+func reply(body string) func(st *Stream, req *request.Request) {
+	return func(st *Stream, req *request.Request) {
+		w := response.NewStreamWriter(st)
+		w.SetRequest(req)
+		w.Header().Set("Content-Length", "11")
+		w.Write([]byte(body))
+		w.Finish()
+	}
+}
+
+func TestServer_Frames(t *testing.T) {
+	tc := startConn(t, &Server{Handler: reply("hello world")})
+
+	// Test: PING is acknowledged with the same payload
+	tc.write(FramePing, 0, 0, []byte("12345678"))
+	f := tc.read()
+	assert.Equal(t, FramePing, f.Type)
+	assert.True(t, f.Flags.Has(FlagAck))
+	assert.Equal(t, "12345678", string(f.Payload))
+
+	// Test: a request is answered with HEADERS and DATA on its stream
+	tc.request(1, "/")
+	h := tc.readHeaders()
+	assert.Equal(t, "200", h[":status"])
+	assert.Equal(t, "11", h["content-length"])
+	f = tc.read()
+	assert.Equal(t, FrameData, f.Type)
+	assert.Equal(t, "hello world", string(f.Payload))
+	if !f.Flags.Has(FlagEndStream) {
+		f = tc.read()
+		assert.True(t, f.Flags.Has(FlagEndStream))
+	}
+
+	// Test: DATA on stream 0 is a connection error
+	tc.write(FrameData, 0, 0, []byte("x"))
+	f = tc.read()
+	assert.Equal(t, FrameGoAway, f.Type)
+	assert.Equal(t, uint32(1), binary.BigEndian.Uint32(f.Payload))
+	assert.Equal(t, ErrCodeProtocol, ErrCode(binary.BigEndian.Uint32(f.Payload[4:])))
+}
+
+func TestServer_FlowControl(t *testing.T) {
+	tc := startConn(t, &Server{Handler: reply("hello world")}, Setting{SettingInitialWindowSize, 4})
+
+	// Test: only the window of the stream is sent
+	tc.request(1, "/")
+	tc.readHeaders()
+	f := tc.read()
+	assert.Equal(t, FrameData, f.Type)
+	assert.Equal(t, "hell", string(f.Payload))
+	select {
+	case f := <-tc.frames:
+		t.Fatalf("unexpected %s frame", f.Type)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Test: WINDOW_UPDATE releases the rest
+	tc.write(FrameWindowUpdate, 0, 1, binary.BigEndian.AppendUint32(nil, 100))
+	var body []byte
+	for {
+		f = tc.read()
+		body = append(body, f.Payload...)
+		if f.Flags.Has(FlagEndStream) {
+			break
+		}
+	}
+	assert.Equal(t, "o world", string(body))
+}
+
+func TestServer_ReceiveWindow(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go io.Copy(io.Discard, client)
+	sc := (&Server{}).newConn(context.Background(), server, bufio.NewReader(server))
+	data := func(streamID uint32, n int) Frame {
+		return Frame{FrameHeader{Length: uint32(n), Type: FrameData, StreamID: streamID}, make([]byte, n)}
+	}
+
+	// Test: DATA within the windows is buffered and the windows are given back
+	sc.lastStreamID = 1
+	st, err := sc.openStream(1, &request.Request{})
+	require.NoError(t, err)
+	require.NoError(t, sc.processData(data(1, 100)))
+	assert.Equal(t, 100, st.body.Len())
+	assert.Equal(t, int64(DefaultInitialWindowSize), sc.recvWindow)
+	assert.Equal(t, int64(DefaultInitialWindowSize), st.recvWindow)
+
+	// Test: DATA beyond the window of the stream resets the stream
+	st.recvWindow = 4
+	assert.Equal(t, StreamError{1, ErrCodeFlowControl, "DATA exceeds the stream window"}, sc.processData(data(1, 5)))
+
+	// Test: DATA beyond the window of the connection is a connection error
+	sc.recvWindow = 4
+	assert.Equal(t, ConnectionError{ErrCodeFlowControl, "DATA exceeds the connection window"}, sc.processData(data(1, 5)))
+}
+
+func TestServer_MaxBodySize(t *testing.T) {
+	tc := startConn(t, &Server{MaxBodySize: 10, Handler: reply("hello world")})
+	post := func(streamID uint32) {
+		tc.write(FrameHeaders, FlagEndHeaders, streamID, tc.enc.Encode([]hpack.HeaderField{
+			{Name: ":method", Value: "POST"},
+			{Name: ":scheme", Value: "http"},
+			{Name: ":path", Value: "/"},
+			{Name: ":authority", Value: "localhost"},
+		}))
+	}
+
+	// Test: a body larger than MaxBodySize is answered with 413 and the
+	// stream is reset
+	post(1)
+	tc.write(FrameData, 0, 1, []byte("123456"))
+	tc.write(FrameData, FlagEndStream, 1, []byte("789012"))
+	assert.Equal(t, "413", tc.readHeaders()[":status"])
+	f := tc.read()
+	assert.Equal(t, FrameRSTStream, f.Type)
+	assert.Equal(t, uint32(1), f.StreamID)
+	assert.Equal(t, ErrCodeNo, ErrCode(binary.BigEndian.Uint32(f.Payload)))
+
+	// Test: a body within MaxBodySize is served
+	post(3)
+	tc.write(FrameData, FlagEndStream, 3, []byte("1234567890"))
+	assert.Equal(t, "200", tc.readHeaders()[":status"])
+}
+
+func TestServer_Streams(t *testing.T) {
+	cancelled := make(chan struct{})
+	tc := startConn(t, &Server{
+		MaxConcurrentStreams: 1,
+		Handler: func(st *Stream, req *request.Request) {
+			<-req.Context().Done()
+			close(cancelled)
+		},
+	})
+
+	// Test: streams above MAX_CONCURRENT_STREAMS are refused
+	tc.request(1, "/")
+	tc.request(3, "/")
+	f := tc.read()
+	assert.Equal(t, FrameRSTStream, f.Type)
+	assert.Equal(t, uint32(3), f.StreamID)
+	assert.Equal(t, ErrCodeRefusedStream, ErrCode(binary.BigEndian.Uint32(f.Payload)))
+
+	// Test: RST_STREAM cancels the context of the request
+	tc.write(FrameRSTStream, 0, 1, binary.BigEndian.AppendUint32(nil, uint32(ErrCodeCancel)))
+	select {
+	case <-cancelled:
+	case <-time.After(2 * time.Second):
+		t.Fatal("handler was not cancelled")
+	}
+
+	// Test: a reused stream identifier is a connection error
+	tc.request(1, "/")
+	f = tc.read()
+	assert.Equal(t, FrameGoAway, f.Type)
+	assert.Equal(t, ErrCodeStreamClosed, ErrCode(binary.BigEndian.Uint32(f.Payload[4:])))
+}
+
+func TestServer_InvalidPreface(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go (&Server{Handler: reply("")}).ServeConn(context.Background(), server, bufio.NewReader(server))
+
+	// Test: a client that does not send the preface gets GOAWAY
+	go client.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	br := bufio.NewReader(client)
+	f, err := ReadFrame(br, DefaultMaxFrameSize)
+	require.NoError(t, err)
+	assert.Equal(t, FrameSettings, f.Type)
+	f, err = ReadFrame(br, DefaultMaxFrameSize)
+	require.NoError(t, err)
+	assert.Equal(t, FrameGoAway, f.Type)
+	assert.Equal(t, ErrCodeProtocol, ErrCode(binary.BigEndian.Uint32(f.Payload[4:])))
+}
+
+func TestNewRequest(t *testing.T) {
+	fields := []hpack.HeaderField{
+		{Name: ":method", Value: "POST"},
+		{Name: ":scheme", Value: "http"},
+		{Name: ":path", Value: "/submit?x=1"},
+		{Name: ":authority", Value: "example.com"},
+		{Name: "cookie", Value: "a=1"},
+		{Name: "cookie", Value: "b=2"},
+	}
+
+	// Test: pseudo-headers become the request line and the host
+	req, err := newRequest(fields)
+	require.NoError(t, err)
+	assert.Equal(t, request.RequestLine{HTTPVersion: "2.0", RequestTarget: "/submit?x=1", Method: "POST"}, req.RequestLine)
+	host, _ := req.Headers.Get("host")
+	assert.Equal(t, "example.com", host)
+	cookie, _ := req.Headers.Get("cookie")
+	assert.Equal(t, "a=1; b=2", cookie)
+
+	// Test: trailers are kept apart from the headers
+	require.NoError(t, addTrailers(req, []hpack.HeaderField{{Name: "host", Value: "evil"}}))
+	host, _ = req.Headers.Get("host")
+	assert.Equal(t, "example.com", host)
+	host, _ = req.Trailers.Get("host")
+	assert.Equal(t, "evil", host)
+
+	// Test: malformed requests are rejected
+	for _, bad := range [][]hpack.HeaderField{
+		fields[1:],
+		append(fields[4:5:5], fields[:4]...),
+		append(fields[:4:4], hpack.HeaderField{Name: "connection", Value: "close"}),
+		append(fields[:4:4], hpack.HeaderField{Name: "X-Upper", Value: "1"}),
+		append(fields[:4:4], hpack.HeaderField{Name: "te", Value: "gzip"}),
+		append(fields[:4:4], hpack.HeaderField{Name: ":status", Value: "200"}),
+	} {
+		_, err := newRequest(bad)
+		assert.Error(t, err)
+	}
+}
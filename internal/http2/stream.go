@@ -0,0 +1,186 @@
+package http2
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"strconv"
+
+	"github.com/ramonvermeulen/httpfromtcp/internal/headers"
+	"github.com/ramonvermeulen/httpfromtcp/internal/hpack"
+	"github.com/ramonvermeulen/httpfromtcp/internal/request"
+	"github.com/ramonvermeulen/httpfromtcp/internal/response"
+)
+
+var ErrStreamClosed = errors.New("http2: stream closed")
+
+type streamState int
+
+const (
+	stateOpen streamState = iota
+	// stateHalfClosedRemote is a stream whose request is complete and whose
+	// response is still being sent.
+	stateHalfClosedRemote
+	stateClosed
+)
+
+// Stream is a single request and response on a connection. It implements
+// response.Stream, writes block while the flow control window of the client
+// is exhausted.
+type Stream struct {
+	id     uint32
+	sc     *serverConn
+	req    *request.Request
+	cancel context.CancelFunc
+	// body and recvWindow are only used by the read loop, until the request
+	// is complete.
+	body       bytes.Buffer
+	recvWindow int64
+
+	// Guarded by sc.mu.
+	state      streamState
+	sendWindow int64
+}
+
+var _ response.Stream = (*Stream)(nil)
+
+func (st *Stream) ID() uint32 {
+	return st.id
+}
+
+func (st *Stream) WriteHeaders(status response.StatusCode, h headers.Headers) error {
+	fields := []hpack.HeaderField{{Name: ":status", Value: strconv.Itoa(int(status))}}
+	return st.writeHeaderBlock(append(fields, hpack.Fields(h)...), false)
+}
+
+func (st *Stream) WriteData(p []byte, endStream bool) error {
+	if len(p) == 0 && !endStream {
+		return nil
+	}
+	for {
+		n, err := st.awaitWindow(len(p))
+		if err != nil {
+			return err
+		}
+		chunk := p[:n]
+		p = p[n:]
+		var flags Flags
+		if endStream && len(p) == 0 {
+			flags = FlagEndStream
+		}
+		st.sc.wmu.Lock()
+		err = WriteFrame(st.sc.bw, FrameData, flags, st.id, chunk)
+		st.sc.wmu.Unlock()
+		if err != nil {
+			return err
+		}
+		if len(p) == 0 {
+			if endStream {
+				st.sc.closeStream(st)
+			}
+			return nil
+		}
+	}
+}
+
+func (st *Stream) WriteTrailers(h headers.Headers) error {
+	return st.writeHeaderBlock(hpack.Fields(h), true)
+}
+
+func (st *Stream) Flush() error {
+	return st.sc.flush()
+}
+
+// open reports whether the client may still send on the stream.
+func (st *Stream) open() bool {
+	st.sc.mu.Lock()
+	defer st.sc.mu.Unlock()
+	return st.state == stateOpen
+}
+
+// Reset ends the stream with RST_STREAM unless it already ended.
+func (st *Stream) Reset(code ErrCode) error {
+	st.sc.mu.Lock()
+	closed := st.state == stateClosed
+	st.sc.mu.Unlock()
+	if closed {
+		return nil
+	}
+	st.sc.closeStream(st)
+	st.cancel()
+	return st.sc.writeFrame(FrameRSTStream, 0, st.id, binary.BigEndian.AppendUint32(nil, uint32(code)))
+}
+
+// writeHeaderBlock sends fields in a HEADERS frame, followed by CONTINUATION
+// frames when they don't fit.
+func (st *Stream) writeHeaderBlock(fields []hpack.HeaderField, endStream bool) error {
+	sc := st.sc
+	sc.mu.Lock()
+	closed := st.state == stateClosed || sc.closed
+	maxSize := int(sc.peerMaxFrameSize)
+	sc.mu.Unlock()
+	if closed {
+		return ErrStreamClosed
+	}
+
+	sc.wmu.Lock()
+	block := sc.enc.Encode(fields)
+	typ, flags := FrameHeaders, Flags(0)
+	if endStream {
+		flags = FlagEndStream
+	}
+	var err error
+	for first := true; err == nil && (first || len(block) > 0); first = false {
+		n := min(len(block), maxSize)
+		frag := block[:n]
+		block = block[n:]
+		f := flags
+		if len(block) == 0 {
+			f |= FlagEndHeaders
+		}
+		err = WriteFrame(sc.bw, typ, f, st.id, frag)
+		typ, flags = FrameContinuation, 0
+	}
+	sc.wmu.Unlock()
+	if err == nil && endStream {
+		sc.closeStream(st)
+	}
+	return err
+}
+
+// awaitWindow reserves up to n bytes of the send windows of the stream and
+// the connection, waiting until there is room.
+func (st *Stream) awaitWindow(n int) (int, error) {
+	sc := st.sc
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	flushed := false
+	for {
+		if st.state == stateClosed || sc.closed {
+			return 0, ErrStreamClosed
+		}
+		if n == 0 {
+			return 0, nil
+		}
+		avail := min(int64(n), st.sendWindow, sc.sendWindow, int64(sc.peerMaxFrameSize))
+		if avail > 0 {
+			st.sendWindow -= avail
+			sc.sendWindow -= avail
+			return int(avail), nil
+		}
+		// The client only grants more after it read what is buffered.
+		if !flushed {
+			sc.mu.Unlock()
+			err := sc.flush()
+			sc.mu.Lock()
+			if err != nil {
+				return 0, err
+			}
+			flushed = true
+			continue
+		}
+		sc.cond.Wait()
+		flushed = false
+	}
+}
@@ -0,0 +1,49 @@
+package request
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"net/url"
+)
+
+// Identity is a client authenticated with a verified TLS certificate.
+type Identity struct {
+	Subject        pkix.Name
+	DNSNames       []string
+	EmailAddresses []string
+	IPAddresses    []net.IP
+	URIs           []*url.URL
+	Certificate    *x509.Certificate
+}
+
+// NewIdentity returns the identity described by the leaf certificate of a
+// verified chain.
+func NewIdentity(cert *x509.Certificate) *Identity {
+	return &Identity{
+		Subject:        cert.Subject,
+		DNSNames:       cert.DNSNames,
+		EmailAddresses: cert.EmailAddresses,
+		IPAddresses:    cert.IPAddresses,
+		URIs:           cert.URIs,
+		Certificate:    cert,
+	}
+}
+
+// Names returns the common name of the subject followed by all subject
+// alternative names, IP addresses and URIs in their string form.
+func (i *Identity) Names() []string {
+	var names []string
+	if i.Subject.CommonName != "" {
+		names = append(names, i.Subject.CommonName)
+	}
+	names = append(names, i.DNSNames...)
+	names = append(names, i.EmailAddresses...)
+	for _, ip := range i.IPAddresses {
+		names = append(names, ip.String())
+	}
+	for _, uri := range i.URIs {
+		names = append(names, uri.String())
+	}
+	return names
+}
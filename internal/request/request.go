@@ -1,11 +1,16 @@
 package request
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"slices"
 	"strconv"
+	"strings"
 
 	"github.com/ramonvermeulen/httpfromtcp/internal/headers"
 )
@@ -21,6 +26,11 @@ var HTTPMethods = [][]byte{
 	[]byte("TRACE"),
 }
 
+var HTTPVersions = [][]byte{
+	[]byte("1.0"),
+	[]byte("1.1"),
+}
+
 var (
 	ErrMalformedRequestLine    = fmt.Errorf("malformed request line")
 	ErrUnsupportedHTTPVersion  = fmt.Errorf("unsupported HTTP version")
@@ -28,7 +38,18 @@ var (
 	ErrParsingInDoneState      = fmt.Errorf("attempted to parse request in done state")
 	ErrBodyExceedContentLength = fmt.Errorf("body exceeds content-length")
 	ErrBodyWithinContentLength = fmt.Errorf("body exceeds content-length")
-	LineSeparator              = []byte("\r\n")
+	ErrLineTooLong             = fmt.Errorf("line exceeds read buffer")
+	ErrMalformedContentLength  = fmt.Errorf("malformed content-length")
+	ErrMalformedChunk          = fmt.Errorf("malformed chunk")
+	ErrAmbiguousFraming        = fmt.Errorf("both transfer-encoding and content-length")
+	ErrChunkedNotLast          = fmt.Errorf("transfer-encoding does not end with chunked")
+	// ErrBodyTooLarge is returned by ReadBody for a body over its limit, the
+	// server answers it with 413.
+	ErrBodyTooLarge = fmt.Errorf("body exceeds the size limit")
+	// ErrUnsupportedTransferEncoding is returned for transfer codings other
+	// than chunked, the server answers it with 501.
+	ErrUnsupportedTransferEncoding = fmt.Errorf("unsupported transfer-encoding")
+	LineSeparator                  = []byte("\r\n")
 )
 
 type requestState int
@@ -37,6 +58,10 @@ const (
 	StateInitialized requestState = iota
 	StateHeaders     requestState = iota
 	StateBody        requestState = iota
+	StateChunkSize   requestState = iota
+	StateChunkData   requestState = iota
+	StateChunkEnd    requestState = iota
+	StateTrailers    requestState = iota
 	StateDone                     = iota
 )
 
@@ -50,8 +75,60 @@ type Request struct {
 	RequestLine RequestLine
 	Headers     headers.Headers
 	Body        string
+	// Trailers holds the trailer section of a chunked body. It is kept apart
+	// from Headers, which a trailer must not override, and nil when the body
+	// is not chunked.
+	Trailers headers.Headers
+	// Params holds the path parameters of the route that matched the
+	// request, it is nil when the request was not routed.
+	Params map[string]string
+	// RemoteAddr and LocalAddr are the addresses of the client and the
+	// server on the connection the request was read from, they are set by
+	// the server.
+	RemoteAddr string
+	LocalAddr  string
+	// TLS is the state of the TLS connection the request was read from, it
+	// is nil for plaintext connections.
+	TLS *tls.ConnectionState
+	// Client is the identity from the verified client certificate of a
+	// mutual TLS connection, it is nil when the client sent none.
+	Client *Identity
+
+	state     requestState
+	remaining int
+	chunked   bool
+	// body collects the body until the request is complete, maxBody limits
+	// it when positive.
+	body    []byte
+	maxBody int
+	ctx     context.Context
+}
+
+// Context returns the context of the request. For requests served by the
+// server it is cancelled when the client goes away, the server is closed or
+// the write timeout expires. It is never nil.
+func (r *Request) Context() context.Context {
+	if r.ctx != nil {
+		return r.ctx
+	}
+	return context.Background()
+}
 
-	state requestState
+// WithContext returns a shallow copy of r with its context changed to ctx,
+// middleware uses it to attach request-scoped values.
+func (r *Request) WithContext(ctx context.Context) *Request {
+	if ctx == nil {
+		panic("request: nil context")
+	}
+	r2 := *r
+	r2.ctx = ctx
+	return &r2
+}
+
+// Param returns the path parameter name, or an empty string if the route
+// has no such parameter.
+func (r *Request) Param(name string) string {
+	return r.Params[name]
 }
 
 func NewRequest() *Request {
@@ -61,23 +138,76 @@ func NewRequest() *Request {
 	}
 }
 
-func getIntFromHeader(h headers.Headers, key string, defaultValue int) int {
-	valueStr, exists := h.Get(key)
-	if !exists {
-		return defaultValue
+// bodyFraming decides how the body is delimited once the headers are known,
+// following RFC 9112 section 6.3. Requests whose framing a proxy could read
+// differently are rejected.
+func (r *Request) bodyFraming() (requestState, error) {
+	te, hasTE := r.Headers.Get("transfer-encoding")
+	cl, hasCL := r.Headers.Get("content-length")
+	switch {
+	case hasTE && hasCL:
+		return 0, ErrAmbiguousFraming
+	case hasTE:
+		codings := headers.ListValues(te)
+		if len(codings) == 0 || codings[len(codings)-1] != "chunked" {
+			return 0, fmt.Errorf("%w: %s", ErrChunkedNotLast, te)
+		}
+		if len(codings) > 1 {
+			return 0, fmt.Errorf("%w: %s", ErrUnsupportedTransferEncoding, te)
+		}
+		r.chunked = true
+		return StateChunkSize, nil
+	case hasCL:
+		n, err := parseContentLength(cl)
+		if err != nil {
+			return 0, err
+		}
+		if n == 0 {
+			return StateDone, nil
+		}
+		r.remaining = n
+		return StateBody, nil
+	default:
+		return StateDone, nil
 	}
+}
 
-	value, err := strconv.Atoi(valueStr)
-	if err != nil {
-		return defaultValue
+// parseContentLength accepts only digits. Repeated headers, which were joined
+// into a list, must all carry the same value.
+func parseContentLength(value string) (int, error) {
+	n := -1
+	for _, v := range strings.Split(value, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" || strings.Trim(v, "0123456789") != "" {
+			return 0, fmt.Errorf("%w: %q", ErrMalformedContentLength, value)
+		}
+		m, err := strconv.Atoi(v)
+		if err != nil || n >= 0 && m != n {
+			return 0, fmt.Errorf("%w: %q", ErrMalformedContentLength, value)
+		}
+		n = m
 	}
-
-	return value
+	return n, nil
 }
 
-func (r *Request) hasBody() bool {
-	cl := getIntFromHeader(r.Headers, "content-length", 0)
-	return cl != 0
+// parseChunkSize parses the line in front of every chunk, chunk extensions
+// are ignored.
+func parseChunkSize(data []byte) (int, int, error) {
+	lsIdx := bytes.Index(data, LineSeparator)
+	if lsIdx == -1 {
+		return 0, 0, nil
+	}
+
+	size, _, _ := bytes.Cut(data[:lsIdx], []byte(";"))
+	size = bytes.TrimRight(size, " \t")
+	if len(size) == 0 || len(bytes.Trim(size, "0123456789abcdefABCDEF")) > 0 {
+		return 0, 0, ErrMalformedChunk
+	}
+	n, err := strconv.ParseInt(string(size), 16, 32)
+	if err != nil {
+		return 0, 0, ErrMalformedChunk
+	}
+	return lsIdx + len(LineSeparator), int(n), nil
 }
 
 func (r *Request) parse(data []byte) (int, error) {
@@ -112,27 +242,69 @@ outer:
 				break outer
 			}
 
+			read += bp
 			if done {
-				if r.hasBody() {
-					r.state = StateBody
+				r.state, err = r.bodyFraming()
+				if err != nil {
+					return 0, err
+				}
+			}
+
+		case StateBody, StateChunkData:
+			n := min(r.remaining, len(currentData))
+			r.body = append(r.body, currentData[:n]...)
+			r.remaining -= n
+			read += n
+
+			if r.remaining == 0 {
+				if r.state == StateChunkData {
+					r.state = StateChunkEnd
 				} else {
 					r.state = StateDone
+					break outer
 				}
 			}
-			read += bp
 
-		case StateBody:
-			cl := getIntFromHeader(r.Headers, "content-length", 0)
+		case StateChunkSize:
+			bp, size, err := parseChunkSize(currentData)
+			if err != nil {
+				return 0, err
+			}
+			if bp == 0 {
+				break outer
+			}
+			read += bp
 
-			remaining := min(cl-len(r.Body), len(currentData))
-			r.Body += string(currentData[:remaining])
-			read += remaining
+			if size == 0 {
+				r.Trailers = headers.NewHeaders()
+				r.state = StateTrailers
+			} else if r.maxBody > 0 && len(r.body)+size > r.maxBody {
+				return 0, ErrBodyTooLarge
+			} else {
+				r.remaining = size
+				r.state = StateChunkData
+			}
 
-			if len(r.Body) > cl {
-				return 0, ErrBodyExceedContentLength
+		case StateChunkEnd:
+			if len(currentData) < len(LineSeparator) {
+				break outer
+			}
+			if !bytes.HasPrefix(currentData, LineSeparator) {
+				return 0, ErrMalformedChunk
 			}
+			read += len(LineSeparator)
+			r.state = StateChunkSize
 
-			if len(r.Body) == cl {
+		case StateTrailers:
+			bp, done, err := r.Trailers.Parse(currentData)
+			if err != nil {
+				return 0, err
+			}
+			if bp == 0 {
+				break outer
+			}
+			read += bp
+			if done {
 				r.state = StateDone
 				break outer
 			}
@@ -163,7 +335,9 @@ func parseRequestLine(line []byte) (int, *RequestLine, error) {
 	requestTarget := parts[1]
 	method := parts[0]
 
-	if len(versionParts) != 2 || !bytes.Equal(versionParts[0], []byte("HTTP")) || !bytes.Equal(versionParts[1], []byte("1.1")) {
+	if len(versionParts) != 2 || !bytes.Equal(versionParts[0], []byte("HTTP")) || !slices.ContainsFunc(HTTPVersions, func(v []byte) bool {
+		return bytes.Equal(v, versionParts[1])
+	}) {
 		return 0, nil, ErrUnsupportedHTTPVersion
 	}
 
@@ -180,30 +354,122 @@ func parseRequestLine(line []byte) (int, *RequestLine, error) {
 	}, nil
 }
 
+// RequestFromReader reads a single request from reader. If reader is a
+// *bufio.Reader only the bytes of this request are consumed, so it can be
+// used to read the next request on a persistent connection. io.EOF is
+// returned when reader has no more data before the request starts.
 func RequestFromReader(reader io.Reader) (*Request, error) {
+	br, ok := reader.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(reader)
+	}
+	request, err := RequestHeadFromReader(br)
+	if err != nil {
+		return nil, err
+	}
+	if err := request.ReadBody(br, 0); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+// RequestHeadFromReader reads the request line and headers of a request, the
+// body is read afterwards with ReadBody. Splitting the two lets the caller
+// use different deadlines for each.
+func RequestHeadFromReader(br *bufio.Reader) (*Request, error) {
+	if _, err := br.Peek(1); err != nil {
+		return nil, err
+	}
 	request := NewRequest()
-	buffer := make([]byte, 1024)
-	bufferLen := 0
+	err := request.readFrom(br, func() bool {
+		return request.state != StateInitialized && request.state != StateHeaders
+	})
+	if err != nil {
+		return nil, err
+	}
+	return request, nil
+}
 
-	for request.state != StateDone {
-		n, err := reader.Read(buffer[bufferLen:])
-		if err != nil {
-			break
+// ReadBody reads the rest of a request returned by RequestHeadFromReader. A
+// body larger than maxSize fails with ErrBodyTooLarge, zero or less means no
+// limit. A Content-Length over the limit fails before the body is read.
+func (r *Request) ReadBody(br *bufio.Reader, maxSize int) error {
+	r.maxBody = maxSize
+	// Reading the head may have consumed the start of the body already.
+	if maxSize > 0 && len(r.body)+r.remaining > maxSize {
+		return ErrBodyTooLarge
+	}
+	err := r.readFrom(br, func() bool {
+		return r.state == StateDone
+	})
+	if err != nil {
+		return err
+	}
+	if r.body != nil {
+		r.Body = string(r.body)
+		r.body = nil
+	}
+	return nil
+}
+
+func (r *Request) readFrom(br *bufio.Reader, done func() bool) error {
+	need := 1
+	for !done() {
+		if _, err := br.Peek(need); err != nil {
+			if errors.Is(err, bufio.ErrBufferFull) {
+				return ErrLineTooLong
+			}
+			if errors.Is(err, io.EOF) {
+				return r.eofError(br)
+			}
+			return err
 		}
-		bufferLen += n
-		bytesProcessed, err := request.parse(buffer[:bufferLen])
+		data, _ := br.Peek(br.Buffered())
+		bytesProcessed, err := r.parse(data)
 		if err != nil {
-			return nil, err
+			return err
 		}
-		if bytesProcessed > 0 {
-			bufferLen -= bytesProcessed
-			buffer = buffer[bytesProcessed:]
+		br.Discard(bytesProcessed)
+
+		need = 1
+		if bytesProcessed == 0 {
+			need = br.Buffered() + 1
 		}
 	}
+	return nil
+}
 
-	if getIntFromHeader(request.Headers, "content-length", 0) != len(request.Body) {
-		return nil, ErrBodyWithinContentLength
+// eofError is the error for a connection that ended before the request was
+// complete. Only a connection that ended between requests returns io.EOF.
+func (r *Request) eofError(br *bufio.Reader) error {
+	switch {
+	case r.state == StateInitialized && br.Buffered() == 0:
+		return io.EOF
+	case r.state == StateBody:
+		return fmt.Errorf("%w: %w", ErrBodyWithinContentLength, io.ErrUnexpectedEOF)
+	case r.chunked:
+		return fmt.Errorf("%w: %w", ErrMalformedChunk, io.ErrUnexpectedEOF)
+	default:
+		return io.ErrUnexpectedEOF
 	}
+}
 
-	return request, nil
+// Write writes the request in wire format, the way a client sends it. A
+// Content-Length header is added when the request has a body but none was set.
+func (r *Request) Write(w io.Writer) error {
+	version := r.RequestLine.HTTPVersion
+	if version == "" {
+		version = "1.1"
+	}
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "%s %s HTTP/%s\r\n", r.RequestLine.Method, r.RequestLine.RequestTarget, version)
+	for key, value := range r.Headers {
+		fmt.Fprintf(bw, "%s: %s\r\n", key, value)
+	}
+	if _, ok := r.Headers.Get("content-length"); !ok && (r.Body != "" || r.RequestLine.Method == "POST" || r.RequestLine.Method == "PUT") {
+		fmt.Fprintf(bw, "content-length: %d\r\n", len(r.Body))
+	}
+	bw.WriteString("\r\n")
+	bw.WriteString(r.Body)
+	return bw.Flush()
 }
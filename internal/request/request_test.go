@@ -1,7 +1,11 @@
 package request
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"io"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -33,6 +37,24 @@ func TestRequestFromReader(t *testing.T) {
 	assert.Equal(t, "/coffee", r.RequestLine.RequestTarget)
 	assert.Equal(t, "1.1", r.RequestLine.HTTPVersion)
 
+	// Test: Good HTTP/1.0 Request line
+	reader = &chunkReader{
+		data:            "GET /coffee HTTP/1.0\r\nHost: localhost:42069\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Equal(t, "1.0", r.RequestLine.HTTPVersion)
+
+	// Test: Unsupported HTTP version
+	reader = &chunkReader{
+		data:            "GET /coffee HTTP/2.0\r\nHost: localhost:42069\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err = RequestFromReader(reader)
+	require.ErrorIs(t, err, ErrUnsupportedHTTPVersion)
+
 	// Test: Invalid number of parts in request line
 	reader = &chunkReader{
 		data:            "/coffee HTTP/1.1\r\nHost: localhost:42069\r\nUser-Agent: curl/7.81.0\r\nAccept: */*\r\n\r\n",
@@ -94,12 +116,8 @@ func TestRequestFromReader(t *testing.T) {
 		data:            "GET /cats HTTP/1.1\r\nfoo: BiZ\r\n",
 		numBytesPerRead: 16,
 	}
-	r, err = RequestFromReader(reader)
-	require.NoError(t, err)
-	require.NotNil(t, r)
-	require.Equal(t, 1, len(r.Headers))
-	h, _ = r.Headers.Get("foo")
-	assert.Equal(t, "BiZ", h)
+	_, err = RequestFromReader(reader)
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
 }
 
 func TestRequestBody(t *testing.T) {
@@ -159,6 +177,179 @@ func TestRequestBody(t *testing.T) {
 	assert.Equal(t, "", string(r.Body))
 }
 
+func TestRequestBody_Framing(t *testing.T) {
+	// Test: Chunked body is decoded, trailer fields are kept apart from the
+	// headers
+	br := bufio.NewReader(&chunkReader{
+		data: "POST /upload HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n" +
+			"5;name=value\r\nhello\r\n7\r\n world!\r\n0\r\nChecksum: abc\r\nHost: evil\r\n\r\n" +
+			"GET /next HTTP/1.1\r\n\r\n",
+		numBytesPerRead: 5,
+	})
+	r, err := RequestFromReader(br)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world!", r.Body)
+	checksum, _ := r.Trailers.Get("checksum")
+	assert.Equal(t, "abc", checksum)
+	_, ok := r.Headers.Get("checksum")
+	assert.False(t, ok)
+	host, _ := r.Headers.Get("host")
+	assert.Equal(t, "a", host)
+	r, err = RequestFromReader(br)
+	require.NoError(t, err)
+	assert.Equal(t, "/next", r.RequestLine.RequestTarget)
+
+	// Test: Repeated identical Content-Length is accepted
+	r, err = RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\nContent-Length: 2\r\nContent-Length: 2\r\n\r\nhi"))
+	require.NoError(t, err)
+	assert.Equal(t, "hi", r.Body)
+
+	// Test: Ambiguous or invalid framing is rejected
+	for _, tc := range []struct {
+		head string
+		err  error
+	}{
+		{"Transfer-Encoding: chunked\r\nContent-Length: 5\r\n", ErrAmbiguousFraming},
+		{"Transfer-Encoding: gzip, chunked\r\n", ErrUnsupportedTransferEncoding},
+		{"Transfer-Encoding: gzip\r\n", ErrChunkedNotLast},
+		{"Transfer-Encoding: chunked, identity\r\n", ErrChunkedNotLast},
+		{"Content-Length: abc\r\n", ErrMalformedContentLength},
+		{"Content-Length: -5\r\n", ErrMalformedContentLength},
+		{"Content-Length: +5\r\n", ErrMalformedContentLength},
+		{"Content-Length: 5\r\nContent-Length: 6\r\n", ErrMalformedContentLength},
+	} {
+		_, err := RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\n" + tc.head + "\r\nhello"))
+		assert.ErrorIs(t, err, tc.err, tc.head)
+	}
+
+	// Test: Malformed chunks are rejected
+	for _, body := range []string{"zz\r\nhello\r\n0\r\n\r\n", "5\r\nhelloX\r\n0\r\n\r\n", "-5\r\nhello\r\n0\r\n\r\n"} {
+		_, err := RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n" + body))
+		assert.ErrorIs(t, err, ErrMalformedChunk, body)
+	}
+	_, err = RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhel"))
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
+
+func TestRequestFromReader_Persistent(t *testing.T) {
+	// Test: Pipelined requests are read one after the other
+	br := bufio.NewReader(&chunkReader{
+		data: "POST /first HTTP/1.1\r\nContent-Length: 5\r\n\r\nhello" +
+			"GET /second HTTP/1.1\r\nHost: localhost:42069\r\n\r\n",
+		numBytesPerRead: 7,
+	})
+	r, err := RequestFromReader(br)
+	require.NoError(t, err)
+	assert.Equal(t, "/first", r.RequestLine.RequestTarget)
+	assert.Equal(t, "hello", r.Body)
+	r, err = RequestFromReader(br)
+	require.NoError(t, err)
+	assert.Equal(t, "/second", r.RequestLine.RequestTarget)
+
+	// Test: No more requests
+	_, err = RequestFromReader(br)
+	require.ErrorIs(t, err, io.EOF)
+
+	// Test: A connection ending within a request is no complete request
+	for _, data := range []string{
+		"GE",
+		"GET /x HTTP/1.1\r\nHost: x\r\n",
+		"POST / HTTP/1.1\r\nContent-Length: 5\r\n\r\nhi",
+	} {
+		_, err = RequestFromReader(bufio.NewReader(strings.NewReader(data)))
+		require.ErrorIs(t, err, io.ErrUnexpectedEOF, data)
+	}
+
+	// Test: Line longer than the read buffer
+	br = bufio.NewReaderSize(&chunkReader{
+		data:            "GET /" + strings.Repeat("a", 64) + " HTTP/1.1\r\n\r\n",
+		numBytesPerRead: 16,
+	}, 16)
+	_, err = RequestFromReader(br)
+	require.ErrorIs(t, err, ErrLineTooLong)
+}
+
+func TestRequestHeadFromReader(t *testing.T) {
+	// Test: Head is read without waiting for the body
+	src := &chunkReader{
+		data:            "POST /upload HTTP/1.1\r\nContent-Length: 5\r\n\r\n",
+		numBytesPerRead: 4,
+	}
+	br := bufio.NewReader(src)
+	r, err := RequestHeadFromReader(br)
+	require.NoError(t, err)
+	assert.Equal(t, "/upload", r.RequestLine.RequestTarget)
+	assert.Empty(t, r.Body)
+
+	// Test: Body is read afterwards
+	src.data += "hello"
+	require.NoError(t, r.ReadBody(br, 0))
+	assert.Equal(t, "hello", r.Body)
+
+	// Test: Body shorter than Content-Length
+	br = bufio.NewReader(strings.NewReader("POST / HTTP/1.1\r\nContent-Length: 5\r\n\r\nhi"))
+	r, err = RequestHeadFromReader(br)
+	require.NoError(t, err)
+	require.ErrorIs(t, r.ReadBody(br, 0), ErrBodyWithinContentLength)
+
+	// Test: Body over the limit is rejected, by Content-Length before it is read
+	for _, raw := range []string{
+		"POST / HTTP/1.1\r\nContent-Length: 5\r\n\r\n",
+		"POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabc\r\n2\r\nde\r\n0\r\n\r\n",
+	} {
+		br = bufio.NewReader(strings.NewReader(raw))
+		r, err = RequestHeadFromReader(br)
+		require.NoError(t, err)
+		assert.ErrorIs(t, r.ReadBody(br, 4), ErrBodyTooLarge)
+	}
+
+	// Test: Body within the limit is read
+	br = bufio.NewReader(strings.NewReader("POST / HTTP/1.1\r\nContent-Length: 4\r\n\r\nabcd"))
+	r, err = RequestHeadFromReader(br)
+	require.NoError(t, err)
+	require.NoError(t, r.ReadBody(br, 4))
+	assert.Equal(t, "abcd", r.Body)
+}
+
+func TestRequest_Context(t *testing.T) {
+	type key struct{}
+
+	// Test: Context defaults to background
+	r := NewRequest()
+	assert.Equal(t, context.Background(), r.Context())
+
+	// Test: WithContext returns a copy with the new context
+	ctx := context.WithValue(context.Background(), key{}, "id-1")
+	r2 := r.WithContext(ctx)
+	assert.Equal(t, "id-1", r2.Context().Value(key{}))
+	assert.Equal(t, context.Background(), r.Context())
+	assert.Panics(t, func() { r.WithContext(nil) })
+}
+
+func TestRequestWrite(t *testing.T) {
+	// Test: Request with body gets a Content-Length
+	r := NewRequest()
+	r.RequestLine = RequestLine{Method: "POST", RequestTarget: "/submit", HTTPVersion: "1.1"}
+	r.Headers.Set("Host", "localhost:42069")
+	r.Body = "hello world!\n"
+	buf := &bytes.Buffer{}
+	require.NoError(t, r.Write(buf))
+	assert.Equal(t, "POST /submit HTTP/1.1\r\nhost: localhost:42069\r\ncontent-length: 13\r\n\r\nhello world!\n", buf.String())
+
+	// Test: Written request parses back
+	parsed, err := RequestFromReader(buf)
+	require.NoError(t, err)
+	assert.Equal(t, r.RequestLine, parsed.RequestLine)
+	assert.Equal(t, r.Body, parsed.Body)
+
+	// Test: GET without body
+	r = NewRequest()
+	r.RequestLine = RequestLine{Method: "GET", RequestTarget: "/"}
+	buf = &bytes.Buffer{}
+	require.NoError(t, r.Write(buf))
+	assert.Equal(t, "GET / HTTP/1.1\r\n\r\n", buf.String())
+}
+
 type chunkReader struct {
 	data            string
 	numBytesPerRead int
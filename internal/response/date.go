@@ -0,0 +1,28 @@
+package response
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// TimeFormat is the IMF-fixdate format used by the Date header.
+const TimeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+type cachedDate struct {
+	unix  int64
+	value string
+}
+
+var lastDate atomic.Pointer[cachedDate]
+
+// httpDate returns the current time formatted for the Date header. The value
+// only changes once per second, so it is formatted at most once per second.
+func httpDate() string {
+	now := time.Now()
+	if d := lastDate.Load(); d != nil && d.unix == now.Unix() {
+		return d.value
+	}
+	d := &cachedDate{unix: now.Unix(), value: now.UTC().Format(TimeFormat)}
+	lastDate.Store(d)
+	return d.value
+}
@@ -0,0 +1,57 @@
+package response
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+)
+
+var (
+	ErrNotHijackable = fmt.Errorf("connection cannot be hijacked")
+	ErrHijacked      = fmt.Errorf("connection was hijacked")
+)
+
+// Hijacker hands the connection of a response over to the handler. It
+// returns the connection and a reader holding whatever the client sent
+// after the request.
+type Hijacker func() (net.Conn, *bufio.Reader, error)
+
+// SetHijacker lets the handler take the connection over through Hijack. The
+// server sets it for HTTP/1.1 connections, writers without one cannot be
+// hijacked.
+func (w *Writer) SetHijacker(h Hijacker) {
+	w.hijacker = h
+}
+
+// Hijack takes the connection over for a protocol other than HTTP, such as a
+// tunnel. What was written explicitly so far is flushed first, so a handler
+// can send a 101 response before switching; a body buffered through Write is
+// dropped. Afterwards the writer refuses every write and the server neither
+// writes to nor closes the connection, that is up to the handler. The reader
+// has to be used instead of the connection, it may already hold data.
+func (w *Writer) Hijack() (net.Conn, *bufio.Reader, error) {
+	switch {
+	case w.hijacked:
+		return nil, nil, ErrHijacked
+	case w.hijacker == nil:
+		return nil, nil, ErrNotHijackable
+	case w.state == stateDone:
+		return nil, nil, ErrResponseFinished
+	}
+	if err := w.writer.Flush(); err != nil {
+		return nil, nil, err
+	}
+	conn, br, err := w.hijacker()
+	if err != nil {
+		return nil, nil, err
+	}
+	w.hijacked = true
+	w.state = stateDone
+	w.body = nil
+	return conn, br, nil
+}
+
+// Hijacked reports whether the connection was taken over by Hijack.
+func (w *Writer) Hijacked() bool {
+	return w.hijacked
+}
@@ -0,0 +1,395 @@
+package response
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+
+	"github.com/ramonvermeulen/httpfromtcp/internal/headers"
+	"github.com/ramonvermeulen/httpfromtcp/internal/request"
+)
+
+var (
+	ErrMalformedStatusLine    = fmt.Errorf("malformed status line")
+	ErrUnsupportedHTTPVersion = fmt.Errorf("unsupported HTTP version")
+	ErrMalformedContentLength = fmt.Errorf("malformed content-length")
+	ErrMalformedChunk         = fmt.Errorf("malformed chunk")
+	ErrLineTooLong            = fmt.Errorf("line exceeds read buffer")
+	ErrParsingInDoneState     = fmt.Errorf("attempted to parse response in done state")
+	ErrIncompleteResponse     = fmt.Errorf("connection closed before response was complete")
+	LineSeparator             = []byte("\r\n")
+)
+
+type responseState int
+
+const (
+	readingStatusLine responseState = iota
+	readingHeaders
+	readingBody
+	readingChunkSize
+	readingChunkData
+	readingChunkEnd
+	readingTrailers
+	readingUntilClose
+	readingDone
+)
+
+type StatusLine struct {
+	HTTPVersion  string
+	StatusCode   StatusCode
+	ReasonPhrase string
+}
+
+// Response is a response as read by a client, the counterpart of
+// request.Request.
+type Response struct {
+	StatusLine StatusLine
+	Headers    headers.Headers
+	Body       string
+	Trailers   headers.Headers
+
+	state      responseState
+	method     string
+	body       []byte
+	remaining  int
+	untilClose bool
+}
+
+// NewResponse returns a response ready to be parsed, method is the method of
+// the request it answers since a HEAD response never has a body.
+func NewResponse(method string) *Response {
+	return &Response{
+		Headers:  headers.NewHeaders(),
+		Trailers: headers.NewHeaders(),
+		state:    readingStatusLine,
+		method:   method,
+	}
+}
+
+// bodyFraming decides how the body is delimited once the headers are known,
+// following RFC 9112 section 6.3.
+func (r *Response) bodyFraming() (responseState, error) {
+	code := r.StatusLine.StatusCode
+	if r.method == "HEAD" || code < 200 || code == StatusNoContent || code == StatusNotModified {
+		return readingDone, nil
+	}
+
+	if te, ok := r.Headers.Get("transfer-encoding"); ok {
+		codings := headers.ListValues(te)
+		if len(codings) > 0 && codings[len(codings)-1] == "chunked" {
+			return readingChunkSize, nil
+		}
+		r.untilClose = true
+		return readingUntilClose, nil
+	}
+
+	if cl, ok := r.Headers.Get("content-length"); ok {
+		n, err := strconv.Atoi(cl)
+		if err != nil || n < 0 {
+			return 0, ErrMalformedContentLength
+		}
+		if n == 0 {
+			return readingDone, nil
+		}
+		r.remaining = n
+		return readingBody, nil
+	}
+
+	r.untilClose = true
+	return readingUntilClose, nil
+}
+
+func (r *Response) parse(data []byte) (int, error) {
+	read := 0
+
+outer:
+	for r.state != readingDone {
+		currentData := data[read:]
+		if len(currentData) == 0 {
+			break outer
+		}
+
+		switch r.state {
+		case readingStatusLine:
+			bp, sl, err := parseStatusLine(currentData)
+			if err != nil {
+				return 0, err
+			}
+			if bp == 0 {
+				break outer
+			}
+			r.StatusLine = *sl
+			r.state = readingHeaders
+			read += bp
+
+		case readingHeaders:
+			bp, done, err := r.Headers.Parse(currentData)
+			if err != nil {
+				return 0, err
+			}
+			if bp == 0 {
+				break outer
+			}
+			read += bp
+
+			if done {
+				r.state, err = r.bodyFraming()
+				if err != nil {
+					return 0, err
+				}
+			}
+
+		case readingBody:
+			n := min(r.remaining, len(currentData))
+			r.body = append(r.body, currentData[:n]...)
+			r.remaining -= n
+			read += n
+
+			if r.remaining == 0 {
+				r.state = readingDone
+			}
+
+		case readingChunkSize:
+			bp, size, err := parseChunkSize(currentData)
+			if err != nil {
+				return 0, err
+			}
+			if bp == 0 {
+				break outer
+			}
+			read += bp
+
+			if size == 0 {
+				r.state = readingTrailers
+			} else {
+				r.remaining = size
+				r.state = readingChunkData
+			}
+
+		case readingChunkData:
+			n := min(r.remaining, len(currentData))
+			r.body = append(r.body, currentData[:n]...)
+			r.remaining -= n
+			read += n
+
+			if r.remaining == 0 {
+				r.state = readingChunkEnd
+			}
+
+		case readingChunkEnd:
+			if len(currentData) < len(LineSeparator) {
+				break outer
+			}
+			if !bytes.HasPrefix(currentData, LineSeparator) {
+				return 0, ErrMalformedChunk
+			}
+			read += len(LineSeparator)
+			r.state = readingChunkSize
+
+		case readingTrailers:
+			bp, done, err := r.Trailers.Parse(currentData)
+			if err != nil {
+				return 0, err
+			}
+			if bp == 0 {
+				break outer
+			}
+			read += bp
+
+			if done {
+				r.state = readingDone
+			}
+
+		case readingUntilClose:
+			r.body = append(r.body, currentData...)
+			read += len(currentData)
+
+		case readingDone:
+			return 0, ErrParsingInDoneState
+
+		default:
+			panic("This should never happen")
+		}
+	}
+	return read, nil
+}
+
+func parseStatusLine(data []byte) (int, *StatusLine, error) {
+	lsIdx := bytes.Index(data, LineSeparator)
+	if lsIdx == -1 {
+		return 0, nil, nil
+	}
+
+	line := data[:lsIdx]
+	parts := bytes.SplitN(line, []byte(" "), 3)
+	if len(parts) < 2 {
+		return 0, nil, ErrMalformedStatusLine
+	}
+
+	versionParts := bytes.Split(parts[0], []byte("/"))
+	if len(versionParts) != 2 || !bytes.Equal(versionParts[0], []byte("HTTP")) || !slices.ContainsFunc(request.HTTPVersions, func(v []byte) bool {
+		return bytes.Equal(v, versionParts[1])
+	}) {
+		return 0, nil, ErrUnsupportedHTTPVersion
+	}
+
+	if len(parts[1]) != 3 {
+		return 0, nil, ErrMalformedStatusLine
+	}
+	code, err := strconv.Atoi(string(parts[1]))
+	if err != nil || code < 100 {
+		return 0, nil, ErrMalformedStatusLine
+	}
+
+	sl := &StatusLine{
+		HTTPVersion: string(versionParts[1]),
+		StatusCode:  StatusCode(code),
+	}
+	if len(parts) == 3 {
+		sl.ReasonPhrase = string(parts[2])
+	}
+	return lsIdx + len(LineSeparator), sl, nil
+}
+
+// parseChunkSize parses the line in front of every chunk, chunk extensions
+// are ignored.
+func parseChunkSize(data []byte) (int, int, error) {
+	lsIdx := bytes.Index(data, LineSeparator)
+	if lsIdx == -1 {
+		return 0, 0, nil
+	}
+
+	size, _, _ := bytes.Cut(data[:lsIdx], []byte(";"))
+	n, err := strconv.ParseInt(string(bytes.TrimSpace(size)), 16, 32)
+	if err != nil || n < 0 {
+		return 0, 0, ErrMalformedChunk
+	}
+	return lsIdx + len(LineSeparator), int(n), nil
+}
+
+// readFrom feeds data from br into the parser until done reports true. Only
+// the bytes that belong to the response are consumed from br.
+func (r *Response) readFrom(br *bufio.Reader, done func() bool) error {
+	need := 1
+	for !done() {
+		if _, err := br.Peek(need); err != nil {
+			switch {
+			case errors.Is(err, bufio.ErrBufferFull):
+				return ErrLineTooLong
+			case errors.Is(err, io.EOF) && r.state == readingUntilClose:
+				r.state = readingDone
+				return nil
+			case errors.Is(err, io.EOF):
+				return ErrIncompleteResponse
+			default:
+				return err
+			}
+		}
+
+		data, _ := br.Peek(br.Buffered())
+		n, err := r.parse(data)
+		if err != nil {
+			return err
+		}
+		br.Discard(n)
+
+		need = 1
+		if n == 0 {
+			need = br.Buffered() + 1
+		}
+	}
+	return nil
+}
+
+// ResponseHeadFromReader reads the status line and headers of a response,
+// the body can then be streamed with BodyReader. Method is the method of the
+// request the response answers.
+func ResponseHeadFromReader(br *bufio.Reader, method string) (*Response, error) {
+	response := NewResponse(method)
+	err := response.readFrom(br, func() bool {
+		return response.state > readingHeaders
+	})
+	if err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+type bodyReader struct {
+	response *Response
+	br       *bufio.Reader
+	err      error
+}
+
+// BodyReader streams the body of a response read by ResponseHeadFromReader,
+// decoding chunked bodies on the fly. Trailers are available once the reader
+// returned io.EOF.
+func (r *Response) BodyReader(br *bufio.Reader) io.Reader {
+	return &bodyReader{response: r, br: br}
+}
+
+func (b *bodyReader) Read(p []byte) (int, error) {
+	r := b.response
+	if len(r.body) == 0 && b.err == nil {
+		b.err = r.readFrom(b.br, func() bool {
+			return len(r.body) > 0 || r.state == readingDone
+		})
+	}
+	if len(r.body) > 0 {
+		n := copy(p, r.body)
+		r.body = r.body[n:]
+		return n, nil
+	}
+	if b.err != nil {
+		return 0, b.err
+	}
+	return 0, io.EOF
+}
+
+// Done reports whether the response, including its body, was read completely.
+func (r *Response) Done() bool {
+	return r.state == readingDone
+}
+
+// KeepAlive reports whether the connection the response was read from can
+// carry another request once the body is read.
+func (r *Response) KeepAlive() bool {
+	if r.untilClose {
+		return false
+	}
+	value, _ := r.Headers.Get("connection")
+	connection := headers.ListValues(value)
+	if slices.Contains(connection, "close") {
+		return false
+	}
+	if r.StatusLine.HTTPVersion == "1.0" {
+		return slices.Contains(connection, "keep-alive")
+	}
+	return true
+}
+
+// ResponseFromReader reads a complete response from reader. Method is the
+// method of the request the response answers. If reader is a *bufio.Reader
+// anything following the response is left in it.
+func ResponseFromReader(reader io.Reader, method string) (*Response, error) {
+	br, ok := reader.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(reader)
+	}
+
+	response, err := ResponseHeadFromReader(br, method)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(response.BodyReader(br))
+	if err != nil {
+		return nil, err
+	}
+
+	response.Body = string(body)
+	return response, nil
+}
@@ -0,0 +1,179 @@
+package response
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResponseFromReader(t *testing.T) {
+	// Test: Content-Length body
+	reader := &chunkReader{
+		data: "HTTP/1.1 200 OK\r\n" +
+			"Content-Type: text/plain\r\n" +
+			"Content-Length: 13\r\n" +
+			"\r\n" +
+			"hello world!\n",
+		numBytesPerRead: 3,
+	}
+	r, err := ResponseFromReader(reader, "GET")
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Equal(t, "1.1", r.StatusLine.HTTPVersion)
+	assert.Equal(t, StatusOK, r.StatusLine.StatusCode)
+	assert.Equal(t, "OK", r.StatusLine.ReasonPhrase)
+	h, _ := r.Headers.Get("content-type")
+	assert.Equal(t, "text/plain", h)
+	assert.Equal(t, "hello world!\n", r.Body)
+
+	// Test: Reason phrase with spaces and missing reason phrase
+	reader = &chunkReader{
+		data:            "HTTP/1.1 500 Internal Server Error\r\nContent-Length: 0\r\n\r\n",
+		numBytesPerRead: 1,
+	}
+	r, err = ResponseFromReader(reader, "GET")
+	require.NoError(t, err)
+	assert.Equal(t, StatusError, r.StatusLine.StatusCode)
+	assert.Equal(t, "Internal Server Error", r.StatusLine.ReasonPhrase)
+
+	reader = &chunkReader{
+		data:            "HTTP/1.1 418\r\nContent-Length: 0\r\n\r\n",
+		numBytesPerRead: 1,
+	}
+	r, err = ResponseFromReader(reader, "GET")
+	require.NoError(t, err)
+	assert.Equal(t, StatusCode(418), r.StatusLine.StatusCode)
+	assert.Empty(t, r.StatusLine.ReasonPhrase)
+
+	// Test: Chunked body with extensions and trailers
+	reader = &chunkReader{
+		data: "HTTP/1.1 200 OK\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"Trailer: X-Content-Length\r\n" +
+			"\r\n" +
+			"6;name=value\r\nhello \r\n" +
+			"6\r\nworld!\r\n" +
+			"0\r\n" +
+			"X-Content-Length: 12\r\n" +
+			"\r\n",
+		numBytesPerRead: 5,
+	}
+	r, err = ResponseFromReader(reader, "GET")
+	require.NoError(t, err)
+	assert.Equal(t, "hello world!", r.Body)
+	h, _ = r.Trailers.Get("x-content-length")
+	assert.Equal(t, "12", h)
+
+	// Test: Close-delimited body
+	reader = &chunkReader{
+		data:            "HTTP/1.0 200 OK\r\nConnection: close\r\n\r\nuntil the very end",
+		numBytesPerRead: 4,
+	}
+	r, err = ResponseFromReader(reader, "GET")
+	require.NoError(t, err)
+	assert.Equal(t, "1.0", r.StatusLine.HTTPVersion)
+	assert.Equal(t, "until the very end", r.Body)
+
+	// Test: Responses without a body
+	for _, tc := range []struct {
+		status string
+		method string
+	}{
+		{"100 Continue", "GET"},
+		{"204 No Content", "GET"},
+		{"304 Not Modified", "GET"},
+		{"200 OK", "HEAD"},
+	} {
+		br := bufio.NewReader(strings.NewReader("HTTP/1.1 " + tc.status + "\r\nContent-Length: 5\r\n\r\nHTTP/1.1 200 OK"))
+		r, err = ResponseFromReader(br, tc.method)
+		require.NoError(t, err, tc.status)
+		assert.Empty(t, r.Body, tc.status)
+		rest, _ := io.ReadAll(br)
+		assert.Equal(t, "HTTP/1.1 200 OK", string(rest), tc.status)
+	}
+
+	// Test: Pipelined responses are left in the reader
+	br := bufio.NewReader(&chunkReader{
+		data:            "HTTP/1.1 200 OK\r\nContent-Length: 3\r\n\r\noneHTTP/1.1 200 OK\r\nContent-Length: 3\r\n\r\ntwo",
+		numBytesPerRead: 7,
+	})
+	r, err = ResponseFromReader(br, "GET")
+	require.NoError(t, err)
+	assert.Equal(t, "one", r.Body)
+	r, err = ResponseFromReader(br, "GET")
+	require.NoError(t, err)
+	assert.Equal(t, "two", r.Body)
+
+	// Test: Body shorter than Content-Length
+	reader = &chunkReader{
+		data:            "HTTP/1.1 200 OK\r\nContent-Length: 200\r\n\r\npartial content",
+		numBytesPerRead: 3,
+	}
+	_, err = ResponseFromReader(reader, "GET")
+	require.ErrorIs(t, err, ErrIncompleteResponse)
+
+	// Test: Malformed chunk
+	reader = &chunkReader{
+		data:            "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\nzz\r\nhello\r\n0\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err = ResponseFromReader(reader, "GET")
+	require.ErrorIs(t, err, ErrMalformedChunk)
+
+	// Test: Malformed status line
+	reader = &chunkReader{
+		data:            "HTTP/1.1 OK\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err = ResponseFromReader(reader, "GET")
+	require.ErrorIs(t, err, ErrMalformedStatusLine)
+
+	// Test: Unsupported HTTP version
+	reader = &chunkReader{
+		data:            "HTTP/2 200 OK\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err = ResponseFromReader(reader, "GET")
+	require.ErrorIs(t, err, ErrUnsupportedHTTPVersion)
+}
+
+func TestResponse_KeepAlive(t *testing.T) {
+	for _, tc := range []struct {
+		response  string
+		keepAlive bool
+	}{
+		{"HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n", true},
+		{"HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n", true},
+		{"HTTP/1.1 200 OK\r\nContent-Length: 0\r\nConnection: Close\r\n\r\n", false},
+		{"HTTP/1.1 200 OK\r\n\r\nuntil close", false},
+		{"HTTP/1.0 200 OK\r\nContent-Length: 0\r\n\r\n", false},
+		{"HTTP/1.0 200 OK\r\nContent-Length: 0\r\nConnection: keep-alive\r\n\r\n", true},
+	} {
+		r, err := ResponseFromReader(strings.NewReader(tc.response), "GET")
+		require.NoError(t, err)
+		assert.Equal(t, tc.keepAlive, r.KeepAlive(), tc.response)
+	}
+}
+
+type chunkReader struct {
+	data            string
+	numBytesPerRead int
+	pos             int
+}
+
+// Read reads up to len(p) or numBytesPerRead bytes from the string per call
+// its useful for simulating reading a variable number of bytes per chunk from a network connection
+func (cr *chunkReader) Read(p []byte) (n int, err error) {
+	if cr.pos >= len(cr.data) {
+		return 0, io.EOF
+	}
+	endIndex := min(cr.pos+cr.numBytesPerRead, len(cr.data))
+	n = copy(p, cr.data[cr.pos:endIndex])
+	cr.pos += n
+
+	return n, nil
+}
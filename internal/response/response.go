@@ -1,83 +1,540 @@
 package response
 
 import (
+	"bufio"
 	"fmt"
 	"io"
+	"slices"
+	"strconv"
 
 	"github.com/ramonvermeulen/httpfromtcp/internal/headers"
+	"github.com/ramonvermeulen/httpfromtcp/internal/request"
 )
 
-type StatusCode int
-
-const (
-	StatusOK         StatusCode = 200
-	StatusBadRequest StatusCode = 400
-	StatusError      StatusCode = 500
+var (
+	ErrInvalidWriteOrder = fmt.Errorf("response parts written out of order")
+	ErrResponseFinished  = fmt.Errorf("response already finished")
 )
 
+// maxBufferedBody is how much of a body written through Write is held back
+// before the writer gives up on Content-Length and starts streaming.
+const maxBufferedBody = 4096
+
 func GetDefaultHeaders(contentLen int) headers.Headers {
 	h := headers.NewHeaders()
 	h.Set("Content-Length", fmt.Sprintf("%d", contentLen))
-	h.Set("Connection", "close")
 	h.Set("Content-Type", "text/plain")
 	return h
 }
 
+type writerState int
+
+const (
+	stateStatusLine writerState = iota
+	stateHeaders
+	stateBuffering
+	stateBody
+	stateDone
+)
+
+// Writer buffers the response in memory, output only reaches the underlying
+// writer once the buffer fills up or Flush is called.
+//
+// A response can be written in two ways. The explicit way is calling
+// WriteStatusLine, WriteHeaders and then WriteBody or WriteChunkedBody, where
+// the handler is responsible for the framing headers. The automatic way is
+// setting Header and SetStatus and writing the body through Write, in which
+// case the writer picks Content-Length, chunked or close-delimited framing
+// itself once Finish is called or the body outgrows its buffer.
 type Writer struct {
-	writer io.Writer
+	// DropTrailersWithoutTE makes WriteTrailers leave the trailer section
+	// empty when the request did not announce "TE: trailers".
+	DropTrailersWithoutTE bool
+	// SendDate adds a Date header to the response unless it already has one.
+	SendDate bool
+	// ServerName is sent as the Server header unless the response already
+	// has one, no Server header is sent when it is empty.
+	ServerName string
+
+	writer *bufio.Writer
+	state  writerState
+	req    *request.Request
+
+	status   StatusCode
+	header   headers.Headers
+	body     []byte
+	chunked  bool
+	discard  bool
+	head     bool
+	headLen  int
+	trailers []string
+
+	closeAfter  bool
+	declaredLen int
+	bodyWritten int
+	// explicitChunked is set for a chunked body written by the handler,
+	// lastChunk and trailersDone track whether it was ended properly.
+	explicitChunked bool
+	lastChunk       bool
+	trailersDone    bool
+
+	headerHooks []func(StatusCode, headers.Headers)
+	writeHooks  []func([]byte)
+
+	stream      Stream
+	streamEnded bool
+
+	hijacker Hijacker
+	hijacked bool
 }
 
 func NewWriter(w io.Writer) *Writer {
-	return &Writer{w}
+	return &Writer{
+		writer:      bufio.NewWriter(w),
+		state:       stateStatusLine,
+		status:      StatusOK,
+		header:      headers.NewHeaders(),
+		declaredLen: -1,
+	}
+}
+
+// SetRequest tells the writer which request it is answering, so framing can
+// take the protocol version of the client into account. For a HEAD request
+// the body is counted but never sent, the headers stay what a GET would get.
+func (w *Writer) SetRequest(req *request.Request) {
+	w.req = req
+	w.head = req.RequestLine.Method == "HEAD"
+	w.discard = w.discard || w.head
+
+	value, _ := req.Headers.Get("connection")
+	connection := headers.ListValues(value)
+	if slices.Contains(connection, "close") ||
+		req.RequestLine.HTTPVersion == "1.0" && !slices.Contains(connection, "keep-alive") {
+		w.closeAfter = true
+	}
+}
+
+// CloseAfterResponse marks the response as the last one on its connection,
+// it is sent with "Connection: close". It has no effect once the headers
+// went out.
+func (w *Writer) CloseAfterResponse() {
+	w.closeAfter = true
+}
+
+// KeepAlive reports whether the connection can carry another request after
+// this response. That is not the case when either side asked to close it,
+// when the body is delimited by closing the connection or when the response
+// was not completed properly.
+func (w *Writer) KeepAlive() bool {
+	return w.state == stateDone && !w.closeAfter
+}
+
+// Started reports whether the status line was written, from then on the
+// response can no longer be replaced by another one.
+func (w *Writer) Started() bool {
+	return w.state != stateStatusLine && w.state != stateBuffering
+}
+
+// Status returns the status code of the response, it is only final once
+// the headers were written.
+func (w *Writer) Status() StatusCode {
+	return w.status
+}
+
+// OnHeaders registers fn to be called right before the header section is
+// written, with the status code and the headers about to be sent. Middleware
+// uses it to observe the response or to change its headers.
+func (w *Writer) OnHeaders(fn func(status StatusCode, h headers.Headers)) {
+	w.headerHooks = append(w.headerHooks, fn)
+}
+
+// OnWrite registers fn to be called with every piece of body the handler
+// writes, before chunked framing. fn must not keep p.
+func (w *Writer) OnWrite(fn func(p []byte)) {
+	w.writeHooks = append(w.writeHooks, fn)
+}
+
+func (w *Writer) observeWrite(p []byte) {
+	for _, fn := range w.writeHooks {
+		fn(p)
+	}
+}
+
+// Header returns the headers sent with a response written through Write.
+func (w *Writer) Header() headers.Headers {
+	return w.header
+}
+
+// SetStatus sets the status code sent with a response written through Write,
+// it has no effect once the headers went out.
+func (w *Writer) SetStatus(statusCode StatusCode) {
+	w.status = statusCode
 }
 
 func (w *Writer) WriteStatusLine(statusCode StatusCode) error {
-	switch statusCode {
-	case StatusOK:
-		w.writer.Write([]byte("HTTP/1.1 200 OK\r\n"))
-	case StatusBadRequest:
-		w.writer.Write([]byte("HTTP/1.1 400 Bad Request\r\n"))
-	case StatusError:
-		w.writer.Write([]byte("HTTP/1.1 500 Internal Server Error\r\n"))
-	default:
-		fmt.Fprintf(w.writer, "HTTP/1.1 %d\r\n", statusCode)
+	if w.state != stateStatusLine {
+		return ErrInvalidWriteOrder
 	}
+	w.state = stateHeaders
+	w.status = statusCode
+	return w.writeStatusLine(statusCode)
+}
 
-	return nil
+func (w *Writer) writeStatusLine(statusCode StatusCode) error {
+	if w.stream != nil {
+		return nil
+	}
+	var err error
+	if text, ok := statusText[statusCode]; ok {
+		_, err = fmt.Fprintf(w.writer, "HTTP/1.1 %d %s\r\n", statusCode, text)
+	} else {
+		_, err = fmt.Fprintf(w.writer, "HTTP/1.1 %d \r\n", statusCode)
+	}
+	return err
 }
 
 func (w *Writer) WriteHeaders(hdrs headers.Headers) error {
+	if w.state != stateHeaders {
+		return ErrInvalidWriteOrder
+	}
+	if err := w.declareTrailers(hdrs); err != nil {
+		return err
+	}
+	w.state = stateBody
+	return w.writeHeaderFields(hdrs)
+}
+
+// writeHeaderFields writes the header section, adding the Date, Server and
+// Connection headers the handler did not set itself.
+func (w *Writer) writeHeaderFields(hdrs headers.Headers) error {
+	for _, fn := range w.headerHooks {
+		fn(w.status, hdrs)
+	}
+	w.observeFraming(hdrs)
+	if w.stream != nil {
+		return w.writeStreamHeaders(hdrs)
+	}
+	if _, ok := hdrs.Get("connection"); !ok {
+		connection := ""
+		if w.closeAfter {
+			connection = "close"
+		} else if w.req != nil && w.req.RequestLine.HTTPVersion == "1.0" {
+			connection = "keep-alive"
+		}
+		if connection != "" {
+			if _, err := fmt.Fprintf(w.writer, "connection: %s\r\n", connection); err != nil {
+				return err
+			}
+		}
+	}
+	if _, ok := hdrs.Get("date"); !ok && w.SendDate {
+		if _, err := fmt.Fprintf(w.writer, "date: %s\r\n", httpDate()); err != nil {
+			return err
+		}
+	}
+	if _, ok := hdrs.Get("server"); !ok && w.ServerName != "" {
+		if _, err := fmt.Fprintf(w.writer, "server: %s\r\n", w.ServerName); err != nil {
+			return err
+		}
+	}
+	return w.writeFields(hdrs)
+}
+
+// observeFraming looks at the headers of the response to find out whether
+// the connection can be reused afterwards.
+func (w *Writer) observeFraming(hdrs headers.Headers) {
+	value, _ := hdrs.Get("connection")
+	if slices.Contains(headers.ListValues(value), "close") {
+		w.closeAfter = true
+	}
+	if !bodyAllowed(w.status) || w.head {
+		return
+	}
+
+	te, _ := hdrs.Get("transfer-encoding")
+	codings := headers.ListValues(te)
+	if len(codings) > 0 && codings[len(codings)-1] == "chunked" {
+		w.explicitChunked = w.stream == nil
+		return
+	}
+	if cl, ok := hdrs.Get("content-length"); ok {
+		if n, err := strconv.Atoi(cl); err == nil && n >= 0 {
+			w.declaredLen = n
+			return
+		}
+	}
+	w.closeAfter = true
+}
+
+func (w *Writer) writeFields(hdrs headers.Headers) error {
 	for key, value := range hdrs {
-		fmt.Fprintf(w.writer, "%s: %s\r\n", key, value)
+		if _, err := fmt.Fprintf(w.writer, "%s: %s\r\n", key, value); err != nil {
+			return err
+		}
 	}
-	fmt.Fprintf(w.writer, "\r\n")
-	return nil
+	_, err := w.writer.WriteString("\r\n")
+	return err
 }
 
 func (w *Writer) WriteBody(body []byte) (int, error) {
-	n, err := w.writer.Write(body)
+	if w.state != stateBody {
+		return 0, ErrInvalidWriteOrder
+	}
+	w.observeWrite(body)
+	if w.discard {
+		return len(body), nil
+	}
+	n, err := w.writeData(body)
+	w.bodyWritten += n
 	return n, err
 }
 
-func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
-	fmt.Fprintf(w.writer, "%x\r\n", len(p))
-	fmt.Fprintf(w.writer, "%s\r\n", p)
-	return len(p), nil
+func (w *Writer) WriteChunkedBody(p []byte, exts ...ChunkExtension) (int, error) {
+	if w.state != stateBody {
+		return 0, ErrInvalidWriteOrder
+	}
+	ext, err := formatChunkExtensions(exts)
+	if err != nil {
+		return 0, err
+	}
+	w.observeWrite(p)
+	return w.writeChunk(p, ext)
+}
+
+func (w *Writer) writeChunk(p []byte, ext string) (int, error) {
+	if w.discard {
+		return len(p), nil
+	}
+	if w.stream != nil {
+		return w.writeData(p)
+	}
+	if _, err := fmt.Fprintf(w.writer, "%x%s\r\n", len(p), ext); err != nil {
+		return 0, err
+	}
+	n, err := w.writer.Write(p)
+	if err != nil {
+		return n, err
+	}
+	_, err = w.writer.WriteString("\r\n")
+	return n, err
 }
 
 func (w *Writer) WriteChunkedBodyDone(trailer bool) (int, error) {
+	if w.state != stateBody {
+		return 0, ErrInvalidWriteOrder
+	}
 	endChunk := []byte("0\r\n\r\n")
 	if trailer {
 		endChunk = []byte("0\r\n")
 	}
-	w.writer.Write(endChunk)
-	return len(endChunk), nil
+	w.lastChunk = true
+	w.trailersDone = !trailer
+	if w.discard || w.stream != nil {
+		return len(endChunk), nil
+	}
+	return w.writer.Write(endChunk)
 }
 
+// WriteTrailers ends the trailer section started by WriteChunkedBodyDone(true).
+// Every field must have been announced in the Trailer header beforehand.
 func (w *Writer) WriteTrailers(h headers.Headers) error {
-	for key, value := range h {
-		fmt.Fprintf(w.writer, "%s: %s\r\n", key, value)
+	if w.state != stateBody {
+		return ErrInvalidWriteOrder
+	}
+	if err := w.checkTrailers(h); err != nil {
+		return err
+	}
+	if w.discard {
+		return nil
+	}
+	if w.DropTrailersWithoutTE && !w.clientAcceptsTrailers() {
+		h = headers.NewHeaders()
 	}
-	fmt.Fprintf(w.writer, "\r\n")
+	if w.stream != nil {
+		w.streamEnded = true
+		return w.stream.WriteTrailers(h)
+	}
+	if err := w.writeFields(h); err != nil {
+		return err
+	}
+	w.trailersDone = true
 	return nil
 }
+
+// Write implements io.Writer for the response body. The first writes are
+// buffered, so a small body can still be sent with a Content-Length header.
+// If the headers were already written explicitly, p is written as is.
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.state != stateDone && w.state != stateHeaders {
+		w.observeWrite(p)
+	}
+	switch w.state {
+	case stateStatusLine:
+		w.state = stateBuffering
+		fallthrough
+	case stateBuffering:
+		// A HEAD response only counts the body, but switches to streaming
+		// at the same size as the GET would.
+		if len(w.body)+w.headLen+len(p) <= maxBufferedBody {
+			if w.head {
+				w.headLen += len(p)
+			} else {
+				w.body = append(w.body, p...)
+			}
+			return len(p), nil
+		}
+		if err := w.startStreaming(); err != nil {
+			return 0, err
+		}
+		return w.writeFramed(p)
+	case stateBody:
+		return w.writeFramed(p)
+	case stateDone:
+		if w.hijacked {
+			return 0, ErrHijacked
+		}
+		return 0, ErrResponseFinished
+	default:
+		return 0, ErrInvalidWriteOrder
+	}
+}
+
+func (w *Writer) writeFramed(p []byte) (int, error) {
+	if w.discard || len(p) == 0 {
+		return len(p), nil
+	}
+	if w.chunked {
+		return w.writeChunk(p, "")
+	}
+	n, err := w.writeData(p)
+	w.bodyWritten += n
+	return n, err
+}
+
+// startStreaming sends the headers of a response whose length is not known
+// yet, followed by whatever part of the body was buffered so far.
+func (w *Writer) startStreaming() error {
+	h := w.header
+	_, declared := h.Get("content-length")
+	switch {
+	case !bodyAllowed(w.status):
+		w.discard = true
+	case declared, w.stream != nil:
+	case w.req != nil && w.req.RequestLine.HTTPVersion == "1.0":
+		h.Del("transfer-encoding")
+		h.Replace("Connection", "close")
+	default:
+		h.Replace("Transfer-Encoding", "chunked")
+		w.chunked = true
+	}
+
+	if err := w.writeHeader(h); err != nil {
+		return err
+	}
+	body := w.body
+	w.body = nil
+	_, err := w.writeFramed(body)
+	return err
+}
+
+func (w *Writer) writeHeader(h headers.Headers) error {
+	if err := w.declareTrailers(h); err != nil {
+		return err
+	}
+	w.state = stateBody
+	if err := w.writeStatusLine(w.status); err != nil {
+		return err
+	}
+	return w.writeHeaderFields(h)
+}
+
+// Finish completes the response. A body that fit in the buffer is sent with
+// a Content-Length header, a chunked body gets its terminating chunk. The
+// server calls Finish once the handler returns, calling it again is a no-op.
+func (w *Writer) Finish() error {
+	switch w.state {
+	case stateStatusLine, stateBuffering:
+		h := w.header
+		if bodyAllowed(w.status) {
+			h.Replace("Content-Length", strconv.Itoa(len(w.body)+w.headLen))
+		} else {
+			w.discard = true
+		}
+		if err := w.writeHeader(h); err != nil {
+			return err
+		}
+		body := w.body
+		w.body = nil
+		if _, err := w.writeFramed(body); err != nil {
+			return err
+		}
+	case stateBody:
+		if w.chunked {
+			if _, err := w.WriteChunkedBodyDone(false); err != nil {
+				return err
+			}
+		}
+		if err := w.endExplicitChunked(); err != nil {
+			return err
+		}
+	case stateHeaders:
+		// Only the status line was written, the response ends without a
+		// body. An interim response has no final one and closes instead.
+		h := headers.NewHeaders()
+		if w.status < 200 {
+			w.closeAfter = true
+		} else if w.stream == nil && bodyAllowed(w.status) {
+			h.Set("Content-Length", "0")
+		}
+		w.state = stateBody
+		if err := w.writeHeaderFields(h); err != nil {
+			return err
+		}
+	}
+	if w.declaredLen >= 0 && !w.discard && w.bodyWritten != w.declaredLen {
+		w.closeAfter = true
+	}
+	w.state = stateDone
+	if w.stream != nil {
+		return w.endStream()
+	}
+	return w.writer.Flush()
+}
+
+// endExplicitChunked completes a chunked body the handler wrote itself. A
+// trailer section that was started is ended empty, a body without its last
+// chunk cannot be completed and closes the connection instead.
+func (w *Writer) endExplicitChunked() error {
+	if !w.explicitChunked || w.trailersDone {
+		return nil
+	}
+	if !w.lastChunk {
+		w.closeAfter = true
+		return nil
+	}
+	w.trailersDone = true
+	if w.discard {
+		return nil
+	}
+	_, err := w.writer.WriteString("\r\n")
+	return err
+}
+
+// Flush sends everything written so far to the underlying writer, streaming
+// handlers call it whenever the client should see the data immediately. A
+// body that is still being buffered is sent as a stream from then on.
+func (w *Writer) Flush() error {
+	if w.state == stateBuffering {
+		if err := w.startStreaming(); err != nil {
+			return err
+		}
+	}
+	if w.stream != nil {
+		return w.stream.Flush()
+	}
+	return w.writer.Flush()
+}
+
+func bodyAllowed(statusCode StatusCode) bool {
+	return statusCode >= 200 && statusCode != StatusNoContent && statusCode != StatusNotModified
+}
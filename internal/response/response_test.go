@@ -0,0 +1,505 @@
+package response
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ramonvermeulen/httpfromtcp/internal/headers"
+	"github.com/ramonvermeulen/httpfromtcp/internal/request"
+)
+
+func TestWriter_Flush(t *testing.T) {
+	// Test: nothing reaches the underlying writer before Flush
+	out := &bytes.Buffer{}
+	w := NewWriter(out)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	h := headers.NewHeaders()
+	h.Set("Content-Length", "5")
+	require.NoError(t, w.WriteHeaders(h))
+	n, err := w.WriteBody([]byte("hello"))
+	require.NoError(t, err)
+	assert.Equal(t, 5, n)
+	assert.Equal(t, 0, out.Len())
+
+	require.NoError(t, w.Flush())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\ncontent-length: 5\r\n\r\nhello", out.String())
+
+	// Test: chunks are framed and only written on Flush
+	out = &bytes.Buffer{}
+	w = NewWriter(out)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	h = headers.NewHeaders()
+	h.Set("Transfer-Encoding", "chunked")
+	require.NoError(t, w.WriteHeaders(h))
+	n, err = w.WriteChunkedBody([]byte("hello world"))
+	require.NoError(t, err)
+	assert.Equal(t, 11, n)
+	_, err = w.WriteChunkedBodyDone(false)
+	require.NoError(t, err)
+	assert.Equal(t, 0, out.Len())
+
+	require.NoError(t, w.Flush())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\ntransfer-encoding: chunked\r\n\r\nb\r\nhello world\r\n0\r\n\r\n", out.String())
+}
+
+func TestWriter_Write(t *testing.T) {
+	// Test: small body gets a Content-Length
+	out := &bytes.Buffer{}
+	w := NewWriter(out)
+	n, err := w.Write([]byte("hello"))
+	require.NoError(t, err)
+	assert.Equal(t, 5, n)
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\ncontent-length: 5\r\n\r\nhello", out.String())
+
+	// Test: no body at all still gets a Content-Length
+	out = &bytes.Buffer{}
+	w = NewWriter(out)
+	w.SetStatus(StatusBadRequest)
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 400 Bad Request\r\ncontent-length: 0\r\n\r\n", out.String())
+
+	// Test: 204 never gets a body or Content-Length
+	out = &bytes.Buffer{}
+	w = NewWriter(out)
+	w.SetStatus(StatusNoContent)
+	_, err = w.Write([]byte("ignored"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 204 No Content\r\n\r\n", out.String())
+
+	// Test: body larger than the buffer switches to chunked
+	out = &bytes.Buffer{}
+	w = NewWriter(out)
+	big := bytes.Repeat([]byte("a"), maxBufferedBody+1)
+	n, err = w.Write(big)
+	require.NoError(t, err)
+	assert.Equal(t, len(big), n)
+	require.NoError(t, w.Finish())
+	assert.Equal(t, fmt.Sprintf("HTTP/1.1 200 OK\r\ntransfer-encoding: chunked\r\n\r\n%x\r\n%s\r\n0\r\n\r\n", len(big), big), out.String())
+
+	// Test: Flush starts streaming right away
+	out = &bytes.Buffer{}
+	w = NewWriter(out)
+	_, err = w.Write([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, w.Flush())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\ntransfer-encoding: chunked\r\n\r\n5\r\nhello\r\n", out.String())
+	_, err = w.Write([]byte(" world"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\ntransfer-encoding: chunked\r\n\r\n5\r\nhello\r\n6\r\n world\r\n0\r\n\r\n", out.String())
+
+	// Test: HTTP/1.0 clients get a close-delimited body
+	out = &bytes.Buffer{}
+	w = NewWriter(out)
+	w.SetRequest(&request.Request{RequestLine: request.RequestLine{HTTPVersion: "1.0"}})
+	_, err = w.Write(big)
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.Equal(t, fmt.Sprintf("HTTP/1.1 200 OK\r\nconnection: close\r\n\r\n%s", big), out.String())
+
+	// Test: explicit writes after Write are out of order
+	out = &bytes.Buffer{}
+	w = NewWriter(out)
+	_, err = w.Write([]byte("hello"))
+	require.NoError(t, err)
+	require.ErrorIs(t, w.WriteStatusLine(StatusOK), ErrInvalidWriteOrder)
+
+	// Test: writing after Finish fails
+	require.NoError(t, w.Finish())
+	_, err = w.Write([]byte("hello"))
+	require.ErrorIs(t, err, ErrResponseFinished)
+}
+
+func TestWriter_WriteTrailers(t *testing.T) {
+	chunkedHeaders := func(trailer string) headers.Headers {
+		h := headers.NewHeaders()
+		h.Set("Transfer-Encoding", "chunked")
+		h.Set("Trailer", trailer)
+		return h
+	}
+
+	// Test: declared trailers are written, regardless of casing
+	out := &bytes.Buffer{}
+	w := NewWriter(out)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(chunkedHeaders("X-COntent-SHA256")))
+	require.NoError(t, w.Flush())
+	out.Reset()
+	_, err := w.WriteChunkedBodyDone(true)
+	require.NoError(t, err)
+	trailer := headers.NewHeaders()
+	trailer.Set("X-Content-SHA256", "abc")
+	require.NoError(t, w.WriteTrailers(trailer))
+	require.NoError(t, w.Flush())
+	assert.Equal(t, "0\r\nx-content-sha256: abc\r\n\r\n", out.String())
+
+	// Test: undeclared trailer
+	w = NewWriter(&bytes.Buffer{})
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(chunkedHeaders("X-Content-Length")))
+	trailer = headers.NewHeaders()
+	trailer.Set("X-Content-SHA256", "abc")
+	require.ErrorIs(t, w.WriteTrailers(trailer), ErrUndeclaredTrailer)
+
+	// Test: forbidden trailer can't be declared
+	w = NewWriter(&bytes.Buffer{})
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.ErrorIs(t, w.WriteHeaders(chunkedHeaders("X-Content-Length, Content-Length")), ErrForbiddenTrailer)
+
+	// Test: forbidden trailer can't be sent
+	w = NewWriter(&bytes.Buffer{})
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(chunkedHeaders("X-Content-Length")))
+	trailer = headers.NewHeaders()
+	trailer.Set("Content-Length", "5")
+	require.ErrorIs(t, w.WriteTrailers(trailer), ErrForbiddenTrailer)
+
+	// Test: trailers dropped without TE: trailers
+	out = &bytes.Buffer{}
+	w = NewWriter(out)
+	w.DropTrailersWithoutTE = true
+	w.SetRequest(&request.Request{Headers: headers.NewHeaders()})
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(chunkedHeaders("X-Content-Length")))
+	require.NoError(t, w.Flush())
+	out.Reset()
+	_, err = w.WriteChunkedBodyDone(true)
+	require.NoError(t, err)
+	trailer = headers.NewHeaders()
+	trailer.Set("X-Content-Length", "5")
+	require.NoError(t, w.WriteTrailers(trailer))
+	require.NoError(t, w.Flush())
+	assert.Equal(t, "0\r\n\r\n", out.String())
+
+	// Test: trailers kept with TE: trailers
+	out = &bytes.Buffer{}
+	w = NewWriter(out)
+	w.DropTrailersWithoutTE = true
+	req := &request.Request{Headers: headers.NewHeaders()}
+	req.Headers.Set("TE", "gzip, Trailers")
+	w.SetRequest(req)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(chunkedHeaders("X-Content-Length")))
+	require.NoError(t, w.Flush())
+	out.Reset()
+	_, err = w.WriteChunkedBodyDone(true)
+	require.NoError(t, err)
+	require.NoError(t, w.WriteTrailers(trailer))
+	require.NoError(t, w.Flush())
+	assert.Equal(t, "0\r\nx-content-length: 5\r\n\r\n", out.String())
+}
+
+func TestWriter_WriteChunkedBody(t *testing.T) {
+	// Test: chunk extensions, token and quoted values
+	out := &bytes.Buffer{}
+	w := NewWriter(out)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(headers.Headers{"transfer-encoding": "chunked"}))
+	require.NoError(t, w.Flush())
+	out.Reset()
+	_, err := w.WriteChunkedBody([]byte("hello"),
+		ChunkExtension{Name: "last"},
+		ChunkExtension{Name: "sig", Value: "abc123"},
+		ChunkExtension{Name: "note", Value: `say "hi"`},
+	)
+	require.NoError(t, err)
+	require.NoError(t, w.Flush())
+	assert.Equal(t, "5;last;sig=abc123;note=\"say \\\"hi\\\"\"\r\nhello\r\n", out.String())
+
+	// Test: invalid extension name
+	_, err = w.WriteChunkedBody([]byte("hello"), ChunkExtension{Name: "bad name"})
+	require.ErrorIs(t, err, ErrInvalidChunkExtension)
+}
+
+func TestWriter_DefaultHeaders(t *testing.T) {
+	// Test: Date and Server are added
+	out := &bytes.Buffer{}
+	w := NewWriter(out)
+	w.SendDate = true
+	w.ServerName = "httpfromtcp"
+	require.NoError(t, w.Finish())
+	resp := out.String()
+	assert.Regexp(t, `\r\ndate: [A-Z][a-z]{2}, \d{2} [A-Z][a-z]{2} \d{4} \d{2}:\d{2}:\d{2} GMT\r\n`, resp)
+	assert.Contains(t, resp, "\r\nserver: httpfromtcp\r\n")
+
+	// Test: headers set by the handler win
+	out = &bytes.Buffer{}
+	w = NewWriter(out)
+	w.SendDate = true
+	w.ServerName = "httpfromtcp"
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(headers.Headers{"date": "Sun, 06 Nov 1994 08:49:37 GMT", "server": "custom"}))
+	require.NoError(t, w.Flush())
+	resp = out.String()
+	assert.Equal(t, 1, strings.Count(resp, "date: "))
+	assert.Contains(t, resp, "\r\ndate: Sun, 06 Nov 1994 08:49:37 GMT\r\n")
+	assert.Contains(t, resp, "\r\nserver: custom\r\n")
+
+	// Test: nothing added when turned off
+	out = &bytes.Buffer{}
+	w = NewWriter(out)
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\ncontent-length: 0\r\n\r\n", out.String())
+}
+
+func TestWriter_Head(t *testing.T) {
+	head := &request.Request{RequestLine: request.RequestLine{Method: "HEAD", HTTPVersion: "1.1"}}
+
+	// Test: body is counted for Content-Length but not sent
+	out := &bytes.Buffer{}
+	w := NewWriter(out)
+	w.SetRequest(head)
+	n, err := w.Write([]byte("hello"))
+	require.NoError(t, err)
+	assert.Equal(t, 5, n)
+	_, err = w.Write([]byte(" world"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\ncontent-length: 11\r\n\r\n", out.String())
+
+	// Test: a body the GET would send chunked gets the same framing
+	for _, method := range []string{"GET", "HEAD"} {
+		out = &bytes.Buffer{}
+		w = NewWriter(out)
+		w.SetRequest(&request.Request{RequestLine: request.RequestLine{Method: method, HTTPVersion: "1.1"}})
+		big := bytes.Repeat([]byte("a"), maxBufferedBody+1)
+		n, err = w.Write(big)
+		require.NoError(t, err)
+		assert.Equal(t, len(big), n)
+		require.NoError(t, w.Finish())
+		raw := out.String()
+		res, err := ResponseHeadFromReader(bufio.NewReader(out), "GET")
+		require.NoError(t, err)
+		te, _ := res.Headers.Get("transfer-encoding")
+		assert.Equal(t, "chunked", te, method)
+		_, ok := res.Headers.Get("content-length")
+		assert.False(t, ok, method)
+		if method == "HEAD" {
+			assert.True(t, strings.HasSuffix(raw, "\r\n\r\n"))
+			assert.NotContains(t, raw, "aaa")
+		}
+	}
+
+	// Test: explicit Content-Length is kept, body discarded
+	out = &bytes.Buffer{}
+	w = NewWriter(out)
+	w.SetRequest(head)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(headers.Headers{"content-length": "5"}))
+	n, err = w.WriteBody([]byte("hello"))
+	require.NoError(t, err)
+	assert.Equal(t, 5, n)
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\ncontent-length: 5\r\n\r\n", out.String())
+
+	// Test: chunks and trailers are discarded
+	out = &bytes.Buffer{}
+	w = NewWriter(out)
+	w.SetRequest(head)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(headers.Headers{"transfer-encoding": "chunked", "trailer": "x-sum"}))
+	_, err = w.WriteChunkedBody([]byte("hello"))
+	require.NoError(t, err)
+	_, err = w.WriteChunkedBodyDone(true)
+	require.NoError(t, err)
+	require.NoError(t, w.WriteTrailers(headers.Headers{"x-sum": "1"}))
+	require.NoError(t, w.Finish())
+	assert.True(t, strings.HasSuffix(out.String(), "\r\n\r\n"))
+	assert.NotContains(t, out.String(), "hello")
+	assert.NotContains(t, out.String(), "x-sum: 1")
+}
+
+func TestWriter_KeepAlive(t *testing.T) {
+	get11 := &request.Request{RequestLine: request.RequestLine{Method: "GET", HTTPVersion: "1.1"}, Headers: headers.NewHeaders()}
+
+	// Test: HTTP/1.1 response with Content-Length keeps the connection
+	out := &bytes.Buffer{}
+	w := NewWriter(out)
+	w.SetRequest(get11)
+	assert.False(t, w.KeepAlive())
+	w.Write([]byte("hello"))
+	require.NoError(t, w.Finish())
+	assert.True(t, w.KeepAlive())
+	assert.NotContains(t, out.String(), "connection")
+
+	// Test: client asks to close
+	req := &request.Request{RequestLine: get11.RequestLine, Headers: headers.Headers{"connection": "Close"}}
+	out = &bytes.Buffer{}
+	w = NewWriter(out)
+	w.SetRequest(req)
+	require.NoError(t, w.Finish())
+	assert.False(t, w.KeepAlive())
+	assert.Contains(t, out.String(), "connection: close\r\n")
+
+	// Test: HTTP/1.0 client has to ask for keep-alive
+	req = &request.Request{RequestLine: request.RequestLine{Method: "GET", HTTPVersion: "1.0"}, Headers: headers.NewHeaders()}
+	w = NewWriter(&bytes.Buffer{})
+	w.SetRequest(req)
+	require.NoError(t, w.Finish())
+	assert.False(t, w.KeepAlive())
+	req.Headers.Set("Connection", "keep-alive")
+	out = &bytes.Buffer{}
+	w = NewWriter(out)
+	w.SetRequest(req)
+	require.NoError(t, w.Finish())
+	assert.True(t, w.KeepAlive())
+	assert.Contains(t, out.String(), "connection: keep-alive\r\n")
+
+	// Test: CloseAfterResponse
+	out = &bytes.Buffer{}
+	w = NewWriter(out)
+	w.SetRequest(get11)
+	w.CloseAfterResponse()
+	require.NoError(t, w.Finish())
+	assert.False(t, w.KeepAlive())
+	assert.Contains(t, out.String(), "connection: close\r\n")
+
+	// Test: handler sends Connection: close itself
+	w = NewWriter(&bytes.Buffer{})
+	w.SetRequest(get11)
+	w.Header().Set("Connection", "close")
+	require.NoError(t, w.Finish())
+	assert.False(t, w.KeepAlive())
+
+	// Test: explicit headers without framing are close-delimited
+	out = &bytes.Buffer{}
+	w = NewWriter(out)
+	w.SetRequest(get11)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(headers.NewHeaders()))
+	w.WriteBody([]byte("hello"))
+	require.NoError(t, w.Finish())
+	assert.False(t, w.KeepAlive())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nconnection: close\r\n\r\nhello", out.String())
+
+	// Test: body shorter than the declared Content-Length
+	w = NewWriter(&bytes.Buffer{})
+	w.SetRequest(get11)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(headers.Headers{"content-length": "10"}))
+	w.WriteBody([]byte("hello"))
+	require.NoError(t, w.Finish())
+	assert.False(t, w.KeepAlive())
+
+	// Test: chunked response keeps the connection
+	w = NewWriter(&bytes.Buffer{})
+	w.SetRequest(get11)
+	w.Write(bytes.Repeat([]byte("a"), maxBufferedBody+1))
+	require.NoError(t, w.Finish())
+	assert.True(t, w.KeepAlive())
+
+	// Test: a trailer section left open by a failed WriteTrailers is ended
+	out = &bytes.Buffer{}
+	w = NewWriter(out)
+	w.SetRequest(get11)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(headers.Headers{"transfer-encoding": "chunked"}))
+	w.WriteChunkedBody([]byte("hello"))
+	w.WriteChunkedBodyDone(true)
+	assert.Error(t, w.WriteTrailers(headers.Headers{"x-undeclared": "1"}))
+	require.NoError(t, w.Finish())
+	assert.True(t, w.KeepAlive())
+	assert.True(t, strings.HasSuffix(out.String(), "5\r\nhello\r\n0\r\n\r\n"))
+
+	// Test: an explicit chunked body without its last chunk closes the
+	// connection
+	w = NewWriter(&bytes.Buffer{})
+	w.SetRequest(get11)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(headers.Headers{"transfer-encoding": "chunked"}))
+	w.WriteChunkedBody([]byte("hello"))
+	require.NoError(t, w.Finish())
+	assert.False(t, w.KeepAlive())
+
+	// Test: a response with only a status line gets an empty header section
+	for _, tc := range []struct {
+		status    StatusCode
+		want      string
+		keepAlive bool
+	}{
+		{StatusOK, "HTTP/1.1 200 OK\r\ncontent-length: 0\r\n\r\n", true},
+		{StatusNoContent, "HTTP/1.1 204 No Content\r\n\r\n", true},
+		{StatusSwitchingProtocols, "HTTP/1.1 101 Switching Protocols\r\nconnection: close\r\n\r\n", false},
+	} {
+		out = &bytes.Buffer{}
+		w = NewWriter(out)
+		w.SetRequest(get11)
+		require.NoError(t, w.WriteStatusLine(tc.status))
+		require.NoError(t, w.Finish())
+		assert.Equal(t, tc.want, out.String())
+		assert.Equal(t, tc.keepAlive, w.KeepAlive())
+	}
+}
+
+func TestWriter_Hooks(t *testing.T) {
+	// Test: hooks observe status, headers and body of an automatic response
+	out := &bytes.Buffer{}
+	w := NewWriter(out)
+	var status StatusCode
+	written := 0
+	w.OnHeaders(func(s StatusCode, h headers.Headers) {
+		status = s
+		h.Set("X-Observed", "yes")
+	})
+	w.OnWrite(func(p []byte) { written += len(p) })
+	w.SetStatus(StatusNotFound)
+	w.Write(bytes.Repeat([]byte("a"), maxBufferedBody+1))
+	w.Write([]byte("b"))
+	require.NoError(t, w.Finish())
+	assert.Equal(t, StatusNotFound, status)
+	assert.Equal(t, StatusNotFound, w.Status())
+	assert.Equal(t, maxBufferedBody+2, written)
+	assert.Contains(t, out.String(), "x-observed: yes\r\n")
+
+	// Test: hooks observe an explicit chunked response once per write
+	w = NewWriter(&bytes.Buffer{})
+	written = 0
+	w.OnWrite(func(p []byte) { written += len(p) })
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(headers.Headers{"transfer-encoding": "chunked"}))
+	w.WriteChunkedBody([]byte("hello"))
+	w.Write([]byte("world"))
+	require.NoError(t, w.Finish())
+	assert.Equal(t, 10, written)
+}
+
+func TestWriter_Hijack(t *testing.T) {
+	// Test: a writer without a hijacker cannot be hijacked
+	w := NewWriter(&bytes.Buffer{})
+	_, _, err := w.Hijack()
+	assert.ErrorIs(t, err, ErrNotHijackable)
+
+	// Test: explicit output is flushed before the connection is handed over
+	out := &bytes.Buffer{}
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	br := bufio.NewReader(server)
+	w = NewWriter(out)
+	w.SetHijacker(func() (net.Conn, *bufio.Reader, error) { return server, br, nil })
+	require.NoError(t, w.WriteStatusLine(StatusSwitchingProtocols))
+	require.NoError(t, w.WriteHeaders(headers.Headers{"upgrade": "tunnel"}))
+	conn, r, err := w.Hijack()
+	require.NoError(t, err)
+	assert.Equal(t, server, conn)
+	assert.Equal(t, br, r)
+	assert.Equal(t, "HTTP/1.1 101 Switching Protocols\r\nupgrade: tunnel\r\n\r\n", out.String())
+	assert.True(t, w.Hijacked())
+
+	// Test: the writer refuses writes afterwards
+	_, err = w.Write([]byte("late"))
+	assert.ErrorIs(t, err, ErrHijacked)
+	_, _, err = w.Hijack()
+	assert.ErrorIs(t, err, ErrHijacked)
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 101 Switching Protocols\r\nupgrade: tunnel\r\n\r\n", out.String())
+}
@@ -0,0 +1,51 @@
+package response
+
+type StatusCode int
+
+const (
+	StatusSwitchingProtocols StatusCode = 101
+	StatusOK                 StatusCode = 200
+	StatusNoContent          StatusCode = 204
+	StatusMovedPermanently   StatusCode = 301
+	StatusFound              StatusCode = 302
+	StatusSeeOther           StatusCode = 303
+	StatusNotModified        StatusCode = 304
+	StatusTemporaryRedirect  StatusCode = 307
+	StatusPermanentRedirect  StatusCode = 308
+	StatusBadRequest         StatusCode = 400
+	StatusForbidden          StatusCode = 403
+	StatusNotFound           StatusCode = 404
+	StatusMethodNotAllowed   StatusCode = 405
+	StatusRequestTimeout     StatusCode = 408
+	StatusContentTooLarge    StatusCode = 413
+	StatusUpgradeRequired    StatusCode = 426
+	StatusError              StatusCode = 500
+	StatusNotImplemented     StatusCode = 501
+)
+
+var statusText = map[StatusCode]string{
+	StatusSwitchingProtocols: "Switching Protocols",
+	StatusOK:                 "OK",
+	StatusNoContent:          "No Content",
+	StatusMovedPermanently:   "Moved Permanently",
+	StatusFound:              "Found",
+	StatusSeeOther:           "See Other",
+	StatusNotModified:        "Not Modified",
+	StatusTemporaryRedirect:  "Temporary Redirect",
+	StatusPermanentRedirect:  "Permanent Redirect",
+	StatusBadRequest:         "Bad Request",
+	StatusForbidden:          "Forbidden",
+	StatusNotFound:           "Not Found",
+	StatusMethodNotAllowed:   "Method Not Allowed",
+	StatusRequestTimeout:     "Request Timeout",
+	StatusContentTooLarge:    "Content Too Large",
+	StatusUpgradeRequired:    "Upgrade Required",
+	StatusError:              "Internal Server Error",
+	StatusNotImplemented:     "Not Implemented",
+}
+
+// StatusText returns the reason phrase for statusCode, or an empty string if
+// the code is unknown.
+func StatusText(statusCode StatusCode) string {
+	return statusText[statusCode]
+}
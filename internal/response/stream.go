@@ -0,0 +1,66 @@
+package response
+
+import (
+	"github.com/ramonvermeulen/httpfromtcp/internal/headers"
+)
+
+// Stream carries a response over a protocol with its own framing, such as an
+// HTTP/2 stream. The writer hands it the status and headers, the body
+// without any chunked framing and the trailers.
+type Stream interface {
+	WriteHeaders(status StatusCode, h headers.Headers) error
+	// WriteData sends a piece of the body, endStream marks the last one.
+	WriteData(p []byte, endStream bool) error
+	// WriteTrailers sends the trailer fields, they end the stream.
+	WriteTrailers(h headers.Headers) error
+	Flush() error
+}
+
+// NewStreamWriter returns a writer for a response sent over s. Handlers use
+// it exactly like a writer returned by NewWriter, chunked encoding and
+// connection management are left to the stream.
+func NewStreamWriter(s Stream) *Writer {
+	w := NewWriter(nil)
+	w.writer = nil
+	w.stream = s
+	return w
+}
+
+func (w *Writer) writeStreamHeaders(hdrs headers.Headers) error {
+	h := headers.NewHeaders()
+	for key, value := range hdrs {
+		h[key] = value
+	}
+	for _, key := range headers.ConnectionHeaders {
+		h.Del(key)
+	}
+	if _, ok := h.Get("date"); !ok && w.SendDate {
+		h.Set("Date", httpDate())
+	}
+	if _, ok := h.Get("server"); !ok && w.ServerName != "" {
+		h.Set("Server", w.ServerName)
+	}
+	return w.stream.WriteHeaders(w.status, h)
+}
+
+// writeData writes a piece of the body as is.
+func (w *Writer) writeData(p []byte) (int, error) {
+	if w.stream != nil {
+		if err := w.stream.WriteData(p, false); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+	return w.writer.Write(p)
+}
+
+// endStream ends a stream that was not ended by trailers.
+func (w *Writer) endStream() error {
+	if !w.streamEnded {
+		w.streamEnded = true
+		if err := w.stream.WriteData(nil, true); err != nil {
+			return err
+		}
+	}
+	return w.stream.Flush()
+}
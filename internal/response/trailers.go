@@ -0,0 +1,116 @@
+package response
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/ramonvermeulen/httpfromtcp/internal/headers"
+)
+
+var (
+	ErrUndeclaredTrailer     = fmt.Errorf("trailer field not declared in Trailer header")
+	ErrForbiddenTrailer      = fmt.Errorf("field not allowed in trailers")
+	ErrInvalidChunkExtension = fmt.Errorf("invalid chunk extension")
+)
+
+// forbiddenTrailers are the fields RFC 9110 section 6.5.1 rules out for
+// trailers: framing, routing, authentication, request modifiers and the
+// response control data a recipient needs before the content.
+var forbiddenTrailers = map[string]bool{
+	"transfer-encoding":   true,
+	"content-length":      true,
+	"trailer":             true,
+	"host":                true,
+	"te":                  true,
+	"expect":              true,
+	"max-forwards":        true,
+	"range":               true,
+	"cache-control":       true,
+	"pragma":              true,
+	"authorization":       true,
+	"proxy-authorization": true,
+	"proxy-authenticate":  true,
+	"www-authenticate":    true,
+	"cookie":              true,
+	"set-cookie":          true,
+	"content-encoding":    true,
+	"content-type":        true,
+	"content-range":       true,
+	"age":                 true,
+	"date":                true,
+	"expires":             true,
+	"location":            true,
+	"retry-after":         true,
+	"vary":                true,
+}
+
+// ChunkExtension is a name=value pair sent along with the size of a chunk.
+// An empty Value sends the name on its own.
+type ChunkExtension struct {
+	Name  string
+	Value string
+}
+
+func formatChunkExtensions(exts []ChunkExtension) (string, error) {
+	var sb strings.Builder
+	for _, ext := range exts {
+		if !headers.IsToken(ext.Name) {
+			return "", fmt.Errorf("%w: name %q", ErrInvalidChunkExtension, ext.Name)
+		}
+		sb.WriteString(";")
+		sb.WriteString(ext.Name)
+		if ext.Value == "" {
+			continue
+		}
+		sb.WriteString("=")
+		if headers.IsToken(ext.Value) {
+			sb.WriteString(ext.Value)
+			continue
+		}
+		if strings.ContainsAny(ext.Value, "\r\n") {
+			return "", fmt.Errorf("%w: value %q", ErrInvalidChunkExtension, ext.Value)
+		}
+		sb.WriteString(`"`)
+		sb.WriteString(strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(ext.Value))
+		sb.WriteString(`"`)
+	}
+	return sb.String(), nil
+}
+
+// declareTrailers records the fields announced in the Trailer header of h.
+func (w *Writer) declareTrailers(h headers.Headers) error {
+	value, ok := h.Get("trailer")
+	if !ok {
+		return nil
+	}
+	for _, name := range headers.ListValues(value) {
+		if forbiddenTrailers[name] {
+			return fmt.Errorf("%w: %s", ErrForbiddenTrailer, name)
+		}
+		w.trailers = append(w.trailers, name)
+	}
+	return nil
+}
+
+func (w *Writer) checkTrailers(h headers.Headers) error {
+	for name := range h {
+		name = strings.ToLower(name)
+		if forbiddenTrailers[name] {
+			return fmt.Errorf("%w: %s", ErrForbiddenTrailer, name)
+		}
+		if !slices.Contains(w.trailers, name) {
+			return fmt.Errorf("%w: %s", ErrUndeclaredTrailer, name)
+		}
+	}
+	return nil
+}
+
+// clientAcceptsTrailers reports whether the request announced "TE: trailers".
+func (w *Writer) clientAcceptsTrailers() bool {
+	if w.req == nil {
+		return false
+	}
+	te, _ := w.req.Headers.Get("te")
+	return slices.Contains(headers.ListValues(te), "trailers")
+}
@@ -0,0 +1,179 @@
+package websocket
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// deflateExtension is the name of the compression extension of RFC 7692.
+const deflateExtension = "permessage-deflate"
+
+// rsv1 marks the first frame of a compressed message.
+const rsv1 = 0x40
+
+// maxWindowSize is the size of the sliding window of compress/flate, the
+// largest one RFC 7692 allows.
+const maxWindowSize = 1 << 15
+
+// deflateTail ends every compressed message: the empty block that was removed
+// by the sender and a final empty block, so the reader stops at the end of
+// the message.
+var deflateTail = []byte{0x00, 0x00, 0xff, 0xff, 0x01, 0x00, 0x00, 0xff, 0xff}
+
+// deflateParams are the negotiated parameters of permessage-deflate. The
+// server never takes its context over to the next message, the client does
+// unless it asked not to.
+type deflateParams struct {
+	clientNoContextTakeover bool
+	serverMaxWindowBits     bool
+}
+
+// String returns the Sec-WebSocket-Extensions value of the response.
+func (p deflateParams) String() string {
+	s := deflateExtension + "; server_no_context_takeover"
+	if p.clientNoContextTakeover {
+		s += "; client_no_context_takeover"
+	}
+	if p.serverMaxWindowBits {
+		s += "; server_max_window_bits=15"
+	}
+	return s
+}
+
+// acceptDeflate returns the parameters of the first permessage-deflate offer
+// in a Sec-WebSocket-Extensions header that can be accepted.
+func acceptDeflate(offers string) (deflateParams, bool) {
+	for _, offer := range strings.Split(offers, ",") {
+		name, params, _ := strings.Cut(offer, ";")
+		if !strings.EqualFold(strings.TrimSpace(name), deflateExtension) {
+			continue
+		}
+		if p, ok := parseDeflateParams(params); ok {
+			return p, true
+		}
+	}
+	return deflateParams{}, false
+}
+
+// parseDeflateParams parses the parameters of an offer, it fails for unknown,
+// repeated or invalid parameters and for a server window smaller than the
+// one of compress/flate.
+func parseDeflateParams(params string) (deflateParams, bool) {
+	var p deflateParams
+	seen := map[string]bool{}
+	for _, param := range strings.Split(params, ";") {
+		if strings.TrimSpace(param) == "" {
+			continue
+		}
+		name, value, hasValue := strings.Cut(param, "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		value = strings.Trim(strings.TrimSpace(value), `"`)
+		if seen[name] {
+			return p, false
+		}
+		seen[name] = true
+		switch name {
+		case "server_no_context_takeover":
+			if hasValue {
+				return p, false
+			}
+		case "client_no_context_takeover":
+			if hasValue {
+				return p, false
+			}
+			p.clientNoContextTakeover = true
+		case "server_max_window_bits":
+			if windowBits(value) != 15 {
+				return p, false
+			}
+			p.serverMaxWindowBits = true
+		case "client_max_window_bits":
+			// Any window of the client fits the one of the decompressor, so
+			// the server does not limit it.
+			if hasValue && windowBits(value) == 0 {
+				return p, false
+			}
+		default:
+			return p, false
+		}
+	}
+	return p, true
+}
+
+// windowBits parses a window size parameter, it returns 0 when the value is
+// invalid.
+func windowBits(value string) int {
+	bits, err := strconv.Atoi(value)
+	if err != nil || bits < 8 || bits > 15 || value[0] == '0' {
+		return 0
+	}
+	return bits
+}
+
+// flateWriters pools compressors by level, offset by the lowest level.
+var flateWriters [flate.BestCompression - flate.HuffmanOnly + 1]sync.Pool
+
+// validLevel reports whether level is a level of compress/flate.
+func validLevel(level int) bool {
+	return level >= flate.HuffmanOnly && level <= flate.BestCompression
+}
+
+func getFlateWriter(w io.Writer, level int) (*flate.Writer, error) {
+	if !validLevel(level) {
+		return nil, fmt.Errorf("websocket: invalid compression level %d", level)
+	}
+	if fw, ok := flateWriters[level-flate.HuffmanOnly].Get().(*flate.Writer); ok {
+		fw.Reset(w)
+		return fw, nil
+	}
+	return flate.NewWriter(w, level)
+}
+
+func putFlateWriter(fw *flate.Writer, level int) {
+	flateWriters[level-flate.HuffmanOnly].Put(fw)
+}
+
+// deflate compresses a message, without the empty block that ends the
+// output of a flush.
+func deflate(p []byte, level int) ([]byte, error) {
+	var buf bytes.Buffer
+	fw, err := getFlateWriter(&buf, level)
+	if err != nil {
+		return nil, err
+	}
+	fw.Write(p)
+	fw.Flush()
+	putFlateWriter(fw, level)
+	return bytes.TrimSuffix(buf.Bytes(), deflateTail[:4]), nil
+}
+
+// inflate decompresses a message of at most limit bytes. The client may
+// refer to earlier messages, the last window of them is kept in c.window.
+func (c *Conn) inflate(p []byte, limit int) ([]byte, error) {
+	src := io.MultiReader(bytes.NewReader(p), bytes.NewReader(deflateTail))
+	if c.inflater == nil {
+		c.inflater = flate.NewReaderDict(src, c.window)
+	} else {
+		c.inflater.(flate.Resetter).Reset(src, c.window)
+	}
+	msg, err := io.ReadAll(io.LimitReader(c.inflater, int64(limit)+1))
+	if err != nil {
+		return nil, &CloseError{CloseInvalidPayload, "invalid compressed data"}
+	}
+	if len(msg) > limit {
+		return nil, &CloseError{CloseMessageTooBig, "message too big"}
+	}
+	if c.deflate.clientNoContextTakeover {
+		return msg, nil
+	}
+	c.window = append(c.window, msg...)
+	if n := len(c.window) - maxWindowSize; n > 0 {
+		c.window = append(c.window[:0], c.window[n:]...)
+	}
+	return msg, nil
+}
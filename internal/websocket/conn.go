@@ -0,0 +1,342 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+)
+
+// closeTimeout is how long Close waits for the client to answer.
+const closeTimeout = 5 * time.Second
+
+// Conn is a WebSocket connection. Messages are read one at a time by a single
+// reader, writes are safe for concurrent use. Pings are answered while
+// reading.
+type Conn struct {
+	conn           net.Conn
+	br             *bufio.Reader
+	maxMessageSize int
+
+	readMu  sync.Mutex
+	readErr error
+
+	// deflate is set when permessage-deflate was negotiated, window holds
+	// the end of the received messages while the client takes its context
+	// over.
+	deflate     *deflateParams
+	level       int
+	compression atomic.Bool
+	inflater    io.ReadCloser
+	window      []byte
+
+	// msgMu keeps the fragments of a message together, writeMu guards
+	// single frames, control frames may go between fragments.
+	msgMu     sync.Mutex
+	writeMu   sync.Mutex
+	bw        *bufio.Writer
+	closeSent bool
+}
+
+// NewConn returns the server side of a WebSocket connection on conn, after
+// hs was answered. br reads from conn and may hold frames the client sent
+// right after its handshake.
+func NewConn(conn net.Conn, br *bufio.Reader, hs *Handshake) *Conn {
+	c := &Conn{
+		conn:           conn,
+		br:             br,
+		maxMessageSize: hs.opts.MaxMessageSize,
+		deflate:        hs.deflate,
+		level:          hs.opts.CompressionLevel,
+		bw:             bufio.NewWriter(conn),
+	}
+	if c.maxMessageSize == 0 {
+		c.maxMessageSize = DefaultMaxMessageSize
+	}
+	if c.level == 0 {
+		c.level = flate.BestSpeed
+	}
+	c.compression.Store(c.deflate != nil)
+	return c
+}
+
+// SetCompression turns compression of the messages sent from now on on or
+// off. It has no effect when permessage-deflate was not negotiated, received
+// messages are decompressed either way.
+func (c *Conn) SetCompression(enabled bool) {
+	c.compression.Store(enabled && c.deflate != nil)
+}
+
+// ReadMessage returns the next text or binary message. Once the connection
+// is closed it returns a *CloseError with the close code, after answering
+// the close frame of the client.
+func (c *Conn) ReadMessage() (MessageType, []byte, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	if c.readErr != nil {
+		return 0, nil, c.readErr
+	}
+	typ, msg, err := c.readMessage()
+	if err != nil {
+		c.readErr = err
+	}
+	return typ, msg, err
+}
+
+func (c *Conn) readMessage() (MessageType, []byte, error) {
+	var typ MessageType
+	var msg []byte
+	compressed := false
+	for {
+		f, err := readFrame(c.br, true, c.maxMessageSize-len(msg))
+		if err != nil {
+			return 0, nil, c.fail(err)
+		}
+		// RSV1 marks the first frame of a compressed message.
+		if f.rsv&^rsv1 != 0 || f.rsv != 0 && (c.deflate == nil || f.op != opText && f.op != opBinary) {
+			return 0, nil, c.fail(&CloseError{CloseProtocolError, "reserved bits set"})
+		}
+		switch f.op {
+		case opPing:
+			if err := c.writeControl(opPong, f.payload); err != nil && !errors.Is(err, ErrClosed) {
+				return 0, nil, err
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			return 0, nil, c.receiveClose(f.payload)
+		case opText, opBinary:
+			if typ != 0 {
+				return 0, nil, c.fail(&CloseError{CloseProtocolError, "message started within a fragmented message"})
+			}
+			typ = MessageType(f.op)
+			compressed = f.rsv != 0
+		case opContinuation:
+			if typ == 0 {
+				return 0, nil, c.fail(&CloseError{CloseProtocolError, "continuation frame without a message"})
+			}
+		default:
+			return 0, nil, c.fail(&CloseError{CloseProtocolError, "unknown opcode"})
+		}
+		msg = append(msg, f.payload...)
+		if f.fin {
+			if compressed {
+				if msg, err = c.inflate(msg, c.maxMessageSize); err != nil {
+					return 0, nil, c.fail(err)
+				}
+			}
+			if typ == TextMessage && !utf8.Valid(msg) {
+				return 0, nil, c.fail(&CloseError{CloseInvalidPayload, "text message is not valid UTF-8"})
+			}
+			return typ, msg, nil
+		}
+	}
+}
+
+// fail ends the connection after a read error. Violations of the protocol
+// are reported to the client with a close frame.
+func (c *Conn) fail(err error) error {
+	var closeErr *CloseError
+	if errors.As(err, &closeErr) {
+		c.writeClose(closeErr.Code, closeErr.Reason)
+		return err
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return &CloseError{CloseAbnormal, err.Error()}
+	}
+	return err
+}
+
+// receiveClose handles the close frame of the client and answers it.
+func (c *Conn) receiveClose(p []byte) error {
+	code, reason := CloseNoStatus, ""
+	switch {
+	case len(p) == 1:
+		return c.fail(&CloseError{CloseProtocolError, "truncated close code"})
+	case len(p) >= 2:
+		code = int(binary.BigEndian.Uint16(p))
+		reason = string(p[2:])
+		if !validCloseCode(code) {
+			return c.fail(&CloseError{CloseProtocolError, "invalid close code"})
+		}
+		if !utf8.ValidString(reason) {
+			return c.fail(&CloseError{CloseInvalidPayload, "close reason is not valid UTF-8"})
+		}
+	}
+	c.writeClose(code, "")
+	return &CloseError{code, reason}
+}
+
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1003, code >= 1007 && code <= 1014:
+		return true
+	default:
+		return code >= 3000 && code <= 4999
+	}
+}
+
+// WriteMessage sends data as a single message.
+func (c *Conn) WriteMessage(typ MessageType, data []byte) error {
+	c.msgMu.Lock()
+	defer c.msgMu.Unlock()
+	if c.compression.Load() {
+		p, err := deflate(data, c.level)
+		if err != nil {
+			return err
+		}
+		return c.writeFrame(true, rsv1, opcode(typ), p)
+	}
+	return c.writeFrame(true, 0, opcode(typ), data)
+}
+
+// NextWriter returns a writer for a message that is sent in fragments, every
+// Write sends one and Close ends the message. Other messages wait until it is
+// closed.
+func (c *Conn) NextWriter(typ MessageType) io.WriteCloser {
+	c.msgMu.Lock()
+	w := &messageWriter{c: c, op: opcode(typ)}
+	if c.compression.Load() {
+		w.rsv = rsv1
+		w.fw, w.err = getFlateWriter(&w.buf, c.level)
+	}
+	return w
+}
+
+// messageWriter sends the fragments of a message. A compressed message is
+// flushed with every Write, the empty block ending the output is held back
+// until the next one so Close can drop it.
+type messageWriter struct {
+	c      *Conn
+	op     opcode
+	rsv    byte
+	fw     *flate.Writer
+	buf    bytes.Buffer
+	err    error
+	closed bool
+}
+
+func (w *messageWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, ErrClosed
+	}
+	if w.err != nil {
+		return 0, w.err
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	payload := p
+	if w.fw != nil {
+		w.fw.Write(p)
+		w.fw.Flush()
+		payload = w.buf.Next(w.buf.Len() - len(deflateTail[:4]))
+	}
+	if err := w.c.writeFrame(false, w.rsv, w.op, payload); err != nil {
+		return 0, err
+	}
+	w.op, w.rsv = opContinuation, 0
+	return len(p), nil
+}
+
+func (w *messageWriter) Close() error {
+	if w.closed {
+		return ErrClosed
+	}
+	w.closed = true
+	defer w.c.msgMu.Unlock()
+	if w.err != nil {
+		return w.err
+	}
+	if w.fw != nil {
+		putFlateWriter(w.fw, w.c.level)
+		if w.rsv != 0 {
+			// An empty message still needs a compressed block.
+			p, err := deflate(nil, w.c.level)
+			if err != nil {
+				return err
+			}
+			return w.c.writeFrame(true, w.rsv, w.op, p)
+		}
+	}
+	return w.c.writeFrame(true, w.rsv, w.op, nil)
+}
+
+// Ping sends a ping, the client answers it with a pong.
+func (c *Conn) Ping(data []byte) error {
+	return c.writeControl(opPing, data)
+}
+
+func (c *Conn) writeControl(op opcode, data []byte) error {
+	if len(data) > maxControlPayload {
+		return errors.New("websocket: control frame payload too long")
+	}
+	return c.writeFrame(true, 0, op, data)
+}
+
+func (c *Conn) writeFrame(fin bool, rsv byte, op opcode, data []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closeSent {
+		return ErrClosed
+	}
+	if err := writeFrame(c.bw, fin, rsv, op, data, false); err != nil {
+		return err
+	}
+	return c.bw.Flush()
+}
+
+// writeClose sends a close frame, nothing can be sent after it.
+func (c *Conn) writeClose(code int, reason string) error {
+	var p []byte
+	if code != CloseNoStatus {
+		p = binary.BigEndian.AppendUint16(nil, uint16(code))
+		p = append(p, reason...)
+		if len(p) > maxControlPayload {
+			p = p[:maxControlPayload]
+		}
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closeSent {
+		return ErrClosed
+	}
+	c.closeSent = true
+	if err := writeFrame(c.bw, true, 0, opClose, p, false); err != nil {
+		return err
+	}
+	return c.bw.Flush()
+}
+
+// Close starts the closing handshake with code and reason and waits a while
+// for the client to answer. A ReadMessage in progress receives the answer
+// instead.
+func (c *Conn) Close(code int, reason string) error {
+	if err := c.writeClose(code, reason); err != nil {
+		return err
+	}
+	c.conn.SetReadDeadline(time.Now().Add(closeTimeout))
+	if !c.readMu.TryLock() {
+		return nil
+	}
+	defer c.readMu.Unlock()
+	for c.readErr == nil {
+		if _, _, err := c.readMessage(); err != nil {
+			c.readErr = err
+		}
+	}
+	return nil
+}
+
+// RemoteAddr returns the address of the client.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
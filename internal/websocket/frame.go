@@ -0,0 +1,145 @@
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+)
+
+type opcode byte
+
+const (
+	opContinuation opcode = 0x0
+	opText         opcode = 0x1
+	opBinary       opcode = 0x2
+	opClose        opcode = 0x8
+	opPing         opcode = 0x9
+	opPong         opcode = 0xa
+)
+
+// maxControlPayload is the largest payload of a close, ping or pong frame.
+const maxControlPayload = 125
+
+const (
+	finBit  = 0x80
+	rsvBits = 0x70
+	maskBit = 0x80
+)
+
+type frame struct {
+	fin     bool
+	rsv     byte
+	op      opcode
+	payload []byte
+}
+
+func (op opcode) control() bool {
+	return op&0x8 != 0
+}
+
+// readFrame reads a frame, masked tells whether the frame has to be masked
+// and limit is the largest payload accepted for a data frame.
+func readFrame(br *bufio.Reader, masked bool, limit int) (frame, error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(br, hdr[:]); err != nil {
+		return frame{}, err
+	}
+	f := frame{
+		fin: hdr[0]&finBit != 0,
+		rsv: hdr[0] & rsvBits,
+		op:  opcode(hdr[0] & 0x0f),
+	}
+	if (hdr[1]&maskBit != 0) != masked {
+		return f, &CloseError{CloseProtocolError, "frame masking does not match its sender"}
+	}
+
+	length := uint64(hdr[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(br, ext[:]); err != nil {
+			return f, unexpectedEOF(err)
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(br, ext[:]); err != nil {
+			return f, unexpectedEOF(err)
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+		if length>>63 != 0 {
+			return f, &CloseError{CloseProtocolError, "invalid payload length"}
+		}
+	}
+	if f.op.control() {
+		if length > maxControlPayload || !f.fin {
+			return f, &CloseError{CloseProtocolError, "invalid control frame"}
+		}
+	} else if length > uint64(max(limit, 0)) {
+		return f, &CloseError{CloseMessageTooBig, "message too big"}
+	}
+
+	var key [4]byte
+	if masked {
+		if _, err := io.ReadFull(br, key[:]); err != nil {
+			return f, unexpectedEOF(err)
+		}
+	}
+	f.payload = make([]byte, length)
+	if _, err := io.ReadFull(br, f.payload); err != nil {
+		return f, unexpectedEOF(err)
+	}
+	if masked {
+		maskBytes(key, f.payload)
+	}
+	return f, nil
+}
+
+// writeFrame writes a frame to w, with a random mask when mask is set.
+func writeFrame(w *bufio.Writer, fin bool, rsv byte, op opcode, payload []byte, mask bool) error {
+	b0 := byte(op) | rsv
+	if fin {
+		b0 |= finBit
+	}
+	var b1 byte
+	if mask {
+		b1 = maskBit
+	}
+	hdr := []byte{b0, b1}
+	switch n := len(payload); {
+	case n <= 125:
+		hdr[1] |= byte(n)
+	case n <= 0xffff:
+		hdr[1] |= 126
+		hdr = binary.BigEndian.AppendUint16(hdr, uint16(n))
+	default:
+		hdr[1] |= 127
+		hdr = binary.BigEndian.AppendUint64(hdr, uint64(n))
+	}
+	if mask {
+		var key [4]byte
+		rand.Read(key[:])
+		hdr = append(hdr, key[:]...)
+		payload = append([]byte(nil), payload...)
+		maskBytes(key, payload)
+	}
+	if _, err := w.Write(hdr); err != nil {
+		return err
+	}
+	_, err := w.Write(payload)
+	return err
+}
+
+func maskBytes(key [4]byte, p []byte) {
+	for i := range p {
+		p[i] ^= key[i%4]
+	}
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,180 @@
+// Package websocket implements the server side of the WebSocket protocol
+// defined in RFC 6455, on a connection taken over after the opening
+// handshake.
+package websocket
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/ramonvermeulen/httpfromtcp/internal/headers"
+	"github.com/ramonvermeulen/httpfromtcp/internal/request"
+	"github.com/ramonvermeulen/httpfromtcp/internal/response"
+)
+
+// Version is the protocol version of RFC 6455, the only one supported.
+const Version = "13"
+
+// DefaultMaxMessageSize limits received messages when Options sets no
+// limit.
+const DefaultMaxMessageSize = 1 << 20
+
+// acceptGUID is appended to the key of the client to compute the accept key.
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+type MessageType int
+
+const (
+	TextMessage   MessageType = 1
+	BinaryMessage MessageType = 2
+)
+
+// Close codes defined by RFC 6455 section 7.4.1.
+const (
+	CloseNormal          = 1000
+	CloseGoingAway       = 1001
+	CloseProtocolError   = 1002
+	CloseUnsupportedData = 1003
+	CloseNoStatus        = 1005
+	CloseAbnormal        = 1006
+	CloseInvalidPayload  = 1007
+	ClosePolicyViolation = 1008
+	CloseMessageTooBig   = 1009
+	CloseInternalError   = 1011
+)
+
+var ErrClosed = errors.New("websocket: connection closed")
+
+// CloseError is returned by reads once the connection is closed, Code is the
+// code of the close frame or CloseAbnormal when there was none.
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket: closed with %d %s", e.Code, e.Reason)
+}
+
+// HandshakeError rejects an opening handshake, Status is the status of the
+// response.
+type HandshakeError struct {
+	Status response.StatusCode
+	Reason string
+}
+
+func (e *HandshakeError) Error() string {
+	return "websocket: " + e.Reason
+}
+
+// Options configure a connection.
+type Options struct {
+	// MaxMessageSize limits the size of a received message, fragments
+	// included. Zero means DefaultMaxMessageSize. Larger messages close the
+	// connection with CloseMessageTooBig.
+	MaxMessageSize int
+	// CheckOrigin decides whether a handshake from a browser page is
+	// accepted. Nil accepts requests without an Origin header and those
+	// whose origin has the host the request was sent to.
+	CheckOrigin func(req *request.Request) bool
+	// EnableCompression accepts the permessage-deflate extension of RFC
+	// 7692 when the client offers it. Messages are then sent compressed
+	// until the handler turns it off with Conn.SetCompression.
+	EnableCompression bool
+	// CompressionLevel is the level of compress/flate messages are
+	// compressed with. Zero means flate.BestSpeed, handshakes fail with 500
+	// for a level compress/flate does not know.
+	CompressionLevel int
+}
+
+// Handshake is an accepted opening handshake, it holds what was negotiated
+// for the connection.
+type Handshake struct {
+	// Accept is the Sec-WebSocket-Accept value of the response.
+	Accept string
+	// Extensions is the Sec-WebSocket-Extensions value of the response,
+	// empty when no extension was negotiated.
+	Extensions string
+
+	opts    Options
+	deflate *deflateParams
+}
+
+// Headers returns the headers of the 101 response that completes the
+// handshake.
+func (hs *Handshake) Headers() headers.Headers {
+	h := headers.NewHeaders()
+	h.Set("Upgrade", "websocket")
+	h.Set("Connection", "Upgrade")
+	h.Set("Sec-WebSocket-Accept", hs.Accept)
+	if hs.Extensions != "" {
+		h.Set("Sec-WebSocket-Extensions", hs.Extensions)
+	}
+	return h
+}
+
+// AcceptKey returns the Sec-WebSocket-Accept value for the key of a client.
+func AcceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// CheckHandshake validates the opening handshake of req and negotiates the
+// extensions enabled by opts.
+func CheckHandshake(req *request.Request, opts Options) (*Handshake, error) {
+	if req.RequestLine.Method != "GET" {
+		return nil, &HandshakeError{response.StatusMethodNotAllowed, "handshake is not a GET request"}
+	}
+	if req.RequestLine.HTTPVersion != "1.1" {
+		return nil, &HandshakeError{response.StatusBadRequest, "handshake requires HTTP/1.1"}
+	}
+	upgrade, _ := req.Headers.Get("upgrade")
+	connection, _ := req.Headers.Get("connection")
+	if !headers.HasToken(upgrade, "websocket") || !headers.HasToken(connection, "upgrade") {
+		return nil, &HandshakeError{response.StatusUpgradeRequired, "missing Upgrade: websocket"}
+	}
+	if version, _ := req.Headers.Get("sec-websocket-version"); version != Version {
+		return nil, &HandshakeError{response.StatusUpgradeRequired, "unsupported version " + version}
+	}
+	key, _ := req.Headers.Get("sec-websocket-key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		return nil, &HandshakeError{response.StatusBadRequest, "invalid Sec-WebSocket-Key"}
+	}
+	checkOrigin := opts.CheckOrigin
+	if checkOrigin == nil {
+		checkOrigin = sameOrigin
+	}
+	if !checkOrigin(req) {
+		return nil, &HandshakeError{response.StatusForbidden, "origin not allowed"}
+	}
+	if opts.EnableCompression && opts.CompressionLevel != 0 && !validLevel(opts.CompressionLevel) {
+		return nil, &HandshakeError{response.StatusError, "invalid compression level"}
+	}
+	hs := &Handshake{Accept: AcceptKey(key), opts: opts}
+	if offers, ok := req.Headers.Get("sec-websocket-extensions"); ok && opts.EnableCompression {
+		if p, ok := acceptDeflate(offers); ok {
+			hs.deflate = &p
+			hs.Extensions = p.String()
+		}
+	}
+	return hs, nil
+}
+
+// sameOrigin accepts requests without an Origin header and those from a page
+// on the host the request was sent to.
+func sameOrigin(req *request.Request) bool {
+	origin, ok := req.Headers.Get("origin")
+	if !ok {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	host, _ := req.Headers.Get("host")
+	return strings.EqualFold(u.Host, host)
+}
@@ -0,0 +1,392 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ramonvermeulen/httpfromtcp/internal/headers"
+	"github.com/ramonvermeulen/httpfromtcp/internal/request"
+	"github.com/ramonvermeulen/httpfromtcp/internal/response"
+)
+
+func TestAcceptKey(t *testing.T) {
+	// Test: example from RFC 6455 section 1.3
+	assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", AcceptKey("dGhlIHNhbXBsZSBub25jZQ=="))
+}
+
+func handshakeRequest() *request.Request {
+	return &request.Request{
+		RequestLine: request.RequestLine{Method: "GET", RequestTarget: "/ws", HTTPVersion: "1.1"},
+		Headers: headers.Headers{
+			"host":                  "example.com",
+			"upgrade":               "websocket",
+			"connection":            "keep-alive, Upgrade",
+			"sec-websocket-key":     "dGhlIHNhbXBsZSBub25jZQ==",
+			"sec-websocket-version": "13",
+		},
+	}
+}
+
+func TestCheckHandshake(t *testing.T) {
+	// Test: a valid handshake returns the accept key
+	hs, err := CheckHandshake(handshakeRequest(), Options{})
+	require.NoError(t, err)
+	assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", hs.Accept)
+
+	// Test: compression is only negotiated when enabled
+	req := handshakeRequest()
+	req.Headers["sec-websocket-extensions"] = "permessage-deflate; client_max_window_bits"
+	hs, err = CheckHandshake(req, Options{})
+	require.NoError(t, err)
+	assert.Empty(t, hs.Extensions)
+	hs, err = CheckHandshake(req, Options{EnableCompression: true})
+	require.NoError(t, err)
+	assert.Equal(t, "permessage-deflate; server_no_context_takeover", hs.Extensions)
+	assert.Equal(t, hs.Extensions, hs.Headers()["sec-websocket-extensions"])
+
+	// Test: an unknown compression level fails the handshake
+	_, err = CheckHandshake(req, Options{EnableCompression: true, CompressionLevel: 10})
+	var hsErr *HandshakeError
+	if assert.ErrorAs(t, err, &hsErr) {
+		assert.Equal(t, response.StatusError, hsErr.Status)
+	}
+
+	// Test: same origin is accepted
+	req = handshakeRequest()
+	req.Headers["origin"] = "https://example.com"
+	_, err = CheckHandshake(req, Options{})
+	assert.NoError(t, err)
+
+	// Test: invalid handshakes are rejected with a status
+	for _, tc := range []struct {
+		modify func(req *request.Request)
+		status response.StatusCode
+	}{
+		{func(req *request.Request) { req.RequestLine.Method = "POST" }, response.StatusMethodNotAllowed},
+		{func(req *request.Request) { req.RequestLine.HTTPVersion = "1.0" }, response.StatusBadRequest},
+		{func(req *request.Request) { delete(req.Headers, "upgrade") }, response.StatusUpgradeRequired},
+		{func(req *request.Request) { req.Headers["connection"] = "keep-alive" }, response.StatusUpgradeRequired},
+		{func(req *request.Request) { req.Headers["sec-websocket-version"] = "8" }, response.StatusUpgradeRequired},
+		{func(req *request.Request) { req.Headers["sec-websocket-key"] = "c2hvcnQ=" }, response.StatusBadRequest},
+		{func(req *request.Request) { req.Headers["origin"] = "https://evil.example" }, response.StatusForbidden},
+	} {
+		req := handshakeRequest()
+		tc.modify(req)
+		_, err := CheckHandshake(req, Options{})
+		var hsErr *HandshakeError
+		if assert.ErrorAs(t, err, &hsErr) {
+			assert.Equal(t, tc.status, hsErr.Status)
+		}
+	}
+}
+
+// peer is the client end of a connection, it reads and writes raw frames.
+type peer struct {
+	t    *testing.T
+	conn net.Conn
+	br   *bufio.Reader
+
+	// mu is held while frames are written in the background.
+	mu sync.Mutex
+	bw *bufio.Writer
+}
+
+func newPair(t *testing.T, opts Options) (*Conn, *peer) {
+	return newHandshakePair(t, &Handshake{opts: opts})
+}
+
+func newHandshakePair(t *testing.T, hs *Handshake) (*Conn, *peer) {
+	client, server := net.Pipe()
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return NewConn(server, bufio.NewReader(server), hs),
+		&peer{t: t, conn: client, br: bufio.NewReader(client), bw: bufio.NewWriter(client)}
+}
+
+// send writes masked frames like a client does.
+func (p *peer) send(frames ...frame) {
+	p.write(true, frames...)
+}
+
+// write writes frames in the background, the pipe blocks until they are
+// read.
+func (p *peer) write(mask bool, frames ...frame) {
+	go func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		for _, f := range frames {
+			writeFrame(p.bw, f.fin, f.rsv, f.op, f.payload, mask)
+		}
+		p.bw.Flush()
+	}()
+}
+
+func (p *peer) read() frame {
+	p.t.Helper()
+	f, err := readFrame(p.br, false, 1<<20)
+	require.NoError(p.t, err)
+	return f
+}
+
+func closePayload(code int, reason string) []byte {
+	return append(binary.BigEndian.AppendUint16(nil, uint16(code)), reason...)
+}
+
+func TestConn_Messages(t *testing.T) {
+	c, p := newPair(t, Options{})
+
+	// Test: masked text and binary messages are read
+	p.send(frame{fin: true, op: opText, payload: []byte("hello")})
+	typ, msg, err := c.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, TextMessage, typ)
+	assert.Equal(t, "hello", string(msg))
+	p.send(frame{fin: true, op: opBinary, payload: []byte{0, 1, 2}})
+	typ, msg, err = c.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, BinaryMessage, typ)
+	assert.Equal(t, []byte{0, 1, 2}, msg)
+
+	// Test: fragments are joined and pings between them are answered
+	p.send(
+		frame{fin: false, op: opText, payload: []byte("frag")},
+		frame{fin: true, op: opPing, payload: []byte("are you there")},
+		frame{fin: false, op: opContinuation, payload: []byte("men")},
+		frame{fin: true, op: opContinuation, payload: []byte("ted")},
+	)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		typ, msg, err := c.ReadMessage()
+		assert.NoError(t, err)
+		assert.Equal(t, TextMessage, typ)
+		assert.Equal(t, "fragmented", string(msg))
+	}()
+	f := p.read()
+	assert.Equal(t, opPong, f.op)
+	assert.Equal(t, "are you there", string(f.payload))
+	<-done
+
+	// Test: messages are sent unmasked, large ones with an extended length
+	go c.WriteMessage(BinaryMessage, bytes.Repeat([]byte("x"), 70000))
+	f = p.read()
+	assert.True(t, f.fin)
+	assert.Equal(t, opBinary, f.op)
+	assert.Len(t, f.payload, 70000)
+
+	// Test: NextWriter sends a message in fragments
+	go func() {
+		w := c.NextWriter(TextMessage)
+		w.Write([]byte("one "))
+		w.Write([]byte("two"))
+		w.Close()
+	}()
+	f = p.read()
+	assert.Equal(t, frame{fin: false, op: opText, payload: []byte("one ")}, f)
+	f = p.read()
+	assert.Equal(t, frame{fin: false, op: opContinuation, payload: []byte("two")}, f)
+	f = p.read()
+	assert.Equal(t, frame{fin: true, op: opContinuation, payload: []byte{}}, f)
+}
+
+func TestConn_ProtocolErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		opts     Options
+		f        frame
+		unmasked bool
+		code     int
+	}{
+		{"unmasked frame", Options{}, frame{fin: true, op: opText, payload: []byte("hi")}, true, CloseProtocolError},
+		{"invalid UTF-8", Options{}, frame{fin: true, op: opText, payload: []byte{0xff, 0xfe}}, false, CloseInvalidPayload},
+		{"message too big", Options{MaxMessageSize: 4}, frame{fin: true, op: opBinary, payload: []byte("hello")}, false, CloseMessageTooBig},
+		{"continuation without message", Options{}, frame{fin: true, op: opContinuation, payload: []byte("x")}, false, CloseProtocolError},
+		{"fragmented ping", Options{}, frame{fin: false, op: opPing}, false, CloseProtocolError},
+		{"unknown opcode", Options{}, frame{fin: true, op: opcode(3)}, false, CloseProtocolError},
+		{"invalid close code", Options{}, frame{fin: true, op: opClose, payload: closePayload(1005, "")}, false, CloseProtocolError},
+	} {
+		c, p := newPair(t, tc.opts)
+		p.write(!tc.unmasked, tc.f)
+
+		// Test: the violation closes the connection with its code
+		errs := make(chan error, 1)
+		go func() {
+			_, _, err := c.ReadMessage()
+			errs <- err
+		}()
+		f := p.read()
+		assert.Equal(t, opClose, f.op, tc.name)
+		assert.Equal(t, tc.code, int(binary.BigEndian.Uint16(f.payload)), tc.name)
+		var closeErr *CloseError
+		if assert.ErrorAs(t, <-errs, &closeErr, tc.name) {
+			assert.Equal(t, tc.code, closeErr.Code, tc.name)
+		}
+		assert.ErrorIs(t, c.WriteMessage(TextMessage, []byte("late")), ErrClosed, tc.name)
+	}
+}
+
+func TestConn_Close(t *testing.T) {
+	// Test: a close frame from the client is answered
+	c, p := newPair(t, Options{})
+	p.send(frame{fin: true, op: opClose, payload: closePayload(CloseNormal, "bye")})
+	errs := make(chan error, 1)
+	go func() {
+		_, _, err := c.ReadMessage()
+		errs <- err
+	}()
+	f := p.read()
+	assert.Equal(t, opClose, f.op)
+	assert.Equal(t, closePayload(CloseNormal, ""), f.payload)
+	assert.Equal(t, &CloseError{CloseNormal, "bye"}, <-errs)
+
+	// Test: Close waits for the answer of the client
+	c, p = newPair(t, Options{})
+	closed := make(chan error, 1)
+	go func() { closed <- c.Close(CloseGoingAway, "restart") }()
+	f = p.read()
+	assert.Equal(t, closePayload(CloseGoingAway, "restart"), f.payload)
+	p.send(frame{fin: true, op: opClose, payload: closePayload(CloseGoingAway, "")})
+	assert.NoError(t, <-closed)
+	_, _, err := c.ReadMessage()
+	assert.Equal(t, &CloseError{CloseGoingAway, ""}, err)
+}
+
+func TestAcceptDeflate(t *testing.T) {
+	for _, tc := range []struct {
+		offers string
+		want   string
+	}{
+		{"permessage-deflate", "permessage-deflate; server_no_context_takeover"},
+		{"permessage-deflate; client_max_window_bits", "permessage-deflate; server_no_context_takeover"},
+		{"permessage-deflate; client_max_window_bits=10", "permessage-deflate; server_no_context_takeover"},
+		{"permessage-deflate; client_no_context_takeover; server_no_context_takeover", "permessage-deflate; server_no_context_takeover; client_no_context_takeover"},
+		{`permessage-deflate; server_max_window_bits="15"`, "permessage-deflate; server_no_context_takeover; server_max_window_bits=15"},
+		{"permessage-deflate; server_max_window_bits=10, permessage-deflate", "permessage-deflate; server_no_context_takeover"},
+		{"permessage-deflate; server_max_window_bits", ""},
+		{"permessage-deflate; client_max_window_bits=7", ""},
+		{"permessage-deflate; client_no_context_takeover=1", ""},
+		{"permessage-deflate; server_no_context_takeover; server_no_context_takeover", ""},
+		{"permessage-deflate; unknown", ""},
+		{"x-webkit-deflate-frame", ""},
+	} {
+		// Test: the first acceptable offer is negotiated
+		p, ok := acceptDeflate(tc.offers)
+		if tc.want == "" {
+			assert.False(t, ok, tc.offers)
+			continue
+		}
+		if assert.True(t, ok, tc.offers) {
+			assert.Equal(t, tc.want, p.String(), tc.offers)
+		}
+	}
+}
+
+// inflateFrames decompresses the payloads of the frames of a message.
+func inflateFrames(t *testing.T, frames ...frame) string {
+	t.Helper()
+	var p []byte
+	for _, f := range frames {
+		p = append(p, f.payload...)
+	}
+	msg, err := io.ReadAll(flate.NewReader(io.MultiReader(bytes.NewReader(p), bytes.NewReader(deflateTail))))
+	require.NoError(t, err)
+	return string(msg)
+}
+
+func TestConn_Compression(t *testing.T) {
+	hello := []byte{0xf2, 0x48, 0xcd, 0xc9, 0xc9, 0x07, 0x00}
+	c, p := newHandshakePair(t, &Handshake{deflate: &deflateParams{}})
+
+	// Test: compressed messages from RFC 7692 section 7.2.3 are read, the
+	// second one refers to the first
+	p.send(
+		frame{fin: true, rsv: rsv1, op: opText, payload: hello},
+		frame{fin: true, rsv: rsv1, op: opText, payload: []byte{0xf2, 0x00, 0x11, 0x00, 0x00}},
+	)
+	for range 2 {
+		typ, msg, err := c.ReadMessage()
+		require.NoError(t, err)
+		assert.Equal(t, TextMessage, typ)
+		assert.Equal(t, "Hello", string(msg))
+	}
+
+	// Test: a compressed message is read from fragments
+	p.send(
+		frame{fin: false, rsv: rsv1, op: opText, payload: hello[:3]},
+		frame{fin: true, op: opContinuation, payload: hello[3:]},
+	)
+	_, msg, err := c.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, "Hello", string(msg))
+
+	// Test: messages are sent compressed
+	go c.WriteMessage(TextMessage, bytes.Repeat([]byte("Hello"), 100))
+	f := p.read()
+	assert.Equal(t, byte(rsv1), f.rsv)
+	assert.Less(t, len(f.payload), 100)
+	assert.Equal(t, strings.Repeat("Hello", 100), inflateFrames(t, f))
+
+	// Test: NextWriter compresses across fragments, only the first has RSV1
+	go func() {
+		w := c.NextWriter(TextMessage)
+		w.Write([]byte("one "))
+		w.Write([]byte("two"))
+		w.Close()
+	}()
+	frames := []frame{p.read(), p.read(), p.read()}
+	assert.Equal(t, []byte{rsv1, 0, 0}, []byte{frames[0].rsv, frames[1].rsv, frames[2].rsv})
+	assert.Equal(t, "one two", inflateFrames(t, frames...))
+
+	// Test: an empty message is compressed to a single block
+	go c.NextWriter(BinaryMessage).Close()
+	f = p.read()
+	assert.Equal(t, byte(rsv1), f.rsv)
+	assert.Equal(t, "", inflateFrames(t, f))
+
+	// Test: compression can be turned off for the connection
+	c.SetCompression(false)
+	go c.WriteMessage(TextMessage, []byte("plain"))
+	f = p.read()
+	assert.Equal(t, frame{fin: true, op: opText, payload: []byte("plain")}, f)
+
+	for _, tc := range []struct {
+		name   string
+		hs     *Handshake
+		frames []frame
+		code   int
+	}{
+		{"not negotiated", &Handshake{}, []frame{{fin: true, rsv: rsv1, op: opText, payload: hello}}, CloseProtocolError},
+		{"RSV1 on a continuation", &Handshake{deflate: &deflateParams{}}, []frame{{op: opText, payload: []byte("a")}, {fin: true, rsv: rsv1, op: opContinuation, payload: hello}}, CloseProtocolError},
+		{"RSV1 on a ping", &Handshake{deflate: &deflateParams{}}, []frame{{fin: true, rsv: rsv1, op: opPing}}, CloseProtocolError},
+		{"invalid data", &Handshake{deflate: &deflateParams{}}, []frame{{fin: true, rsv: rsv1, op: opBinary, payload: []byte{0xff, 0xff}}}, CloseInvalidPayload},
+		{"too big", &Handshake{opts: Options{MaxMessageSize: 4}, deflate: &deflateParams{}}, []frame{{fin: true, rsv: rsv1, op: opText, payload: hello}}, CloseMessageTooBig},
+	} {
+		// Test: invalid compressed messages close the connection
+		c, p := newHandshakePair(t, tc.hs)
+		p.send(tc.frames...)
+		go c.ReadMessage()
+		f := p.read()
+		assert.Equal(t, opClose, f.op, tc.name)
+		assert.Equal(t, tc.code, int(binary.BigEndian.Uint16(f.payload)), tc.name)
+	}
+
+	// Test: an unknown compression level fails writes instead of panicking
+	c, _ = newHandshakePair(t, &Handshake{deflate: &deflateParams{}, opts: Options{CompressionLevel: 10}})
+	assert.Error(t, c.WriteMessage(TextMessage, []byte("x")))
+	w := c.NextWriter(TextMessage)
+	_, err = w.Write([]byte("x"))
+	assert.Error(t, err)
+	assert.Error(t, w.Close())
+}